@@ -13,6 +13,8 @@ import (
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
 
+	"github.com/MichiganDiningAPI/api/mdining/responsearchive"
+	"github.com/MichiganDiningAPI/api/mdining/schemawatch"
 	"github.com/MichiganDiningAPI/internal/util/date"
 	pb "github.com/anders617/mdining-proto/proto/mdining"
 	"github.com/anders617/mdining-proto/proto/mdiningapi2"
@@ -37,6 +39,10 @@ func urlFrom(s string) *url.URL {
 type MDiningClient2 struct {
 	client *http.Client
 	apiKey string
+	// Optional collector recording the fields of every upstream response
+	schema *schemawatch.Collector
+	// Optional archive storing every raw upstream response
+	archive *responsearchive.Archive
 }
 
 func New(apiKey string) *MDiningClient2 {
@@ -46,6 +52,21 @@ func New(apiKey string) *MDiningClient2 {
 	return mc
 }
 
+// SetSchemaCollector - Record the JSON fields of every upstream response in c
+func (m *MDiningClient2) SetSchemaCollector(c *schemawatch.Collector) {
+	m.schema = c
+}
+
+// SetArchive - Store the raw body of every upstream response in a
+func (m *MDiningClient2) SetArchive(a *responsearchive.Archive) {
+	m.archive = a
+}
+
+// SetTransport - Send requests through rt instead of the default transport (e.g. to replay an archive)
+func (m *MDiningClient2) SetTransport(rt http.RoundTripper) {
+	m.client.Transport = rt
+}
+
 func (m *MDiningClient2) getPB(url string, reply proto.Message, preprocess func(string) string) error {
 	res, err := m.client.Get(url)
 	if err != nil {
@@ -57,6 +78,12 @@ func (m *MDiningClient2) getPB(url string, reply proto.Message, preprocess func(
 	if err1 != nil {
 		return err1
 	}
+	if m.schema != nil {
+		m.schema.Observe(schemawatch.EndpointFromURL(url), b)
+	}
+	if m.archive != nil {
+		m.archive.Record(url, b)
+	}
 	s := string(b)
 	um := jsonpb.Unmarshaler{AllowUnknownFields: true}
 	s = preprocess(s)
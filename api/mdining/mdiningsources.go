@@ -0,0 +1,128 @@
+package mdiningsources
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MichiganDiningAPI/api/mdining/mdiningclient"
+	"github.com/MichiganDiningAPI/api/mdining/mdiningclient2"
+	"github.com/MichiganDiningAPI/api/mdining/responsearchive"
+	"github.com/MichiganDiningAPI/api/mdining/schemawatch"
+	"github.com/MichiganDiningAPI/internal/processing/menumerge"
+	"github.com/golang/glog"
+)
+
+//
+// Fetching of dining halls and menus from each upstream source
+//
+
+const (
+	// Legacy - Source backed by the legacy mobile.its.umich.edu endpoints (mdiningclient)
+	Legacy = "legacy"
+	// WebPlatforms - Source backed by the webplatforms endpoints (mdiningclient2)
+	WebPlatforms = "webplatforms"
+)
+
+// Options - Optional hooks applied to every client
+type Options struct {
+	// Transport used for upstream requests, nil for the default
+	Transport http.RoundTripper
+	// Collector recording the fields of upstream responses
+	Schema *schemawatch.Collector
+	// Archive storing raw upstream responses
+	Archive *responsearchive.Archive
+}
+
+// Dates - Returns numDays consecutive days starting at start
+func Dates(start time.Time, numDays int) []time.Time {
+	oneDay, _ := time.ParseDuration("24h")
+	currentDate := start
+	dates := []time.Time{}
+	for i := 0; i < numDays; i++ {
+		dates = append(dates, currentDate)
+		currentDate = currentDate.Add(oneDay)
+	}
+	return dates
+}
+
+// Fetch - Fetches every named source concurrently, results are in the same order as names
+func Fetch(names []string, apiKey string, dates []time.Time, opts Options) []*menumerge.Source {
+	sources := make([]*menumerge.Source, len(names))
+	wg := sync.WaitGroup{}
+	for idx, name := range names {
+		wg.Add(1)
+		go func(idx int, name string) {
+			defer wg.Done()
+			switch name {
+			case Legacy:
+				sources[idx] = FetchLegacy(opts)
+			case WebPlatforms:
+				sources[idx] = FetchWebPlatforms(apiKey, dates, opts)
+			default:
+				sources[idx] = &menumerge.Source{Name: name, Err: errors.New("unknown source")}
+			}
+		}(idx, name)
+	}
+	wg.Wait()
+	return sources
+}
+
+// FetchLegacy - Fetches all dining halls and menus using mdiningclient
+func FetchLegacy(opts Options) *menumerge.Source {
+	source := &menumerge.Source{Name: Legacy}
+	mdining := mdiningclient.New()
+	if opts.Transport != nil {
+		mdining.SetTransport(opts.Transport)
+	}
+	mdining.SetSchemaCollector(opts.Schema)
+	mdining.SetArchive(opts.Archive)
+	diningHallsByCampus, err := mdining.GetDiningHallList()
+	if err != nil {
+		source.Err = err
+		return source
+	}
+	for campus, diningHalls := range *diningHallsByCampus {
+		glog.Infof("Received campus: %s", campus)
+		source.DiningHalls = append(source.DiningHalls, diningHalls.DiningHalls...)
+		m, err := mdining.GetAllMenus(diningHalls)
+		if err != nil {
+			source.Err = err
+			return source
+		}
+		source.Menus = append(source.Menus, *m...)
+	}
+	return source
+}
+
+// FetchWebPlatforms - Fetches dining halls and menus for the given dates using mdiningclient2
+func FetchWebPlatforms(apiKey string, dates []time.Time, opts Options) *menumerge.Source {
+	source := &menumerge.Source{Name: WebPlatforms}
+	if apiKey == "" {
+		source.Err = errors.New("missing api_key")
+		return source
+	}
+	client := mdiningclient2.New(apiKey)
+	if opts.Transport != nil {
+		client.SetTransport(opts.Transport)
+	}
+	client.SetSchemaCollector(opts.Schema)
+	client.SetArchive(opts.Archive)
+	diningHallsByCampus, partialMenus, err := client.GetDiningHallList(dates)
+	if err != nil {
+		source.Err = err
+		return source
+	}
+	for campus, diningHalls := range *diningHallsByCampus {
+		glog.Infof("Received campus: %s", campus)
+		source.DiningHalls = append(source.DiningHalls, diningHalls.DiningHalls...)
+	}
+	menus, err := client.GetAllMenus(partialMenus)
+	if err != nil {
+		source.Err = err
+		return source
+	}
+	source.Menus = *menus
+	return source
+}
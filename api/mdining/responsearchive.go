@@ -0,0 +1,224 @@
+package responsearchive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MichiganDiningAPI/internal/util/date"
+	"github.com/golang/glog"
+)
+
+//
+// Archive of raw upstream responses
+//
+// Every response body is gzipped and stored under a key of the form
+//     {endpoint}/{dining hall}/{date}/{fetch time}[.{other params}].json.gz
+// so that menus can be re-derived later without calling upstream. A replay
+// transport serves archived responses to the regular mdining clients so
+// reprocessing goes through exactly the same parsing code as a live fetch.
+//
+
+// FetchTimeLayout - Layout of the fetch time component of archive keys
+const FetchTimeLayout = "20060102T150405Z"
+
+const archiveSuffix = ".json.gz"
+
+// Query params which are part of the key path rather than the file name.
+// "key" is the api key and "_type" is constant so neither is stored.
+var keyParams = map[string]bool{"key": true, "_type": true, "location": true, "diningHall": true, "date": true}
+
+// Store - Blob storage for archived responses (a local directory or an object store)
+type Store interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	List() ([]string, error)
+}
+
+// DirStore - Store backed by a local directory, keys map to relative paths
+type DirStore struct {
+	root string
+}
+
+// NewDirStore - Create a Store rooted at the given directory
+func NewDirStore(root string) *DirStore {
+	return &DirStore{root: root}
+}
+
+func (d *DirStore) Put(key string, data []byte) error {
+	path := filepath.Join(d.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func (d *DirStore) Get(key string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(d.root, filepath.FromSlash(key)))
+}
+
+func (d *DirStore) List() ([]string, error) {
+	keys := []string{}
+	err := filepath.Walk(d.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, archiveSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(d.root, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	return keys, err
+}
+
+// Archive - Records responses for a single fetch run
+type Archive struct {
+	store     Store
+	fetchTime time.Time
+}
+
+// New - Create an Archive recording responses under the given fetch time
+func New(store Store, fetchTime time.Time) *Archive {
+	return &Archive{store: store, fetchTime: fetchTime}
+}
+
+// Record - Compresses and stores a response body, errors are logged and otherwise ignored
+func (a *Archive) Record(rawURL string, body []byte) {
+	key := Key(rawURL, a.fetchTime)
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		glog.Errorf("Failed to compress response %s: %s", key, err)
+		return
+	}
+	if err := w.Close(); err != nil {
+		glog.Errorf("Failed to compress response %s: %s", key, err)
+		return
+	}
+	if err := a.store.Put(key, buf.Bytes()); err != nil {
+		glog.Errorf("Failed to archive response %s: %s", key, err)
+	}
+}
+
+// Key - Returns the archive key for a request url fetched at fetchTime
+func Key(rawURL string, fetchTime time.Time) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		u = &url.URL{Path: rawURL}
+	}
+	params := u.Query()
+	hall := params.Get("diningHall")
+	if hall == "" {
+		hall = params.Get("location")
+	}
+	if hall == "" {
+		hall = "all"
+	}
+	day := "all"
+	if d := params.Get("date"); d != "" {
+		// The webplatforms api uses dd-MM-yyyy, store as yyyy-MM-dd so keys sort by date
+		if t, err := time.ParseInLocation(date.MDiningAPINoTimeLayout, d, date.USEasternLocation); err == nil {
+			day = date.FormatNoTime(t)
+		} else {
+			day = d
+		}
+	}
+	name := fetchTime.UTC().Format(FetchTimeLayout)
+	other := url.Values{}
+	for param, values := range params {
+		if !keyParams[param] {
+			other[param] = values
+		}
+	}
+	if len(other) > 0 {
+		name += "." + other.Encode()
+	}
+	segments := []string{u.Host + u.Path, hall, day, name + archiveSuffix}
+	for idx, segment := range segments {
+		segments[idx] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// FetchTimes - Returns the distinct fetch runs present in the store, oldest first
+func FetchTimes(store Store) ([]time.Time, error) {
+	keys, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	times := []time.Time{}
+	for _, key := range keys {
+		name := key[strings.LastIndex(key, "/")+1:]
+		if idx := strings.IndexAny(name, "."); idx >= 0 {
+			name = name[:idx]
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		t, err := time.Parse(FetchTimeLayout, name)
+		if err != nil {
+			glog.Warningf("Skipping archive key with unparsable fetch time %s", key)
+			continue
+		}
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times, nil
+}
+
+// ReplayTransport - http.RoundTripper serving archived responses from a single fetch run
+type ReplayTransport struct {
+	store     Store
+	fetchTime time.Time
+}
+
+// NewReplayTransport - Create a transport replaying the run fetched at fetchTime
+func NewReplayTransport(store Store, fetchTime time.Time) *ReplayTransport {
+	return &ReplayTransport{store: store, fetchTime: fetchTime}
+}
+
+func (r *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := Key(req.URL.String(), r.fetchTime)
+	res := &http.Response{
+		Request:    req,
+		Header:     http.Header{},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}
+	data, err := r.store.Get(key)
+	if err != nil {
+		glog.Warningf("No archived response for %s", key)
+		res.StatusCode = http.StatusNotFound
+		res.Status = "404 Not Found"
+		res.Body = ioutil.NopCloser(strings.NewReader(""))
+		return res, nil
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return nil, err
+	}
+	res.StatusCode = http.StatusOK
+	res.Status = "200 OK"
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	return res, nil
+}
@@ -0,0 +1,233 @@
+package schemawatch
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+//
+// Upstream schema drift monitoring
+//
+// The mdining clients unmarshal with AllowUnknownFields so renamed or added
+// upstream fields are silently ignored. A Collector records every JSON field
+// path (e.g. "menu.category[].menuItem[].name") and the JSON types seen for it
+// per endpoint so that each run can be compared against a stored baseline.
+//
+// Many fields are optional (e.g. fields of categories are absent from responses
+// for meals without categories), so the number of responses each field appeared
+// in is recorded too. A baseline field is only reported missing when it was
+// present in every baseline response.
+//
+
+// Schema - The set of fields and types seen for an endpoint
+type Schema struct {
+	Endpoint string `json:"endpoint"`
+	// Map from field path to the sorted JSON types seen for it.
+	// Fields which were only ever null have no types.
+	Fields map[string][]string `json:"fields"`
+	// Number of responses observed
+	Responses int64 `json:"responses,omitempty"`
+	// Map from field path to the number of responses it appeared in
+	Presence  map[string]int64 `json:"presence,omitempty"`
+	UpdatedAt string           `json:"updatedAt"`
+}
+
+// Required - Whether field appeared in every response of the schema. Baselines stored before presence was
+// recorded treat every field as required.
+func (s *Schema) Required(field string) bool {
+	if s.Responses == 0 {
+		return true
+	}
+	return s.Presence[field] == s.Responses
+}
+
+// TypeChange - A field whose JSON types differ from the baseline
+type TypeChange struct {
+	Field    string   `json:"field"`
+	Baseline []string `json:"baseline"`
+	Current  []string `json:"current"`
+}
+
+// Drift - Differences between a baseline schema and the current run
+type Drift struct {
+	Endpoint      string       `json:"endpoint"`
+	NewFields     []string     `json:"newFields,omitempty"`
+	MissingFields []string     `json:"missingFields,omitempty"`
+	TypeChanges   []TypeChange `json:"typeChanges,omitempty"`
+	// True if there was no baseline to compare against
+	NoBaseline bool `json:"noBaseline,omitempty"`
+}
+
+// HasDrift - Whether anything changed relative to the baseline
+func (d *Drift) HasDrift() bool {
+	return len(d.NewFields) > 0 || len(d.MissingFields) > 0 || len(d.TypeChanges) > 0
+}
+
+// Collector - Accumulates schemas from upstream responses
+type Collector struct {
+	// Map from endpoint to field path to set of types
+	fields map[string]map[string]map[string]bool
+	// Map from endpoint to number of responses
+	responses map[string]int64
+	// Map from endpoint to field path to number of responses containing it
+	presence map[string]map[string]int64
+	mu       sync.Mutex
+}
+
+// NewCollector - Create an empty Collector
+func NewCollector() *Collector {
+	return &Collector{
+		fields:    map[string]map[string]map[string]bool{},
+		responses: map[string]int64{},
+		presence:  map[string]map[string]int64{},
+	}
+}
+
+// EndpointFromURL - Returns the endpoint name (host and path without query) for a request url
+func EndpointFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Host + u.Path
+}
+
+// Observe - Records the fields of a JSON response body for the given endpoint
+func (c *Collector) Observe(endpoint string, body []byte) {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		glog.Warningf("Could not observe schema for %s: %s", endpoint, err)
+		return
+	}
+	seen := map[string]map[string]bool{}
+	walk(seen, "", v)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fields, exists := c.fields[endpoint]
+	if !exists {
+		fields = map[string]map[string]bool{}
+		c.fields[endpoint] = fields
+		c.presence[endpoint] = map[string]int64{}
+	}
+	c.responses[endpoint]++
+	for path, types := range seen {
+		if _, exists := fields[path]; !exists {
+			fields[path] = map[string]bool{}
+		}
+		for t := range types {
+			fields[path][t] = true
+		}
+		c.presence[endpoint][path]++
+	}
+}
+
+func walk(fields map[string]map[string]bool, path string, v interface{}) {
+	if path != "" {
+		if _, exists := fields[path]; !exists {
+			fields[path] = map[string]bool{}
+		}
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if path != "" {
+			fields[path]["object"] = true
+		}
+		for key, child := range val {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			walk(fields, childPath, child)
+		}
+	case []interface{}:
+		if path != "" {
+			fields[path]["array"] = true
+		}
+		for _, child := range val {
+			walk(fields, path+"[]", child)
+		}
+	case string:
+		fields[path]["string"] = true
+	case float64:
+		fields[path]["number"] = true
+	case bool:
+		fields[path]["bool"] = true
+	}
+}
+
+// Schemas - Returns the schemas collected so far, sorted by endpoint
+func (c *Collector) Schemas(updatedAt string) []*Schema {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	schemas := make([]*Schema, 0, len(c.fields))
+	for endpoint, fields := range c.fields {
+		schema := &Schema{
+			Endpoint:  endpoint,
+			Fields:    map[string][]string{},
+			Responses: c.responses[endpoint],
+			Presence:  map[string]int64{},
+			UpdatedAt: updatedAt,
+		}
+		for path, types := range fields {
+			schema.Fields[path] = sortedKeys(types)
+			schema.Presence[path] = c.presence[endpoint][path]
+		}
+		schemas = append(schemas, schema)
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Endpoint < schemas[j].Endpoint })
+	return schemas
+}
+
+// Diff - Compares the current schema against a baseline (which may be nil). Fields are only reported
+// missing if they were required in the baseline.
+func Diff(baseline *Schema, current *Schema) *Drift {
+	drift := &Drift{Endpoint: current.Endpoint}
+	if baseline == nil {
+		drift.NoBaseline = true
+		return drift
+	}
+	for field, types := range current.Fields {
+		baseTypes, exists := baseline.Fields[field]
+		if !exists {
+			drift.NewFields = append(drift.NewFields, field)
+			continue
+		}
+		if !equal(baseTypes, types) {
+			drift.TypeChanges = append(drift.TypeChanges, TypeChange{Field: field, Baseline: baseTypes, Current: types})
+		}
+	}
+	for field := range baseline.Fields {
+		if _, exists := current.Fields[field]; !exists && baseline.Required(field) {
+			drift.MissingFields = append(drift.MissingFields, field)
+		}
+	}
+	sort.Strings(drift.NewFields)
+	sort.Strings(drift.MissingFields)
+	sort.Slice(drift.TypeChanges, func(i, j int) bool { return drift.TypeChanges[i].Field < drift.TypeChanges[j].Field })
+	return drift
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package schemawatch
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Returns the schema collected from the given responses to a single endpoint
+func collect(responses ...string) *Schema {
+	c := NewCollector()
+	for _, response := range responses {
+		c.Observe("menu", []byte(response))
+	}
+	return c.Schemas("2020-01-01")[0]
+}
+
+func TestCollect(t *testing.T) {
+	schema := collect(
+		`{"meal":[{"name":"LUNCH","category":[{"name":"Entrees"}]}]}`,
+		`{"meal":[{"name":"DINNER","category":[]}, {"name":2}]}`,
+	)
+	wantFields := map[string][]string{
+		"meal":                   {"array"},
+		"meal[]":                 {"object"},
+		"meal[].name":            {"number", "string"},
+		"meal[].category":        {"array"},
+		"meal[].category[]":      {"object"},
+		"meal[].category[].name": {"string"},
+	}
+	if !reflect.DeepEqual(schema.Fields, wantFields) {
+		t.Errorf("Fields = %v, want %v", schema.Fields, wantFields)
+	}
+	wantPresence := map[string]int64{
+		"meal": 2, "meal[]": 2, "meal[].name": 2, "meal[].category": 2, "meal[].category[]": 1, "meal[].category[].name": 1,
+	}
+	if schema.Responses != 2 || !reflect.DeepEqual(schema.Presence, wantPresence) {
+		t.Errorf("Responses = %d, Presence = %v, want 2, %v", schema.Responses, schema.Presence, wantPresence)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	baseline := collect(
+		`{"meal":[{"name":"LUNCH","category":[{"name":"Entrees"}]}]}`,
+		`{"meal":[{"name":"DINNER"}]}`,
+	)
+	// Stored before presence was recorded
+	legacy := collect(`{"meal":[{"name":"LUNCH","category":[{"name":"Entrees"}]}]}`)
+	legacy.Responses, legacy.Presence = 0, nil
+	tests := []struct {
+		name     string
+		baseline *Schema
+		current  string
+		want     Drift
+	}{
+		{"no drift", baseline, `{"meal":[{"name":"BREAKFAST","category":[{"name":"Soups"}]}]}`, Drift{Endpoint: "menu"}},
+		{"optional field absent", baseline, `{"meal":[{"name":"BREAKFAST"}]}`, Drift{Endpoint: "menu"}},
+		{"required field missing", baseline, `{"meal":[{"title":"BREAKFAST"}]}`,
+			Drift{Endpoint: "menu", NewFields: []string{"meal[].title"}, MissingFields: []string{"meal[].name"}}},
+		{"type change", baseline, `{"meal":[{"name":3}]}`,
+			Drift{Endpoint: "menu", TypeChanges: []TypeChange{{Field: "meal[].name", Baseline: []string{"string"}, Current: []string{"number"}}}}},
+		{"legacy baseline fields required", legacy, `{"meal":[{"name":"BREAKFAST"}]}`,
+			Drift{Endpoint: "menu", MissingFields: []string{"meal[].category", "meal[].category[]", "meal[].category[].name"}}},
+		{"no baseline", nil, `{}`, Drift{Endpoint: "menu", NoBaseline: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Diff(test.baseline, collect(test.current)); !reflect.DeepEqual(*got, test.want) {
+				t.Errorf("Diff = %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
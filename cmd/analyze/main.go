@@ -2,8 +2,17 @@ package main
 
 import (
 	"flag"
+	"sort"
+	"time"
 
 	"github.com/MichiganDiningAPI/db/dynamoclient"
+	"github.com/MichiganDiningAPI/internal/processing/cooccurrence"
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	"github.com/MichiganDiningAPI/internal/processing/foodtrends"
+	"github.com/MichiganDiningAPI/internal/processing/hallstats"
+	"github.com/MichiganDiningAPI/internal/processing/nutrition"
+	"github.com/MichiganDiningAPI/internal/processing/rollups"
+	"github.com/MichiganDiningAPI/internal/processing/rotation"
 	containers "github.com/MichiganDiningAPI/internal/util/containers"
 	"github.com/MichiganDiningAPI/internal/util/date"
 	pb "github.com/anders617/mdining-proto/proto/mdining"
@@ -19,121 +28,319 @@ func logStats(container interface{}) {
 	glog.Infof("Stats: mean %f median %f mode %f", mean, median, mode)
 }
 
-func countTimesServed(food *pb.Food) int64 {
-	count := int64(0)
-	for _, dh := range food.DiningHallMatch {
-		if dh.Campus != "" && dh.Campus != "DINING HALLS" {
-			// For now, only analyze actual Dining Hall foods
-			continue
-		}
-		for _, mealTime := range dh.MealTime {
-			count += int64(len(mealTime.MealNames))
-		}
+func parseDateFlag(name string, value string) {
+	if value == "" {
+		return
+	}
+	if _, err := date.ParseNoTime(&value); err != nil {
+		glog.Fatalf("Invalid --%s %s, expected yyyy-MM-dd: %s", name, value, err)
 	}
-	return count
 }
 
-func updateStats(foodStats *pb.FoodStat, food *pb.Food) {
-	timesServed := countTimesServed(food)
-	if timesServed == 0 {
-		return
-	}
-	foodStats.TotalFoodMealsServed += timesServed
-	foodStats.TimesServed[food.Key] += timesServed
-	for _, cat := range food.Category {
-		foodStats.CategoryCounts[cat] += timesServed
+// Recomputes the rollups of every granularity for the periods containing dates
+// and the food trends for each of trendWindows, streaming the FoodStats table
+// once into per period and per window accumulators
+func updateRollupsAndTrends(dc *dynamoclient.DynamoClient, dates []string, trendWindows []int, retiredAfterDays int) {
+	rollupAccumulators := []*rollups.Accumulator{}
+	if len(dates) > 0 {
+		for _, granularity := range rollups.Granularities {
+			affected := map[string]bool{}
+			for _, d := range dates {
+				period, err := rollups.Period(granularity, d)
+				if err != nil {
+					glog.Warningf("Skipping rollup for invalid date %s: %s", d, err)
+					continue
+				}
+				affected[period] = true
+			}
+			rollupAccumulators = append(rollupAccumulators, rollups.NewAccumulator(granularity, affected))
+		}
 	}
-	_, e := foodStats.FoodWeekdayCounts[food.Key]
-	if !e {
-		foodStats.FoodWeekdayCounts[food.Key] = &pb.StringToInt{Data: make(map[string]int64)}
+	trendAccumulators := []*foodtrends.Accumulator{}
+	for _, windowWeeks := range trendWindows {
+		trendAccumulators = append(trendAccumulators, foodtrends.NewAccumulator(foodtrends.Options{
+			WindowWeeks:      windowWeeks,
+			AsOf:             date.Now(),
+			RetiredAfterDays: retiredAfterDays,
+		}))
 	}
-	d, _ := date.ParseNoTime(&food.Date)
-	foodStats.FoodWeekdayCounts[food.Key].Data[d.Weekday().String()] += timesServed
-	_, e = foodStats.WeekdayFoodCounts[d.Weekday().String()]
-	if !e {
-		foodStats.WeekdayFoodCounts[d.Weekday().String()] = &pb.StringToInt{Data: make(map[string]int64)}
+	if len(rollupAccumulators) == 0 && len(trendAccumulators) == 0 {
+		return
 	}
-	foodStats.WeekdayFoodCounts[d.Weekday().String()].Data[food.Key] += timesServed
-	_, e = foodStats.FoodDiningHallCounts[food.Key]
-	if !e {
-		foodStats.FoodDiningHallCounts[food.Key] = &pb.StringToInt{Data: make(map[string]int64)}
+	// Trends cover the full history, rollups only need the periods containing dates
+	var startDate *string
+	if len(trendAccumulators) == 0 {
+		if first, err := date.ParseNoTime(&dates[0]); err == nil {
+			start := first
+			for _, granularity := range rollups.Granularities {
+				if periodStart, _, _ := rollups.PeriodOf(granularity, first); periodStart.Before(start) {
+					start = periodStart
+				}
+			}
+			formatted := date.FormatNoTime(start)
+			startDate = &formatted
+		}
 	}
-	for dhName, dh := range food.DiningHallMatch {
-		if dh.Campus != "" && dh.Campus != "DINING HALLS" {
-			// For now, only analyze actual Dining Hall foods
-			continue
+	err := dc.ForEachFoodStat(startDate, nil, func(stat *pb.FoodStat) {
+		for _, accumulator := range rollupAccumulators {
+			accumulator.Add(stat)
 		}
-		_, e = foodStats.DiningHallFoodCounts[dhName]
-		if !e {
-			foodStats.DiningHallFoodCounts[dhName] = &pb.StringToInt{Data: make(map[string]int64)}
+		for _, accumulator := range trendAccumulators {
+			accumulator.Add(stat)
 		}
-		for range dh.MealTime {
-			foodStats.FoodDiningHallCounts[food.Key].Data[dhName]++
-			foodStats.DiningHallFoodCounts[dhName].Data[food.Key]++
-			foodStats.DiningHallMealsServed[dhName]++
+	})
+	if err != nil {
+		glog.Fatalf("Error scanning food stats: %s", err)
+	}
+	for idx, accumulator := range rollupAccumulators {
+		updated := accumulator.Rollups()
+		for _, rollup := range updated {
+			if err := dc.PutRollup(rollup); err != nil {
+				glog.Fatalf("Error putting rollup: %s", err)
+			}
 		}
+		glog.Infof("Updated %d %s rollups", len(updated), rollups.Granularities[idx])
+	}
+	for idx, accumulator := range trendAccumulators {
+		trends := accumulator.Trends()
+		if err := dc.PutFoodTrends(trends); err != nil {
+			glog.Fatalf("Error putting trends: %s", err)
+		}
+		glog.Infof("Updated %d food trends for %d week window", len(trends), trendWindows[idx])
+	}
+}
+
+// Detects menu rotation cycles from the last historyDays of foods and projects menus past the published horizon
+func updateRotations(dc *dynamoclient.DynamoClient, historyDays int, projectionDays int) {
+	history := rotation.NewHistory()
+	startDate := date.FormatNoTime(date.Now().AddDate(0, 0, -historyDays))
+	err := dc.ForEachFood(&startDate, nil, history.AddFood)
+	if err != nil {
+		glog.Fatalf("Error scanning foods: %s", err)
+	}
+	opts := rotation.DefaultOptions
+	opts.ProjectionDays = projectionDays
+	rotations := history.Detect(opts)
+	for _, r := range rotations {
+		glog.Infof("%s repeats every %d days (confidence %f)", r.DiningHall, r.CycleDays, r.Confidence)
+	}
+	if err := dc.PutMenuRotations(rotations); err != nil {
+		glog.Fatalf("Error putting menu rotations: %s", err)
+	}
+}
+
+// Computes the foods most often served on the same dining hall meal from the last historyDays of menus
+func updateAssociations(dc *dynamoclient.DynamoClient, historyDays int, minCount int64, limit int, maxPairs int) {
+	counter := cooccurrence.NewCounter(maxPairs)
+	startDate := date.FormatNoTime(date.Now().AddDate(0, 0, -historyDays))
+	err := dc.ForEachMenu(&startDate, nil, counter.AddMenu)
+	if err != nil {
+		glog.Fatalf("Error scanning menus: %s", err)
 	}
-	if len(food.MenuItem.Allergens) == 0 {
-		foodStats.AllergenCounts["none"] += timesServed
+	if pruned := counter.Pruned(); pruned > 0 {
+		glog.Warningf("Reached %d food pairs, pairs on fewer than %d menus may be undercounted", maxPairs, pruned)
 	}
-	for _, allergen := range food.MenuItem.Allergens {
-		foodStats.AllergenCounts[allergen] += timesServed
+	associations := counter.Top(minCount, limit)
+	if err := dc.PutFoodAssociations(associations); err != nil {
+		glog.Fatalf("Error putting food associations: %s", err)
 	}
-	if len(food.MenuItem.Attribute) == 0 {
-		foodStats.AttributeCounts["none"] += timesServed
+	glog.Infof("Updated associations for %d foods", len(associations))
+}
+
+// Computes per dining hall summary statistics over the last weeks complete weeks
+func updateHallSummaries(dc *dynamoclient.DynamoClient, weeks int) {
+	accumulator := hallstats.NewAccumulator()
+	startDate, endDate := hallstats.Window(weeks)
+	err := dc.ForEachFood(&startDate, &endDate, accumulator.AddFood)
+	if err != nil {
+		glog.Fatalf("Error scanning foods: %s", err)
 	}
-	for _, attribute := range food.MenuItem.Attribute {
-		foodStats.AttributeCounts[attribute] += timesServed
+	summaries := accumulator.Summaries()
+	if err := dc.PutDiningHallSummaries(summaries); err != nil {
+		glog.Fatalf("Error putting dining hall summaries: %s", err)
 	}
+	glog.Infof("Updated summaries for %d dining halls", len(summaries))
 }
 
-func NewFoodStat(date string) *pb.FoodStat {
-	return &pb.FoodStat{
-		Date:                  date,
-		TimesServed:           map[string]int64{},
-		FoodDiningHallCounts:  map[string]*pb.StringToInt{},
-		DiningHallFoodCounts:  map[string]*pb.StringToInt{},
-		CategoryCounts:        map[string]int64{},
-		AllergenCounts:        map[string]int64{},
-		AttributeCounts:       map[string]int64{},
-		WeekdayFoodCounts:     map[string]*pb.StringToInt{},
-		FoodWeekdayCounts:     map[string]*pb.StringToInt{},
-		NumUniqueFoods:        0,
-		TotalFoodMealsServed:  0,
-		DiningHallMealsServed: map[string]int64{},
+// Computes nutrition aggregates for the menus on dates and the nutrition history of every food over the last
+// historyDays, reading menus a date at a time and folding the histories every windowDays dates
+func updateNutrition(dc *dynamoclient.DynamoClient, dates []string, historyDays int, windowDays int) {
+	include := map[string]bool{}
+	for _, d := range dates {
+		include[d] = true
+	}
+	historyStart := date.FormatNoTime(date.Now().AddDate(0, 0, -historyDays))
+	startDate := historyStart
+	if len(dates) > 0 && dates[0] < startDate {
+		startDate = dates[0]
+	}
+	history := nutrition.NewHistory()
+	numAggregates := 0
+	aggregates := []*nutrition.Aggregate{}
+	flush := func() {
+		if err := dc.PutNutritionAggregates(aggregates); err != nil {
+			glog.Fatalf("Error putting nutrition aggregates: %s", err)
+		}
+		numAggregates += len(aggregates)
+		aggregates = []*nutrition.Aggregate{}
+	}
+	numDates := 0
+	forEachMenuDate(dc, startDate, nil, nil, func(d string, menus []*pb.Menu) {
+		for _, menu := range menus {
+			if d >= historyStart {
+				history.AddMenu(menu)
+			}
+			if include[d] {
+				aggregates = append(aggregates, nutrition.Summarize(menu))
+			}
+		}
+		numDates++
+		if numDates%windowDays == 0 {
+			flush()
+			// Later dates are only added after d
+			history.Compact(nextDate(d))
+		}
+	})
+	flush()
+	foods := history.Foods()
+	if err := dc.PutFoodNutrition(foods); err != nil {
+		glog.Fatalf("Error putting food nutrition: %s", err)
 	}
+	glog.Infof("Updated nutrition for %d meals and %d foods", numAggregates, len(foods))
 }
 
 func main() {
+	foodAliases := flag.String("food_aliases", "", "Path to a json file mapping food name aliases to canonical names.")
+	startDateFlag := flag.String("start_date", "", "First date (yyyy-MM-dd) to recompute stats for. Defaults to today.")
+	endDateFlag := flag.String("end_date", "", "Last date (yyyy-MM-dd) to recompute stats for. Defaults to the latest date with menus.")
+	incremental := flag.Bool("incremental", false, "Only recompute dates marked dirty by fetch and dates after the last analyzed date.")
+	windowDays := flag.Int("window_days", 28, "Number of dates of menus read between compactions of the food nutrition histories.")
+	workers := flag.Int("workers", 4, "Number of dates whose stats are recomputed in parallel.")
+	progressInterval := flag.Duration("progress_interval", 30*time.Second, "How often to log progress while recomputing stats.")
+	computeRollups := flag.Bool("rollups", true, "Recompute the weekly, monthly and term rollups containing the recomputed dates.")
+	computeTrends := flag.Bool("trends", true, "Recompute food trends and seasonality.")
+	trendWindows := flag.String("trend_windows", foodtrends.DefaultWindows, "Comma separated trend windows in weeks.")
+	retiredAfterDays := flag.Int("retired_after_days", 28, "Foods not served for this many days are considered retired.")
+	computeRotations := flag.Bool("rotations", true, "Detect menu rotation cycles and project menus past the published horizon.")
+	rotationHistoryDays := flag.Int("rotation_history_days", 84, "Days of history used to detect menu rotation cycles.")
+	projectionDays := flag.Int("projection_days", 14, "Days past the last published menu to project using the detected cycle.")
+	computeAssociations := flag.Bool("associations", true, "Compute the foods most often served on the same meal as each food.")
+	associationHistoryDays := flag.Int("association_history_days", 180, "Days of menus used to compute food associations.")
+	associationMinCount := flag.Int64("association_min_count", 3, "Minimum number of menus two foods must share to be associated.")
+	associationsPerFood := flag.Int("associations_per_food", 10, "Maximum number of associations stored per food.")
+	associationMaxPairs := flag.Int("association_max_pairs", 1000000, "Maximum number of food pair counts held while computing associations, 0 for no limit.")
+	computeHallSummaries := flag.Bool("hall_summaries", true, "Compute per dining hall summary statistics.")
+	hallSummaryWeeks := flag.Int("hall_summary_weeks", 12, "Complete weeks before the current week covered by dining hall summaries.")
+	computeNutrition := flag.Bool("nutrition", true, "Compute nutrition aggregates for the recomputed dates and nutrition histories of foods.")
+	nutritionHistoryDays := flag.Int("nutrition_history_days", 365, "Days of menus covered by food nutrition histories.")
 	flag.Parse()
+	parseDateFlag("start_date", *startDateFlag)
+	parseDateFlag("end_date", *endDateFlag)
+	if *windowDays < 1 || *workers < 1 {
+		glog.Fatalf("--window_days and --workers must be positive")
+	}
+	trendWeeks, err := foodtrends.ParseWindows(*trendWindows)
+	if err != nil {
+		glog.Fatalf("Invalid --trend_windows: %s", err)
+	}
+
+	if *foodAliases != "" {
+		if err := foodnames.LoadAliases(*foodAliases); err != nil {
+			glog.Fatalf("Failed to load food aliases: %s", err)
+		}
+	}
 
 	dc := dynamoclient.New()
+	dc.CreateTablesIfNotExists()
 
-	stats := map[string]*pb.FoodStat{}
+	state, err := dc.GetAnalysisState(dynamoclient.FoodStatsAnalysis)
+	if err != nil {
+		glog.Fatalf("Failed to get analysis state: %s", err)
+	}
 
-	// Find all foods and calculate
+	// Work out which dates to recompute
 	startDate := date.FormatNoTime(date.Now())
-	dc.ForEachFood(&startDate, nil, func(food *pb.Food) {
-		stat, exists := stats[food.Date]
-		if !exists {
-			stat = NewFoodStat(food.Date)
-			stats[food.Date] = stat
+	if *startDateFlag != "" {
+		startDate = *startDateFlag
+	}
+	var endDate *string
+	if *endDateFlag != "" {
+		endDate = endDateFlag
+	}
+	dirty := map[string]bool{}
+	for _, d := range state.DirtyDates() {
+		dirty[d] = true
+	}
+	// In incremental mode only dirty dates and dates after the watermark are included
+	include := func(d string) bool { return true }
+	if *incremental {
+		if state.LastAnalyzedDate != "" {
+			startDate = state.LastAnalyzedDate
 		}
-		updateStats(stat, food)
+		for d := range dirty {
+			if d < startDate {
+				startDate = d
+			}
+		}
+		include = func(d string) bool {
+			return d > state.LastAnalyzedDate || dirty[d]
+		}
+		glog.Infof("Incremental analysis from %s: %d dirty dates, last analyzed %s", startDate, len(dirty), state.LastAnalyzedDate)
+	}
+
+	dates := computeStats(dc, startDate, endDate, include, pipelineOptions{
+		Workers:          *workers,
+		ProgressInterval: *progressInterval,
 	})
 
-	for _, stat := range stats {
-		stat.NumUniqueFoods = int64(len(stat.TimesServed))
+	if *computeRollups || *computeTrends {
+		rollupDates := dates
+		if !*computeRollups {
+			rollupDates = nil
+		}
+		windows := trendWeeks
+		if !*computeTrends {
+			windows = nil
+		}
+		updateRollupsAndTrends(dc, rollupDates, windows, *retiredAfterDays)
 	}
 
-	// Push results to dynamodb
-	for date, stat := range stats {
-		glog.Infof("Putting stats for date %s", date)
-		err := dc.PutProto(&dynamoclient.FoodStatsTableName, stat)
-		if err != nil {
-			glog.Fatalf("Error putting proto: %s", err)
-		}
-		glog.Infof("Sucessfully put stats for date %s", date)
+	if *computeRotations {
+		updateRotations(dc, *rotationHistoryDays, *projectionDays)
+	}
+
+	if *computeAssociations {
+		updateAssociations(dc, *associationHistoryDays, *associationMinCount, *associationsPerFood, *associationMaxPairs)
+	}
+
+	if *computeHallSummaries {
+		updateHallSummaries(dc, *hallSummaryWeeks)
+	}
+
+	if *computeNutrition {
+		updateNutrition(dc, dates, *nutritionHistoryDays, *windowDays)
 	}
 
+	// Record progress. The dirty marks read before the run inside the
+	// recomputed range are cleared and the watermark only moves forward when
+	// the range was open ended.
+	processed := []string{}
+	for _, mark := range state.DirtyMarks {
+		d := dynamoclient.DirtyMarkDate(mark)
+		if d >= startDate && (endDate == nil || d <= *endDate) {
+			processed = append(processed, mark)
+		}
+	}
+	sort.Strings(processed)
+	lastAnalyzedDate := state.LastAnalyzedDate
+	if endDate == nil && len(dates) > 0 && dates[len(dates)-1] > lastAnalyzedDate {
+		lastAnalyzedDate = dates[len(dates)-1]
+	}
+	err = dc.CompleteAnalysis(dynamoclient.FoodStatsAnalysis, lastAnalyzedDate, processed)
+	if err != nil {
+		glog.Fatalf("Failed to record analysis state: %s", err)
+	}
+	// Tell web servers to reload, they still reload on their schedule if this fails
+	dc.BumpDataGeneration("analyze")
+	glog.Infof("Analyzed %d dates, cleared %d dirty marks, last analyzed date %s", len(dates), len(processed), lastAnalyzedDate)
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MichiganDiningAPI/db/dynamoclient"
+	"github.com/MichiganDiningAPI/internal/processing/foodstats"
+	"github.com/MichiganDiningAPI/internal/processing/mdiningprocessing"
+	"github.com/MichiganDiningAPI/internal/util/date"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+)
+
+//
+// Per date recomputation of FoodStats
+//
+// The Foods table is keyed by food, so reading the foods of a date range
+// means scanning the whole table. The Menus table is keyed by date, so each
+// date's menus are read with a query and turned into foods with
+// MenusToFoods, the same way fetch writes the Foods table. Workers dates are
+// computed in parallel and each date's stats are put as soon as its menus
+// have been read, so at most Workers dates are held in memory. Dates which
+// are not included are skipped without any reads. Without an end date, dates
+// after today are read in order up to the first date without menus.
+//
+
+// Options of computeStats
+type pipelineOptions struct {
+	Workers int
+	// How often to log progress
+	ProgressInterval time.Duration
+}
+
+// Counts of work done, updated atomically
+type pipelineProgress struct {
+	datesRead int64
+	menusRead int64
+	datesPut  int64
+}
+
+func (p *pipelineProgress) log(start time.Time) {
+	glog.Infof("Progress: %d dates read, %d menus read, %d dates put in %s",
+		atomic.LoadInt64(&p.datesRead), atomic.LoadInt64(&p.menusRead), atomic.LoadInt64(&p.datesPut),
+		time.Since(start).Round(time.Second))
+}
+
+// Returns the dates from startDate to endDate, or to today if endDate is nil
+func datesThrough(startDate string, endDate *string) []string {
+	start, err := date.ParseNoTime(&startDate)
+	if err != nil {
+		glog.Fatalf("Invalid start date %s: %s", startDate, err)
+	}
+	last := date.DayStart(date.Now())
+	if endDate != nil {
+		if last, err = date.ParseNoTime(endDate); err != nil {
+			glog.Fatalf("Invalid end date %s: %s", *endDate, err)
+		}
+	}
+	dates := []string{}
+	for d := start; !d.After(last); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, date.FormatNoTime(d))
+	}
+	return dates
+}
+
+// Returns the day after d
+func nextDate(d string) string {
+	t, err := date.ParseNoTime(&d)
+	if err != nil {
+		glog.Fatalf("Invalid date %s: %s", d, err)
+	}
+	return date.FormatNoTime(t.AddDate(0, 0, 1))
+}
+
+// Reads the menus on d, failing on error
+func menusOnDate(dc *dynamoclient.DynamoClient, d string, progress *pipelineProgress) []*pb.Menu {
+	menus, err := dc.QueryMenusOnDate(d)
+	if err != nil {
+		glog.Fatalf("Error querying menus on %s: %s", d, err)
+	}
+	if progress != nil {
+		atomic.AddInt64(&progress.datesRead, 1)
+		atomic.AddInt64(&progress.menusRead, int64(len(menus)))
+	}
+	return menus
+}
+
+// Calls fn with the menus of each date from startDate to endDate in order. Without an end date the dates
+// continue past today up to the first date without menus, since menus are only published a few days ahead.
+func forEachMenuDate(dc *dynamoclient.DynamoClient, startDate string, endDate *string,
+	progress *pipelineProgress, fn func(d string, menus []*pb.Menu)) {
+	next := startDate
+	for _, d := range datesThrough(startDate, endDate) {
+		fn(d, menusOnDate(dc, d, progress))
+		next = nextDate(d)
+	}
+	if endDate != nil {
+		return
+	}
+	for d := next; ; d = nextDate(d) {
+		menus := menusOnDate(dc, d, progress)
+		if len(menus) == 0 {
+			return
+		}
+		fn(d, menus)
+	}
+}
+
+// Recomputes and puts the FoodStats of every date from startDate to endDate (open ended if nil) for which
+// include returns true. Returns the sorted dates put.
+func computeStats(dc *dynamoclient.DynamoClient, startDate string, endDate *string, include func(string) bool,
+	opts pipelineOptions) []string {
+	progress := &pipelineProgress{}
+	begin := time.Now()
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(opts.ProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				progress.log(begin)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	dates := []string{}
+	put := func(d string, menus []*pb.Menu) {
+		if !putStats(dc, d, menus) {
+			return
+		}
+		atomic.AddInt64(&progress.datesPut, 1)
+		mu.Lock()
+		dates = append(dates, d)
+		mu.Unlock()
+	}
+
+	// Dates through today are independent so they are computed in parallel
+	pending := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range pending {
+				put(d, menusOnDate(dc, d, progress))
+			}
+		}()
+	}
+	next := startDate
+	for _, d := range datesThrough(startDate, endDate) {
+		next = nextDate(d)
+		if include(d) {
+			pending <- d
+		}
+	}
+	close(pending)
+	wg.Wait()
+	// Later dates are read in order since the first date without menus ends the range
+	if endDate == nil {
+		forEachMenuDate(dc, next, nil, progress, func(d string, menus []*pb.Menu) {
+			if include(d) {
+				put(d, menus)
+			}
+		})
+	}
+
+	close(done)
+	progress.log(begin)
+	sort.Strings(dates)
+	return dates
+}
+
+// Computes and puts the FoodStats of a single date from its menus, returns false if it has none
+func putStats(dc *dynamoclient.DynamoClient, d string, menus []*pb.Menu) bool {
+	if len(menus) == 0 {
+		return false
+	}
+	messages := make([]proto.Message, len(menus))
+	for idx, menu := range menus {
+		messages[idx] = menu
+	}
+	foods, err := mdiningprocessing.MenusToFoods(&messages)
+	if err != nil {
+		glog.Fatalf("Error converting menus on %s to foods: %s", d, err)
+	}
+	stat := foodstats.New(d)
+	for _, food := range foods {
+		foodstats.Update(stat, food.(*pb.Food))
+	}
+	foodstats.Finalize(stat)
+	if err := dc.PutProto(&dynamoclient.FoodStatsTableName, stat); err != nil {
+		glog.Fatalf("Error putting proto: %s", err)
+	}
+	glog.Infof("Sucessfully put stats for date %s", d)
+	return true
+}
@@ -5,11 +5,18 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/MichiganDiningAPI/api/mdining/schemawatch"
 	dc "github.com/MichiganDiningAPI/db/dynamoclient"
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	"github.com/MichiganDiningAPI/internal/util/date"
+	"github.com/MichiganDiningAPI/internal/web/apikeys"
+	"github.com/MichiganDiningAPI/internal/web/ratelimiter"
 	"github.com/golang/glog"
+	"github.com/google/uuid"
 )
 
 func toInt(b bool) int {
@@ -19,14 +26,73 @@ func toInt(b bool) int {
 	return 0
 }
 
+func printSchemas(baselines map[string]*schemawatch.Schema, filter string) {
+	endpoints := []string{}
+	for endpoint := range baselines {
+		if strings.Contains(endpoint, filter) {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		schema := baselines[endpoint]
+		fmt.Printf("%s (updated %s, %d fields)\n", endpoint, schema.UpdatedAt, len(schema.Fields))
+		fields := []string{}
+		for field := range schema.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			optional := ""
+			if !schema.Required(field) {
+				optional = fmt.Sprintf(" (in %d of %d responses)", schema.Presence[field], schema.Responses)
+			}
+			fmt.Printf("    %s: %s%s\n", field, strings.Join(schema.Fields[field], "|"), optional)
+		}
+	}
+}
+
+func printAPIKeys(keys []*dc.APIKey) {
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt < keys[j].CreatedAt })
+	for _, key := range keys {
+		revoked := ""
+		if key.RevokedAt != "" {
+			revoked = " revoked " + key.RevokedAt
+		}
+		fmt.Printf("%s %s (tier %s, created %s%s)\n", key.ID, key.Owner, key.Tier, key.CreatedAt, revoked)
+	}
+}
+
+func printAPIKeyUsage(usages []*dc.APIKeyUsage) {
+	total := int64(0)
+	for _, usage := range usages {
+		fmt.Printf("%s %d\n", usage.Date, usage.Requests)
+		total += usage.Requests
+	}
+	fmt.Printf("Total %d requests over %d days\n", total, len(usages))
+}
+
 func main() {
 	create := flag.Bool("create", false, "Specify this flag to create necessary tables on dynamodb")
 	delete := flag.Bool("delete", false, "Specify this flag to delete necessary table on dynamo db")
 	query := flag.Bool("query", false, "Specify this flag to query tables")
 	stream := flag.Bool("stream", false, "Specify this flag to stream from the hearts table")
+	schemas := flag.Bool("schemas", false, "Specify this flag to print the stored upstream schema baselines")
+	endpoint := flag.String("endpoint", "", "Only print schemas for endpoints containing this string (used with --schemas)")
+	issueAPIKey := flag.Bool("issue_api_key", false, "Specify this flag to issue a new API key to --owner and print it")
+	owner := flag.String("owner", "", "Who an API key is issued to (used with --issue_api_key)")
+	tier := flag.String("tier", ratelimiter.DefaultTier, "Rate limit tier of an API key (used with --issue_api_key)")
+	revokeAPIKey := flag.String("revoke_api_key", "", "Id of an API key to revoke")
+	listAPIKeys := flag.Bool("api_keys", false, "Specify this flag to print every issued API key")
+	apiKeyUsage := flag.String("api_key_usage", "", "Id of an API key to print the daily usage of")
+	startDate := flag.String("start_date", "", "First date (yyyy-MM-dd) of usage to print (used with --api_key_usage)")
+	endDate := flag.String("end_date", "", "Last date (yyyy-MM-dd) of usage to print (used with --api_key_usage)")
+	migrateKeys := flag.Bool("migrate_food_keys", false, "Specify this flag to move Hearts and Foods stored under legacy lower cased names to canonical food keys")
+	foodAliases := flag.String("food_aliases", "", "Path to a json file mapping food name aliases to canonical names (used with --migrate_food_keys)")
 	flag.Parse()
 
-	if toInt(*create)+toInt(*delete)+toInt(*query)+toInt(*stream) > 1 {
+	if toInt(*create)+toInt(*delete)+toInt(*query)+toInt(*stream)+toInt(*schemas)+toInt(*issueAPIKey)+
+		toInt(*revokeAPIKey != "")+toInt(*listAPIKeys)+toInt(*apiKeyUsage != "")+toInt(*migrateKeys) > 1 {
 		glog.Fatal("You must specify either create or delete, not both")
 	}
 
@@ -46,6 +112,70 @@ func main() {
 			fmt.Printf("Not Deleting!\n")
 		}
 	}
+	if *schemas {
+		baselines, err := dynamoclient.QueryUpstreamSchemas()
+		if err != nil {
+			glog.Fatalf("Failed to query upstream schemas: %s", err)
+		}
+		printSchemas(baselines, *endpoint)
+	}
+	if *issueAPIKey {
+		if *owner == "" {
+			glog.Fatal("You must specify the --owner of the API key")
+		}
+		key, err := apikeys.Generate()
+		if err != nil {
+			glog.Fatalf("Failed to generate API key: %s", err)
+		}
+		apiKey := &dc.APIKey{
+			ID:        uuid.New().String(),
+			KeyHash:   apikeys.Hash(key),
+			Owner:     *owner,
+			Tier:      *tier,
+			CreatedAt: date.Format(date.Now()),
+		}
+		if err := dynamoclient.PutAPIKey(apiKey); err != nil {
+			glog.Fatalf("Failed to put API key: %s", err)
+		}
+		fmt.Printf("Issued API key %s to %s (tier %s)\n", apiKey.ID, apiKey.Owner, apiKey.Tier)
+		fmt.Printf("Key: %s\n", key)
+		fmt.Printf("The key is not stored and cannot be shown again.\n")
+	}
+	if *revokeAPIKey != "" {
+		if err := dynamoclient.RevokeAPIKey(*revokeAPIKey, date.Format(date.Now())); err != nil {
+			glog.Fatalf("Failed to revoke API key %s: %s", *revokeAPIKey, err)
+		}
+		fmt.Printf("Revoked API key %s\n", *revokeAPIKey)
+	}
+	if *listAPIKeys {
+		keys, err := dynamoclient.QueryAPIKeys()
+		if err != nil {
+			glog.Fatalf("Failed to query API keys: %s", err)
+		}
+		printAPIKeys(keys)
+	}
+	if *apiKeyUsage != "" {
+		var start, end *string
+		if *startDate != "" {
+			start = startDate
+		}
+		if *endDate != "" {
+			end = endDate
+		}
+		usages, err := dynamoclient.QueryAPIKeyUsage(*apiKeyUsage, start, end)
+		if err != nil {
+			glog.Fatalf("Failed to query usage of API key %s: %s", *apiKeyUsage, err)
+		}
+		printAPIKeyUsage(usages)
+	}
+	if *migrateKeys {
+		if *foodAliases != "" {
+			if err := foodnames.LoadAliases(*foodAliases); err != nil {
+				glog.Fatalf("Failed to load food aliases: %s", err)
+			}
+		}
+		migrateFoodKeys(dynamoclient)
+	}
 	if *stream {
 		records, done := dynamoclient.StreamHearts()
 		time.AfterFunc(time.Second*10, func() { done <- struct{}{} })
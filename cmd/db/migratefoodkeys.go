@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	dc "github.com/MichiganDiningAPI/db/dynamoclient"
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	"github.com/MichiganDiningAPI/internal/processing/mdiningprocessing"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+)
+
+//
+// Migration of Hearts and Foods to canonical food keys
+//
+// Foods and Hearts used to be keyed by the lower cased food name. Hearts on a
+// legacy key are added to the canonical key before the legacy key is deleted,
+// so variants of a food have their hearts merged. Foods of every date with a
+// legacy row are rebuilt from the Menus of that date, which merges variants the
+// same way fetch now does. A legacy row is deleted once its canonical key is
+// rebuilt, or moved to its canonical key when the menus no longer produce it. The rebuilt dates
+// are marked dirty so the next incremental analyze recomputes their stats.
+//
+
+// Dates of foods rebuilt per scan of the Menus table
+const migrationDatesPerScan = 30
+
+func migrateFoodKeys(dynamoclient *dc.DynamoClient) {
+	migrateHearts(dynamoclient)
+	migrateFoods(dynamoclient)
+	if _, err := dynamoclient.BumpDataGeneration("db"); err != nil {
+		glog.Errorf("Web servers will pick up the migration on their next scheduled reload: %s", err)
+	}
+}
+
+func migrateHearts(dynamoclient *dc.DynamoClient) {
+	hearts, err := dynamoclient.QueryAllHearts()
+	if err != nil {
+		glog.Fatalf("Failed to query hearts: %s", err)
+	}
+	migrated := 0
+	for _, heart := range hearts {
+		key := foodnames.Key(heart.Key)
+		if key == heart.Key {
+			continue
+		}
+		// Add before deleting so a failure never loses hearts, rerunning may count them twice instead
+		if _, err := dynamoclient.AddHearts(key, heart.Count); err != nil {
+			glog.Fatalf("Failed to add %d hearts of %s to %s: %s", heart.Count, heart.Key, key, err)
+		}
+		if err := dynamoclient.DeleteHearts([]string{heart.Key}); err != nil {
+			glog.Fatalf("Added the hearts of %s to %s but failed to delete it, delete it before rerunning: %s", heart.Key, key, err)
+		}
+		glog.Infof("Moved %d hearts from %s to %s", heart.Count, heart.Key, key)
+		migrated++
+	}
+	fmt.Printf("Moved the hearts of %d legacy keys\n", migrated)
+}
+
+func migrateFoods(dynamoclient *dc.DynamoClient) {
+	// Legacy rows by date
+	legacy := map[string][]*pb.Food{}
+	err := dynamoclient.ForEachFood(nil, nil, func(food *pb.Food) {
+		if foodnames.Key(food.Name) != food.Key {
+			legacy[food.Date] = append(legacy[food.Date], food)
+		}
+	})
+	if err != nil {
+		glog.Fatalf("Failed to scan foods: %s", err)
+	}
+	dates := make([]string, 0, len(legacy))
+	for d := range legacy {
+		dates = append(dates, d)
+	}
+	sort.Strings(dates)
+	glog.Infof("Found legacy foods on %d dates", len(dates))
+
+	for start := 0; start < len(dates); start += migrationDatesPerScan {
+		end := start + migrationDatesPerScan
+		if end > len(dates) {
+			end = len(dates)
+		}
+		chunk := dates[start:end]
+		include := map[string]bool{}
+		for _, d := range chunk {
+			include[d] = true
+		}
+		menus := []proto.Message{}
+		err := dynamoclient.ForEachMenu(&chunk[0], &chunk[len(chunk)-1], func(menu *pb.Menu) {
+			if include[menu.Date] {
+				menus = append(menus, menu)
+			}
+		})
+		if err != nil {
+			glog.Fatalf("Failed to scan menus from %s to %s: %s", chunk[0], chunk[len(chunk)-1], err)
+		}
+		foods, err := mdiningprocessing.MenusToFoods(&menus)
+		if err != nil {
+			glog.Fatalf("Failed to convert menus from %s to %s to foods: %s", chunk[0], chunk[len(chunk)-1], err)
+		}
+		if err := dynamoclient.PutProtoBatch(&dc.FoodTableName, foods); err != nil {
+			glog.Fatalf("Failed to put foods from %s to %s: %s", chunk[0], chunk[len(chunk)-1], err)
+		}
+		rebuilt := map[string]bool{}
+		for _, f := range foods {
+			food := f.(*pb.Food)
+			rebuilt[food.Key+food.Date] = true
+		}
+		// Legacy rows whose canonical key was not rebuilt, because their menus are gone or no longer list
+		// them, are moved to the canonical key instead so no food is lost. Variants are merged into the
+		// first row moved.
+		moved := map[string]*pb.Food{}
+		movedFoods := []proto.Message{}
+		stale := []*pb.Food{}
+		for _, d := range chunk {
+			for _, food := range legacy[d] {
+				stale = append(stale, food)
+				key := foodnames.Key(food.Name)
+				if rebuilt[key+food.Date] {
+					continue
+				}
+				if m, exists := moved[key+food.Date]; exists {
+					mergeFood(m, food)
+					continue
+				}
+				m := proto.Clone(food).(*pb.Food)
+				m.Key = key
+				moved[key+food.Date] = m
+				movedFoods = append(movedFoods, m)
+			}
+		}
+		if err := dynamoclient.PutProtoBatch(&dc.FoodTableName, movedFoods); err != nil {
+			glog.Fatalf("Failed to move legacy foods from %s to %s: %s", chunk[0], chunk[len(chunk)-1], err)
+		}
+		// Only delete legacy rows once their replacements are written
+		if err := dynamoclient.DeleteFoods(stale); err != nil {
+			glog.Fatalf("Failed to delete legacy foods from %s to %s: %s", chunk[0], chunk[len(chunk)-1], err)
+		}
+		if err := dynamoclient.MarkDatesDirty(dc.FoodStatsAnalysis, chunk); err != nil {
+			glog.Errorf("Failed to mark %d dates dirty, run analyze without --incremental: %s", len(chunk), err)
+		}
+		fmt.Printf("Rebuilt foods from %s to %s, moved %d and deleted %d legacy rows\n", chunk[0], chunk[len(chunk)-1], len(movedFoods), len(stale))
+	}
+}
+
+// Adds the categories and dining hall matches of food missing from into
+func mergeFood(into *pb.Food, food *pb.Food) {
+	for _, c := range food.Category {
+		contains := false
+		for _, existing := range into.Category {
+			if existing == c {
+				contains = true
+			}
+		}
+		if !contains {
+			into.Category = append(into.Category, c)
+		}
+	}
+	for hall, match := range food.DiningHallMatch {
+		if into.DiningHallMatch == nil {
+			into.DiningHallMatch = map[string]*pb.FoodDiningHallMatch{}
+		}
+		if _, exists := into.DiningHallMatch[hall]; !exists {
+			into.DiningHallMatch[hall] = match
+		}
+	}
+}
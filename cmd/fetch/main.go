@@ -1,49 +1,171 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
 	"sync"
 
-	mc "github.com/MichiganDiningAPI/api/mdining/mdiningclient"
+	"github.com/MichiganDiningAPI/api/mdining/mdiningsources"
+	"github.com/MichiganDiningAPI/api/mdining/responsearchive"
+	"github.com/MichiganDiningAPI/api/mdining/schemawatch"
 	dc "github.com/MichiganDiningAPI/db/dynamoclient"
-	"github.com/MichiganDiningAPI/internal/util/containers"
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
 	"github.com/MichiganDiningAPI/internal/processing/mdiningprocessing"
-	pb "github.com/anders617/mdining-proto/proto/mdining"
+	"github.com/MichiganDiningAPI/internal/processing/menumerge"
+	"github.com/MichiganDiningAPI/internal/util/containers"
+	"github.com/MichiganDiningAPI/internal/util/date"
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
 )
 
+//
+// Fetches dining halls and menus from every configured upstream source, merges
+// them and writes the result to the DiningHalls/Menus/Foods tables
+//
+
+// Summary of a fetch run written to --manifest
+type manifest struct {
+	StartTime     string               `json:"startTime"`
+	EndTime       string               `json:"endTime"`
+	Sources       []string             `json:"sources"`
+	MenusBySource map[string]int       `json:"menusBySource"`
+	FailedSources map[string]string    `json:"failedSources"`
+	Discrepancies int                  `json:"discrepancies"`
+	SchemaDrift   []*schemawatch.Drift `json:"schemaDrift"`
+}
+
+func writeJSON(path string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		glog.Errorf("Failed to marshal %s: %s", path, err)
+		return
+	}
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		glog.Errorf("Failed to write %s: %s", path, err)
+		return
+	}
+	glog.Infof("Wrote %s", path)
+}
+
+// Compares the schemas seen this run against the stored baselines.
+// Baselines are stored for new endpoints, and replaced if acceptDrift is set.
+func checkSchemaDrift(dynamoclient *dc.DynamoClient, schema *schemawatch.Collector, acceptDrift bool) []*schemawatch.Drift {
+	baselines, err := dynamoclient.QueryUpstreamSchemas()
+	if err != nil {
+		glog.Errorf("Failed to query upstream schemas: %s", err)
+		return nil
+	}
+	drifts := []*schemawatch.Drift{}
+	for _, current := range schema.Schemas(date.Format(date.Now())) {
+		drift := schemawatch.Diff(baselines[current.Endpoint], current)
+		if drift.HasDrift() {
+			glog.Warningf("Schema drift for %s: %d new, %d missing, %d type changes", drift.Endpoint, len(drift.NewFields), len(drift.MissingFields), len(drift.TypeChanges))
+		}
+		if drift.NoBaseline || (acceptDrift && drift.HasDrift()) {
+			dynamoclient.PutUpstreamSchema(current)
+		}
+		drifts = append(drifts, drift)
+	}
+	return drifts
+}
+
+// Environment variable holding the webplatforms API key when --api_key is not given, so it stays out of
+// the image args
+const apiKeyEnv = "MDINING_API_KEY"
+
+// Returns priority without source, logging a warning if it was present
+func withoutSource(priority []string, source string) []string {
+	remaining := []string{}
+	for _, name := range priority {
+		if name == source {
+			glog.Warningf("No API key, skipping the %s source", source)
+			continue
+		}
+		remaining = append(remaining, name)
+	}
+	return remaining
+}
+
 func main() {
+	foodAliases := flag.String("food_aliases", "", "Path to a json file mapping food name aliases to canonical names.")
+	diningHallAliases := flag.String("dining_hall_aliases", "", "Path to a json file mapping dining hall name aliases to canonical names, used to match halls across sources.")
+	apiKey := flag.String("api_key", "", "API Key for the webplatforms mdining api, read from $"+apiKeyEnv+" if empty. The webplatforms source is skipped without one.")
+	numDays := flag.Int("num_days", 7, "Number of days of data (from today) to retrieve from the webplatforms source.")
+	sourcesFlag := flag.String("sources", mdiningsources.WebPlatforms+","+mdiningsources.Legacy, "Comma separated upstream sources to query, in priority order.")
+	discrepancyReport := flag.String("discrepancy_report", "", "Path to write a json report of menus where sources disagree.")
+	manifestPath := flag.String("manifest", "", "Path to write a json manifest summarizing the fetch run.")
+	acceptSchemaDrift := flag.Bool("accept_schema_drift", false, "Replace stored upstream schema baselines with the schemas seen in this run.")
+	archiveDir := flag.String("archive_dir", "", "Directory to archive raw upstream responses in for later reprocessing.")
+	metricsTextfile := flag.String("metrics_textfile", "", "Path to write Prometheus metrics of the run to, for the node_exporter textfile collector.")
+	metricsPushURL := flag.String("metrics_push_url", "", "URL of a Prometheus Pushgateway to push metrics of the run to.")
 	flag.Parse()
+	startTime := date.Now()
+
+	if *foodAliases != "" {
+		if err := foodnames.LoadAliases(*foodAliases); err != nil {
+			glog.Fatalf("Failed to load food aliases: %s", err)
+		}
+	}
+	if *diningHallAliases != "" {
+		if err := menumerge.LoadDiningHallAliases(*diningHallAliases); err != nil {
+			glog.Fatalf("Failed to load dining hall aliases: %s", err)
+		}
+	}
 
-	mdining := mc.New()
+	schema := schemawatch.NewCollector()
+	opts := mdiningsources.Options{Schema: schema, Transport: &instrumentedTransport{next: http.DefaultTransport}}
+	if *archiveDir != "" {
+		opts.Archive = responsearchive.New(responsearchive.NewDirStore(*archiveDir), startTime)
+	}
+	if *apiKey == "" {
+		*apiKey = os.Getenv(apiKeyEnv)
+	}
+	priority := strings.Split(*sourcesFlag, ",")
+	if *apiKey == "" {
+		priority = withoutSource(priority, mdiningsources.WebPlatforms)
+		if len(priority) == 0 {
+			glog.Fatalf("Set --api_key or $%s to fetch from %s, or add other --sources", apiKeyEnv, mdiningsources.WebPlatforms)
+		}
+	}
+	sources := mdiningsources.Fetch(priority, *apiKey, mdiningsources.Dates(startTime, *numDays), opts)
 
 	dynamoclient := dc.New()
 	dynamoclient.CreateTablesIfNotExists()
 
-	diningHallsByCampus, err := mdining.GetDiningHallList()
-	if err != nil {
-		glog.Fatalf("Failed to get dining hall list %s", err)
-	}
-	diningHallsList := []proto.Message{}
-	for campus, diningHalls := range *diningHallsByCampus {
-		glog.Infof("Received campus: %s", campus)
-		diningHallsList = append(diningHallsList, util.AsSliceType(diningHalls.DiningHalls, []proto.Message{}).([]proto.Message)...)
-	}
-	dynamoclient.PutProtoBatch(&dc.DiningHallsTableName, diningHallsList)
-	menus := []*pb.Menu{}
-	for _, diningHalls := range *diningHallsByCampus {
-		m, err := mdining.GetAllMenus(diningHalls)
-		if err != nil {
-			glog.Fatalf("Failed to get menus %s", err)
+	merged := menumerge.Merge(priority, sources)
+	drift := checkSchemaDrift(dynamoclient, schema, *acceptSchemaDrift)
+	writeManifest := func() {
+		if *manifestPath == "" {
+			return
 		}
-		menus = append(menus, *m...)
+		writeJSON(*manifestPath, &manifest{
+			StartTime:     date.Format(startTime),
+			EndTime:       date.Format(date.Now()),
+			Sources:       priority,
+			MenusBySource: merged.Report.MenusBySource,
+			FailedSources: merged.Report.FailedSources,
+			Discrepancies: len(merged.Report.Discrepancies),
+			SchemaDrift:   drift,
+		})
 	}
-	menusProtoSlice := util.AsSliceType(menus, []proto.Message{}).([]proto.Message)
-	if err != nil {
-		glog.Fatalf("Failed to get menus %s", err)
+	if len(merged.Report.FailedSources) == len(sources) {
+		writeManifest()
+		exportMetrics(*metricsTextfile, *metricsPushURL, priority, merged, drift, 0, startTime, false)
+		glog.Fatalf("All sources failed: %v", merged.Report.FailedSources)
+	}
+	if *discrepancyReport != "" {
+		writeJSON(*discrepancyReport, &merged.Report)
 	}
-	glog.Infof("Menus count: %d", len(menusProtoSlice))
+
+	diningHallsList := util.AsSliceType(merged.DiningHalls, []proto.Message{}).([]proto.Message)
+	dynamoclient.PutProtoBatch(&dc.DiningHallsTableName, diningHallsList)
+
+	menusProtoSlice := util.AsSliceType(merged.Menus, []proto.Message{}).([]proto.Message)
+	glog.Infof("Menus count: %d %v", len(menusProtoSlice), merged.Report.MenusBySource)
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
@@ -61,4 +183,9 @@ func main() {
 		}()
 	}
 	wg.Wait()
+	dynamoclient.MarkFoodDatesDirty(foodsSlice)
+	// Tell web servers to reload, they still reload on their schedule if this fails
+	dynamoclient.BumpDataGeneration("fetch")
+	writeManifest()
+	exportMetrics(*metricsTextfile, *metricsPushURL, priority, merged, drift, len(foodsSlice), startTime, true)
 }
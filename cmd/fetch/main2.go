@@ -7,6 +7,7 @@ import (
 
 	"github.com/MichiganDiningAPI/api/mdining/mdiningclient2"
 	dc "github.com/MichiganDiningAPI/db/dynamoclient"
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
 	"github.com/MichiganDiningAPI/internal/processing/mdiningprocessing"
 	util "github.com/MichiganDiningAPI/internal/util/containers"
 	"github.com/golang/glog"
@@ -16,7 +17,14 @@ import (
 func main() {
 	var apiKey = flag.String("api_key", "", "API Key for mdining api")
 	var numDays = flag.Int("num_days", 7, "Number of days of data (from today) to retrieve.")
+	var foodAliases = flag.String("food_aliases", "", "Path to a json file mapping food name aliases to canonical names.")
 	flag.Parse()
+	if *foodAliases != "" {
+		if err := foodnames.LoadAliases(*foodAliases); err != nil {
+			glog.Errorf("Failed to load food aliases: %s", err)
+			return
+		}
+	}
 	if len(*apiKey) == 0 {
 		glog.Errorf("Missing required argument api_key.")
 		return
@@ -68,4 +76,7 @@ func main() {
 		}()
 	}
 	wg.Wait()
+	dynamoclient.MarkFoodDatesDirty(foodsSlice)
+	// Tell web servers to reload, they still reload on their schedule if this fails
+	dynamoclient.BumpDataGeneration("fetch2")
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MichiganDiningAPI/api/mdining/schemawatch"
+	"github.com/MichiganDiningAPI/internal/processing/menumerge"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+//
+// Metrics of a fetch run
+//
+// Fetch exits once it is done, so rather than being scraped its metrics are
+// written to --metrics_textfile for the node_exporter textfile collector
+// and/or pushed to the Pushgateway at --metrics_push_url under the job
+// "fetch". The DynamoDB request metrics of the run are included.
+//
+
+const metricsJob = "fetch"
+
+var (
+	upstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fetch_upstream_requests_total",
+		Help: "Upstream requests by host and HTTP status code, or error if no response was received.",
+	}, []string{"host", "code"})
+	upstreamLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "fetch_upstream_request_duration_seconds",
+		Help: "Time taken to receive the response headers of upstream requests.",
+	}, []string{"host"})
+	sourceUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fetch_source_up",
+		Help: "Whether each upstream source was fetched successfully.",
+	}, []string{"source"})
+	menusBySource = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fetch_menus",
+		Help: "Number of merged menus taken from each source.",
+	}, []string{"source"})
+	discrepancies = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fetch_discrepancies",
+		Help: "Number of menus where the sources disagreed.",
+	})
+	schemaDrift = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fetch_schema_drift_endpoints",
+		Help: "Number of upstream endpoints whose fields differ from their baseline.",
+	})
+	foodsWritten = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fetch_foods",
+		Help: "Number of foods written.",
+	})
+	runDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fetch_duration_seconds",
+		Help: "Time taken by the fetch run.",
+	})
+	lastRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fetch_last_run_timestamp_seconds",
+		Help: "Unix time at which the fetch run finished.",
+	})
+	runSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fetch_success",
+		Help: "Whether the fetch run wrote its results.",
+	})
+)
+
+// Counts and times upstream requests
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	upstreamLatency.WithLabelValues(req.URL.Host).Observe(time.Since(start).Seconds())
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamRequests.WithLabelValues(req.URL.Host, code).Inc()
+	return resp, err
+}
+
+// Records the outcome of the run and exports every metric
+func exportMetrics(textfile string, pushURL string, priority []string, merged *menumerge.Result,
+	drift []*schemawatch.Drift, numFoods int, start time.Time, success bool) {
+	if textfile == "" && pushURL == "" {
+		return
+	}
+	for _, source := range priority {
+		_, failed := merged.Report.FailedSources[source]
+		sourceUp.WithLabelValues(source).Set(float64(toInt(!failed)))
+		menusBySource.WithLabelValues(source).Set(float64(merged.Report.MenusBySource[source]))
+	}
+	discrepancies.Set(float64(len(merged.Report.Discrepancies)))
+	schemaDrift.Set(float64(len(drift)))
+	foodsWritten.Set(float64(numFoods))
+	runDuration.Set(time.Since(start).Seconds())
+	lastRun.Set(float64(time.Now().Unix()))
+	runSuccess.Set(float64(toInt(success)))
+	// The runtime metrics of a short lived run are not useful and would clash with the node_exporter's own
+	prometheus.Unregister(prometheus.NewGoCollector())
+	prometheus.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	if textfile != "" {
+		if err := prometheus.WriteToTextfile(textfile, prometheus.DefaultGatherer); err != nil {
+			glog.Errorf("Error writing metrics to %s: %s", textfile, err)
+		}
+	}
+	if pushURL != "" {
+		if err := push.New(pushURL, metricsJob).Gatherer(prometheus.DefaultGatherer).Push(); err != nil {
+			glog.Errorf("Error pushing metrics to %s: %s", pushURL, err)
+		}
+	}
+}
+
+func toInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
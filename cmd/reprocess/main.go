@@ -0,0 +1,222 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/MichiganDiningAPI/api/mdining/mdiningsources"
+	"github.com/MichiganDiningAPI/api/mdining/responsearchive"
+	dc "github.com/MichiganDiningAPI/db/dynamoclient"
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	"github.com/MichiganDiningAPI/internal/processing/foodstats"
+	"github.com/MichiganDiningAPI/internal/processing/mdiningprocessing"
+	"github.com/MichiganDiningAPI/internal/processing/menumerge"
+	"github.com/MichiganDiningAPI/internal/util/containers"
+	"github.com/MichiganDiningAPI/internal/util/date"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+)
+
+//
+// Rebuilds the Menus, Foods and FoodStats tables for a date range from the
+// raw upstream responses archived by fetch (--archive_dir) without calling upstream.
+// Rows on rebuilt dates which the archive no longer produces are deleted, dates
+// without any archived menus are left untouched.
+//
+
+// Placeholder api key, the replay transport ignores it
+const archivedAPIKey = "archived"
+
+func main() {
+	archiveDir := flag.String("archive_dir", "", "Directory containing raw upstream responses archived by fetch.")
+	startDate := flag.String("start_date", "", "First date (yyyy-MM-dd) to rebuild.")
+	endDate := flag.String("end_date", "", "Last date (yyyy-MM-dd) to rebuild.")
+	lookbackDays := flag.Int("lookback_days", 14, "How many days before start_date to look for fetch runs which may contain menus in range.")
+	numDays := flag.Int("num_days", 7, "Number of days each archived webplatforms fetch retrieved.")
+	sourcesFlag := flag.String("sources", mdiningsources.WebPlatforms+","+mdiningsources.Legacy, "Comma separated upstream sources to replay, in priority order.")
+	foodAliases := flag.String("food_aliases", "", "Path to a json file mapping food name aliases to canonical names.")
+	dryRun := flag.Bool("dry_run", false, "Rebuild but only log counts instead of writing to dynamodb.")
+	flag.Parse()
+
+	if *archiveDir == "" || *startDate == "" || *endDate == "" {
+		glog.Fatalf("archive_dir, start_date and end_date are required")
+	}
+	start, err := date.ParseNoTime(startDate)
+	if err != nil {
+		glog.Fatalf("Invalid start_date %s: %s", *startDate, err)
+	}
+	end, err := date.ParseNoTime(endDate)
+	if err != nil {
+		glog.Fatalf("Invalid end_date %s: %s", *endDate, err)
+	}
+	if *foodAliases != "" {
+		if err := foodnames.LoadAliases(*foodAliases); err != nil {
+			glog.Fatalf("Failed to load food aliases: %s", err)
+		}
+	}
+
+	store := responsearchive.NewDirStore(*archiveDir)
+	fetchTimes, err := responsearchive.FetchTimes(store)
+	if err != nil {
+		glog.Fatalf("Failed to list archive %s: %s", *archiveDir, err)
+	}
+	earliest := date.DayStart(start.AddDate(0, 0, -*lookbackDays))
+	latest := date.DayEnd(end)
+	priority := strings.Split(*sourcesFlag, ",")
+
+	// Replay runs oldest first so that menus from later runs (closer to the
+	// date they were served) replace earlier projections
+	menusBySlot := map[string]*pb.Menu{}
+	for _, fetchTime := range fetchTimes {
+		if fetchTime.Before(earliest) || fetchTime.After(latest) {
+			continue
+		}
+		glog.Infof("Replaying fetch run %s", date.Format(fetchTime))
+		opts := mdiningsources.Options{Transport: responsearchive.NewReplayTransport(store, fetchTime)}
+		sources := mdiningsources.Fetch(priority, archivedAPIKey, mdiningsources.Dates(fetchTime, *numDays), opts)
+		merged := menumerge.Merge(priority, sources)
+		for _, menu := range merged.Menus {
+			if menu.Date < *startDate || menu.Date > *endDate {
+				continue
+			}
+			menusBySlot[menu.Date+menu.DiningHallMeal] = menu
+		}
+	}
+	menus := []*pb.Menu{}
+	for _, menu := range menusBySlot {
+		menus = append(menus, menu)
+	}
+	menusProtoSlice := util.AsSliceType(menus, []proto.Message{}).([]proto.Message)
+	foodsSlice, err := mdiningprocessing.MenusToFoods(&menusProtoSlice)
+	if err != nil {
+		glog.Fatalf("Could not convert menus to foods %s", err)
+	}
+	stats := map[string]*pb.FoodStat{}
+	for _, f := range foodsSlice {
+		food := f.(*pb.Food)
+		stat, exists := stats[food.Date]
+		if !exists {
+			stat = foodstats.New(food.Date)
+			stats[food.Date] = stat
+		}
+		foodstats.Update(stat, food)
+	}
+	statsProtoSlice := []proto.Message{}
+	for _, stat := range stats {
+		foodstats.Finalize(stat)
+		statsProtoSlice = append(statsProtoSlice, stat)
+	}
+	glog.Infof("Rebuilt %d menus, %d foods and %d food stats for %s to %s", len(menusProtoSlice), len(foodsSlice), len(statsProtoSlice), *startDate, *endDate)
+	if *dryRun {
+		return
+	}
+
+	dynamoclient := dc.New()
+	tables := []*string{&dc.MenuTableName, &dc.FoodTableName, &dc.FoodStatsTableName}
+	rebuilt := [][]proto.Message{menusProtoSlice, foodsSlice, statsProtoSlice}
+	errs := make([]error, len(tables))
+	wg := sync.WaitGroup{}
+	for idx := range tables {
+		wg.Add(1)
+		go func(idx int) {
+			errs[idx] = dynamoclient.PutProtoBatch(tables[idx], rebuilt[idx])
+			wg.Done()
+		}(idx)
+	}
+	wg.Wait()
+	for idx, err := range errs {
+		if err != nil {
+			glog.Fatalf("Failed to put rebuilt %s: %s", *tables[idx], err)
+		}
+	}
+	staleDates := deleteStale(dynamoclient, *startDate, *endDate, menus, foodsSlice)
+
+	// Rollups, trends and the other analyses read the rebuilt foods and stats
+	if err := dynamoclient.MarkFoodDatesDirty(foodsSlice); err != nil {
+		glog.Errorf("Run analyze without --incremental for %s to %s", *startDate, *endDate)
+	}
+	if err := dynamoclient.MarkDatesDirty(dc.FoodStatsAnalysis, staleDates); err != nil {
+		glog.Errorf("Failed to mark %d dates dirty, run analyze without --incremental for %s to %s: %s", len(staleDates), *startDate, *endDate, err)
+	}
+	if _, err := dynamoclient.BumpDataGeneration("reprocess"); err != nil {
+		glog.Errorf("Web servers will pick up the rebuilt data on their next scheduled reload: %s", err)
+	}
+}
+
+// Deletes the Menus, Foods and FoodStats between startDate and endDate which were not rebuilt, on dates
+// which have rebuilt menus. Returns the dates rows were deleted from.
+func deleteStale(dynamoclient *dc.DynamoClient, startDate string, endDate string, menus []*pb.Menu, foods []proto.Message) []string {
+	rebuiltDates := map[string]bool{}
+	rebuiltMenus := map[string]bool{}
+	for _, menu := range menus {
+		rebuiltDates[menu.Date] = true
+		rebuiltMenus[menu.Date+menu.DiningHallMeal] = true
+	}
+	rebuiltFoods := map[string]bool{}
+	foodDates := map[string]bool{}
+	for _, f := range foods {
+		food := f.(*pb.Food)
+		rebuiltFoods[food.Key+food.Date] = true
+		foodDates[food.Date] = true
+	}
+	staleDates := map[string]bool{}
+	untouched := map[string]bool{}
+	staleMenus := []*pb.Menu{}
+	err := dynamoclient.ForEachMenu(&startDate, &endDate, func(menu *pb.Menu) {
+		if !rebuiltDates[menu.Date] {
+			untouched[menu.Date] = true
+			return
+		}
+		if !rebuiltMenus[menu.Date+menu.DiningHallMeal] {
+			staleMenus = append(staleMenus, menu)
+			staleDates[menu.Date] = true
+		}
+	})
+	if err != nil {
+		glog.Fatalf("Failed to scan menus from %s to %s: %s", startDate, endDate, err)
+	}
+	for d := range untouched {
+		glog.Warningf("No archived menus for %s, leaving its rows untouched", d)
+	}
+	staleFoods := []*pb.Food{}
+	err = dynamoclient.ForEachFood(&startDate, &endDate, func(food *pb.Food) {
+		if rebuiltDates[food.Date] && !rebuiltFoods[food.Key+food.Date] {
+			staleFoods = append(staleFoods, food)
+			staleDates[food.Date] = true
+		}
+	})
+	if err != nil {
+		glog.Fatalf("Failed to scan foods from %s to %s: %s", startDate, endDate, err)
+	}
+	staleStats := []string{}
+	err = dynamoclient.ForEachFoodStat(&startDate, &endDate, func(stat *pb.FoodStat) {
+		// Stats are rebuilt for every date with foods
+		if rebuiltDates[stat.Date] && !foodDates[stat.Date] {
+			staleStats = append(staleStats, stat.Date)
+			staleDates[stat.Date] = true
+		}
+	})
+	if err != nil {
+		glog.Fatalf("Failed to scan food stats from %s to %s: %s", startDate, endDate, err)
+	}
+
+	if err := dynamoclient.DeleteMenus(staleMenus); err != nil {
+		glog.Fatalf("Failed to delete stale menus: %s", err)
+	}
+	if err := dynamoclient.DeleteFoods(staleFoods); err != nil {
+		glog.Fatalf("Failed to delete stale foods: %s", err)
+	}
+	if err := dynamoclient.DeleteFoodStats(staleStats); err != nil {
+		glog.Fatalf("Failed to delete stale food stats: %s", err)
+	}
+	glog.Infof("Deleted %d menus, %d foods and %d food stats which were not rebuilt", len(staleMenus), len(staleFoods), len(staleStats))
+	dates := make([]string, 0, len(staleDates))
+	for d := range staleDates {
+		dates = append(dates, d)
+	}
+	sort.Strings(dates)
+	return dates
+}
@@ -2,23 +2,46 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/MichiganDiningAPI/api/analytics/analyticsclient"
+	"github.com/MichiganDiningAPI/db/dynamoclient"
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	"github.com/MichiganDiningAPI/internal/processing/foodtrends"
+	"github.com/MichiganDiningAPI/internal/web/apikeys"
 	"github.com/MichiganDiningAPI/internal/web/mdiningserver"
 	"github.com/MichiganDiningAPI/internal/web/ratelimiter"
+	"github.com/MichiganDiningAPI/internal/web/rpcmetrics"
+	"github.com/MichiganDiningAPI/internal/web/rpctracing"
+	extpb "github.com/MichiganDiningAPI/proto/mdiningextensions"
 	pb "github.com/anders617/mdining-proto/proto/mdining"
 	"github.com/golang/glog"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/soheilhy/cmux"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/propagation"
+	"go.opentelemetry.io/otel/api/standard"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/trace/stdout"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 //
@@ -27,6 +50,17 @@ import (
 
 const proxiedGrpcPort = "5982"
 
+const serviceName = "mdining-web"
+
+// Methods which require an API key of the admin tier
+var adminMethods = []string{"/mdiningextensions.MDiningExtensions/Reload"}
+
+// Headers set on responses served from data older than expected
+const (
+	dataStaleHeader = "X-Data-Stale"
+	dataAgeHeader   = "X-Data-Age"
+)
+
 var analytics *analyticsclient.AnalyticsClient = analyticsclient.New()
 
 // preflightHandler adds the necessary headers in order to serve
@@ -45,32 +79,193 @@ func allowCORS(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if origin := r.Header.Get("Origin"); origin != "" {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
+			// Let browsers read the staleness of responses
+			w.Header().Set("Access-Control-Expose-Headers", dataStaleHeader+", "+dataAgeHeader)
 			if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
 				preflightHandler(w, r)
 				return
 			}
 		}
 		glog.Infof("serving http for %s", r.URL.Path)
-		// Asynchronously send analytics
-		go analytics.SendHit(r)
+		// Asynchronously send analytics, except for metrics scrapes
+		if r.URL.Path != "/metrics" {
+			go analytics.SendHit(r)
+		}
 		h.ServeHTTP(w, r)
 	})
 }
 
-// Serves GRPC requests
-func serveGRPC(port string, server *mdiningserver.Server) {
+// Chains unary interceptors, the first being the outermost. grpc only supports chaining from v1.28.
+func chainUnary(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Chains stream interceptors, the first being the outermost
+func chainStream(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// Sets headers marking responses served from data older than expected, giving the data's age in seconds
+func markStale(server *mdiningserver.Server, set func(key string, value string)) {
+	if stale, age := server.Staleness(); stale {
+		set(dataStaleHeader, "true")
+		set(dataAgeHeader, strconv.Itoa(int(age.Seconds())))
+	}
+}
+
+// Marks replies served from stale data with header metadata
+func staleUnaryInterceptor(server *mdiningserver.Server) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		markStale(server, func(key string, value string) {
+			grpc.SetHeader(ctx, metadata.Pairs(key, value))
+		})
+		return handler(ctx, req)
+	}
+}
+
+// Returns the options of a grpc server tracing and measuring calls over transport, unless rateLimiter is nil
+// rate limiting them, allowing admin methods only for admin keys in keys, and unless staleness is nil marking
+// replies served from its stale data
+func serverOptions(transport string, rateLimiter *ratelimiter.RateLimiter, keys ratelimiter.KeyStore,
+	staleness *mdiningserver.Server) []grpc.ServerOption {
+	unary := []grpc.UnaryServerInterceptor{rpctracing.UnaryServerInterceptor(transport), rpcmetrics.UnaryServerInterceptor(transport)}
+	stream := []grpc.StreamServerInterceptor{rpctracing.StreamServerInterceptor(transport), rpcmetrics.StreamServerInterceptor(transport)}
+	if rateLimiter != nil {
+		unary = append(unary, rateLimiter.UnaryServerInterceptor())
+		stream = append(stream, rateLimiter.StreamServerInterceptor())
+	}
+	unary = append(unary, ratelimiter.AdminUnaryServerInterceptor(keys, adminMethods...))
+	if staleness != nil {
+		unary = append(unary, staleUnaryInterceptor(staleness))
+	}
+	return []grpc.ServerOption{grpc.UnaryInterceptor(chainUnary(unary...)), grpc.StreamInterceptor(chainStream(stream...))}
+}
+
+// Serves GRPC requests in the background, returning the grpc server so it can be stopped
+func serveGRPC(port string, server *mdiningserver.Server, opts ...grpc.ServerOption) *grpc.Server {
 	lis, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		glog.Fatalf("Failed to listen: %v", err)
 	}
-	s := grpc.NewServer()
+	s := grpc.NewServer(opts...)
 
 	// Register Server
 	pb.RegisterMDiningServer(s, server)
+	extpb.RegisterMDiningExtensionsServer(s, server)
 
 	glog.Infof("Serving GRPC Requests on %s", port)
-	if err := s.Serve(lis); err != nil {
-		glog.Fatalf("failed to server: %v", err)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			glog.Fatalf("failed to server: %v", err)
+		}
+	}()
+	return s
+}
+
+// Stops server once its calls are done or, failing that, forcefully once ctx is done
+func stopGRPC(ctx context.Context, name string, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		glog.Infof("Stopped %s grpc server", name)
+	case <-ctx.Done():
+		glog.Warningf("Timed out draining %s grpc server, closing its connections", name)
+		server.Stop()
+	}
+}
+
+// Serves the readiness of the server along with the load state and age of each dataset
+func readinessHandler(server *mdiningserver.Server) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		ready, datasets := server.Readiness()
+		resp.Header().Set("Content-Type", "application/json")
+		if !ready {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(resp).Encode(&struct {
+			Ready    bool                           `json:"ready"`
+			Datasets []*mdiningserver.DatasetStatus `json:"datasets"`
+		}{ready, datasets})
+	}
+}
+
+// Starts exporting spans to exporter, which is stdout, otlp or empty to disable tracing. Returns a function
+// which exports the spans still queued, waiting up to the deadline of its context.
+func initTracing(exporter string, otlpEndpoint string, sampleRatio float64) func(context.Context) {
+	// Traces are continued from and propagated with W3C traceparent headers
+	global.SetPropagators(propagation.New(
+		propagation.WithExtractors(trace.TraceContext{}), propagation.WithInjectors(trace.TraceContext{})))
+	var processor sdktrace.SpanProcessor
+	stop := func() {}
+	switch exporter {
+	case "":
+		return func(context.Context) {}
+	case "stdout":
+		exp, err := stdout.NewExporter(stdout.Options{})
+		if err != nil {
+			glog.Fatalf("Failed to create trace exporter: %s", err)
+		}
+		processor = sdktrace.NewSimpleSpanProcessor(exp)
+	case "otlp":
+		exp, err := otlp.NewExporter(otlp.WithInsecure(), otlp.WithAddress(otlpEndpoint))
+		if err != nil {
+			glog.Fatalf("Failed to create trace exporter: %s", err)
+		}
+		batcher, err := sdktrace.NewBatchSpanProcessor(exp)
+		if err != nil {
+			glog.Fatalf("Failed to create span processor: %s", err)
+		}
+		processor = batcher
+		stop = func() {
+			if err := exp.Stop(); err != nil {
+				glog.Warningf("Failed to stop trace exporter: %s", err)
+			}
+		}
+	default:
+		glog.Fatalf("Unknown trace exporter %s", exporter)
+	}
+	provider, err := sdktrace.NewProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.ParentSample(sdktrace.ProbabilitySampler(sampleRatio))}),
+		sdktrace.WithResource(resource.New(standard.ServiceNameKey.String(serviceName))))
+	if err != nil {
+		glog.Fatalf("Failed to create trace provider: %s", err)
+	}
+	provider.RegisterSpanProcessor(processor)
+	global.SetTraceProvider(provider)
+	glog.Infof("Exporting %v of traces to %s", sampleRatio, exporter)
+	return func(ctx context.Context) {
+		done := make(chan bool)
+		go func() {
+			// Unregistering the processor exports the spans it has queued
+			provider.UnregisterSpanProcessor(processor)
+			stop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			glog.Warningf("Timed out exporting queued spans")
+		}
 	}
 }
 
@@ -79,8 +274,48 @@ func main() {
 	if port == "" {
 		port = "8081"
 	}
+	foodAliases := flag.String("food_aliases", "", "Path to a json file mapping food name aliases to canonical names.")
+	rateLimits := flag.String("rate_limits", "", "Path to a json file of per route rate limits, the defaults are used if empty.")
+	acceptAPIKeys := flag.Bool("api_keys", true, "Accept API keys issued with cmd/db, giving their holders the limits of their tier.")
+	exposeMetrics := flag.Bool("metrics", true, "Serve Prometheus metrics at /metrics.")
+	generationPollInterval := flag.Duration("generation_poll_interval", time.Minute, "How often to check whether fetch or analyze have written new data, reloading if so. 0 only reloads on schedule.")
+	drainTimeout := flag.Duration("drain_timeout", 25*time.Second, "Time allowed for in-flight requests to finish after SIGTERM or SIGINT before connections are closed.")
+	preDrainDelay := flag.Duration("pre_drain_delay", 5*time.Second, "Time /readyz reports not ready after SIGTERM or SIGINT before the listener is closed, so load balancers stop routing first. Counts towards --drain_timeout and must be shorter.")
+	trendWindows := flag.String("trend_windows", foodtrends.DefaultWindows, "Comma separated trend windows in weeks served by /v1/foodTrends, matching analyze's --trend_windows.")
+	trustedProxyHops := flag.Int("trusted_proxy_hops", 0, "Number of proxies in front of the server which append the client address to X-Forwarded-For.")
+	traceExporter := flag.String("trace_exporter", "", "Where to export traces: stdout, otlp or empty to disable tracing.")
+	otlpEndpoint := flag.String("otlp_endpoint", "localhost:4317", "Address of the OpenTelemetry collector receiving traces over OTLP/gRPC when --trace_exporter=otlp.")
+	traceSampleRatio := flag.Float64("trace_sample_ratio", 1, "Fraction of traces started by this server which are exported, traces continued from a caller follow its decision.")
 	flag.Parse()
 
+	if *preDrainDelay < 0 || *preDrainDelay >= *drainTimeout {
+		glog.Fatalf("--pre_drain_delay must be at least 0 and shorter than --drain_timeout")
+	}
+
+	shutdownTracing := initTracing(*traceExporter, *otlpEndpoint, *traceSampleRatio)
+
+	if *foodAliases != "" {
+		if err := foodnames.LoadAliases(*foodAliases); err != nil {
+			glog.Fatalf("Failed to load food aliases: %s", err)
+		}
+	}
+
+	rateLimitConfig := ratelimiter.DefaultConfig
+	if *rateLimits != "" {
+		config, err := ratelimiter.LoadConfig(*rateLimits)
+		if err != nil {
+			glog.Fatalf("Failed to load rate limits: %s", err)
+		}
+		rateLimitConfig = *config
+	}
+	var keys ratelimiter.KeyStore
+	var keyStore *apikeys.Store
+	if *acceptAPIKeys {
+		keyStore = apikeys.New(dynamoclient.New())
+		keys = keyStore
+	}
+	rateLimiter := ratelimiter.New(rateLimitConfig, *trustedProxyHops, keys)
+
 	// Read index.html and favicon.ico into memory
 	indexHTML, e := ioutil.ReadFile("public/index.html")
 	if e != nil {
@@ -92,7 +327,15 @@ func main() {
 		glog.Fatalf("Error reading public/favicon.ico", e)
 	}
 
+	trendWeeks, err := foodtrends.ParseWindows(*trendWindows)
+	if err != nil {
+		glog.Fatalf("Invalid --trend_windows: %s", err)
+	}
 	mDiningServer := mdiningserver.New()
+	mDiningServer.SetTrendWindows(trendWeeks)
+	if *generationPollInterval > 0 {
+		mDiningServer.WatchGeneration(*generationPollInterval)
+	}
 
 	// Create the main listener.
 	glog.Infof("Listening on port " + port)
@@ -110,22 +353,40 @@ func main() {
 	httpL := m.Match(cmux.HTTP1Fast())
 
 	// HTTP
-	mux := runtime.NewServeMux()
+	// API keys are passed on to the loopback grpc server, which checks the tier of admin methods
+	mux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(func(key string) (string, bool) {
+		if strings.ToLower(key) == ratelimiter.APIKeyHeader {
+			return ratelimiter.APIKeyHeader, true
+		}
+		return runtime.DefaultHeaderMatcher(key)
+	}))
 
-	opts := []grpc.DialOption{grpc.WithInsecure()}
+	// The gateway's calls to the loopback grpc server are children of the REST request's span
+	opts := []grpc.DialOption{grpc.WithInsecure(), grpc.WithUnaryInterceptor(rpctracing.UnaryClientInterceptor(rpcmetrics.REST))}
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	// Set the address to forward requests to to grpcAddr
 	err = pb.RegisterMDiningHandlerFromEndpoint(ctx, mux, "localhost:"+proxiedGrpcPort, opts)
-	grpcServer := grpc.NewServer()
+	if err != nil {
+		glog.Fatalf("Failed to register gateway: %s", err)
+	}
+	err = extpb.RegisterMDiningExtensionsHandlerFromEndpoint(ctx, mux, "localhost:"+proxiedGrpcPort, opts)
+	if err != nil {
+		glog.Fatalf("Failed to register gateway: %s", err)
+	}
+	// grpc-web responses are marked stale by the HTTP handler
+	grpcServer := grpc.NewServer(serverOptions(rpcmetrics.GRPCWeb, rateLimiter, keys, nil)...)
 	// Register Server
 	pb.RegisterMDiningServer(grpcServer, mDiningServer)
+	extpb.RegisterMDiningExtensionsServer(grpcServer, mDiningServer)
 	// Wrap it in a grpcweb handler in order to also serve grpc-web requests
 	wrappedGrpc := grpcweb.WrapServer(grpcServer, grpcweb.WithAllowedRequestHeaders([]string{"*"}))
-	menuRateLimiter := ratelimiter.New()
+	metricsHandler := promhttp.Handler()
+	ready := readinessHandler(mDiningServer)
 	grpcWebHandler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
 		if wrappedGrpc.IsGrpcWebRequest(req) {
+			markStale(mDiningServer, resp.Header().Set)
 			wrappedGrpc.ServeHTTP(resp, req)
 			return
 		}
@@ -138,6 +399,19 @@ func main() {
 			resp.Write(favicon)
 			return
 		}
+		if *exposeMetrics && req.URL.Path == "/metrics" {
+			metricsHandler.ServeHTTP(resp, req)
+			return
+		}
+		// Liveness only checks that the server is handling requests, restarting won't fix missing data
+		if req.URL.Path == "/livez" {
+			resp.Write([]byte("OK"))
+			return
+		}
+		if req.URL.Path == "/readyz" {
+			ready(resp, req)
+			return
+		}
 		if req.URL.Path == "/healthcheck" {
 			if mDiningServer.IsAvailable() {
 				resp.Write([]byte("OK"))
@@ -146,31 +420,84 @@ func main() {
 			http.Error(resp, "Unavailable", http.StatusInternalServerError)
 			return
 		}
-		if !menuRateLimiter.ShouldAllow(req) {
-			http.Error(resp, "Please do not abuse this API. Rate limit reached.", http.StatusInternalServerError)
+		if !rateLimiter.AllowHTTP(resp, req) {
 			return
 		}
+		markStale(mDiningServer, resp.Header().Set)
 		// Fall back to other servers.
 		mux.ServeHTTP(resp, req)
 	})
 	httpS := &http.Server{
-		Handler: allowCORS(grpcWebHandler),
+		Handler: rpctracing.HTTPHandler(allowCORS(grpcWebHandler)),
 	}
 
 	// Create your protocol servers.
-	grpcS := grpc.NewServer()
+	grpcS := grpc.NewServer(serverOptions(rpcmetrics.GRPC, rateLimiter, keys, mDiningServer)...)
 
 	// Register Server
 	pb.RegisterMDiningServer(grpcS, mDiningServer)
+	extpb.RegisterMDiningExtensionsServer(grpcS, mDiningServer)
 
 	// Use the muxed listeners for your servers.
 	// One GRPC server to handle proxied http requests
-	go serveGRPC(proxiedGrpcPort, mDiningServer)
+	// Proxied REST requests are rate limited by the HTTP handler instead
+	proxiedGrpcS := serveGRPC(proxiedGrpcPort, mDiningServer, serverOptions(rpcmetrics.REST, nil, keys, nil)...)
 	// Second GRPC server to handle direct GRPC requests
 	go grpcS.Serve(grpcL)
 	// HTTP Server To Proxy Requests to First GRPC Server
 	go httpS.Serve(httpL)
 
 	// Start serving!
-	m.Serve()
+	closing := make(chan struct{})
+	go func() {
+		if err := m.Serve(); err != nil {
+			select {
+			case <-closing:
+				// Closed for shutdown
+			default:
+				glog.Fatalf("Error serving: %s", err)
+			}
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	glog.Infof("Received %s, draining for up to %v", sig, *drainTimeout)
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer drainCancel()
+
+	// Report not ready so load balancers stop routing new requests here. Heart streams never end on their own,
+	// so they are ended now as well to let the servers drain.
+	mDiningServer.Shutdown()
+	glog.Infof("Not ready, closing the listener in %v", *preDrainDelay)
+	time.Sleep(*preDrainDelay)
+	// Stop accepting connections, cmux closes grpcL and httpL once the main listener is closed
+	close(closing)
+	l.Close()
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		stopGRPC(drainCtx, "grpc", grpcS)
+	}()
+	go func() {
+		defer wg.Done()
+		// Also drains grpc-web calls, which are served over HTTP
+		if err := httpS.Shutdown(drainCtx); err != nil {
+			glog.Warningf("Timed out draining HTTP server, closing its connections: %s", err)
+			httpS.Close()
+		}
+		glog.Infof("Stopped HTTP server")
+	}()
+	wg.Wait()
+	// The gateway calls the proxied grpc server, so it is stopped once the HTTP server has drained
+	stopGRPC(drainCtx, "proxied", proxiedGrpcS)
+
+	if keyStore != nil {
+		keyStore.Close()
+	}
+	shutdownTracing(drainCtx)
+	glog.Infof("Shut down")
+	glog.Flush()
 }
@@ -0,0 +1,155 @@
+package dynamoclient
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MichiganDiningAPI/internal/util/date"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+)
+
+// FoodStatsAnalysis - Name of the analysis job computing the FoodStats table
+const FoodStatsAnalysis = "foodstats"
+
+// AnalysisState - Progress of an incremental analysis job
+type AnalysisState struct {
+	// Name of the analysis job, e.g. FoodStatsAnalysis
+	Name string `json:"name"`
+	// Latest date (yyyy-MM-dd) that has been analyzed
+	LastAnalyzedDate string `json:"lastAnalyzedDate"`
+	// Marks of dates at or before LastAnalyzedDate whose data changed since
+	// they were analyzed, each "date#version" so every mark is distinct
+	DirtyMarks []string `json:"dirtyDates" dynamodbav:"dirtyDates,stringset"`
+	UpdatedAt  string   `json:"updatedAt"`
+}
+
+// Separates the date of a dirty mark from its version
+const dirtyMarkSeparator = "#"
+
+// DirtyMarkDate - Returns the date of a dirty mark
+func DirtyMarkDate(mark string) string {
+	return strings.SplitN(mark, dirtyMarkSeparator, 2)[0]
+}
+
+// DirtyDates - Returns the distinct dates marked dirty in ascending order
+func (s *AnalysisState) DirtyDates() []string {
+	seen := map[string]bool{}
+	dates := []string{}
+	for _, mark := range s.DirtyMarks {
+		d := DirtyMarkDate(mark)
+		if !seen[d] {
+			seen[d] = true
+			dates = append(dates, d)
+		}
+	}
+	sort.Strings(dates)
+	return dates
+}
+
+// GetAnalysisState - Returns the state of the named analysis job, empty if it has never run
+func (d *DynamoClient) GetAnalysisState(name string) (*AnalysisState, error) {
+	key, err := dynamodbattribute.Marshal(&name)
+	if err != nil {
+		return nil, err
+	}
+	req := d.client.GetItemRequest(&dynamodb.GetItemInput{
+		TableName:      aws.String(AnalysisStateTableName),
+		Key:            map[string]dynamodb.AttributeValue{NameKey: *key},
+		ConsistentRead: aws.Bool(true)})
+	res, err := req.Send(d.requestContext())
+	if err != nil {
+		return nil, err
+	}
+	state := AnalysisState{Name: name}
+	if res.Item == nil {
+		return &state, nil
+	}
+	err = dynamodbattribute.UnmarshalMap(res.Item, &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// MarkDatesDirty - Adds dates to the dirty set of the named analysis job.
+// Each call adds new marks, so marks a running job already read stay distinct
+// from the ones added while it runs.
+func (d *DynamoClient) MarkDatesDirty(name string, dates []string) error {
+	if len(dates) == 0 {
+		return nil
+	}
+	version := strconv.FormatInt(time.Now().UnixNano(), 36)
+	marks := make([]string, len(dates))
+	for i, day := range dates {
+		marks[i] = day + dirtyMarkSeparator + version
+	}
+	return d.updateAnalysisState(name, "SET updatedAt = :now ADD dirtyDates :dates", marks, nil)
+}
+
+// MarkFoodDatesDirty - Marks the dates of the written foods so the next
+// incremental FoodStats analysis recomputes them
+func (d *DynamoClient) MarkFoodDatesDirty(foods []proto.Message) error {
+	seen := map[string]bool{}
+	dates := []string{}
+	for _, msg := range foods {
+		food, ok := msg.(*pb.Food)
+		if !ok || seen[food.Date] {
+			continue
+		}
+		seen[food.Date] = true
+		dates = append(dates, food.Date)
+	}
+	if err := d.MarkDatesDirty(FoodStatsAnalysis, dates); err != nil {
+		glog.Errorf("Failed to mark %d dates dirty: %s", len(dates), err)
+		return err
+	}
+	return nil
+}
+
+// CompleteAnalysis - Records that the named job analyzed everything up to
+// lastAnalyzedDate and removes the processed marks from its dirty set. Marks
+// added while the job was running are not among processed, so their dates
+// stay dirty for the next run.
+func (d *DynamoClient) CompleteAnalysis(name string, lastAnalyzedDate string, processed []string) error {
+	update := "SET updatedAt = :now, lastAnalyzedDate = :last"
+	if len(processed) > 0 {
+		update += " DELETE dirtyDates :dates"
+	}
+	return d.updateAnalysisState(name, update, processed, &lastAnalyzedDate)
+}
+
+func (d *DynamoClient) updateAnalysisState(name string, update string, dates []string, lastAnalyzedDate *string) error {
+	key, err := dynamodbattribute.Marshal(&name)
+	if err != nil {
+		return err
+	}
+	now := date.Format(date.Now())
+	values := map[string]dynamodb.AttributeValue{
+		":now": dynamodb.AttributeValue{S: &now},
+	}
+	if len(dates) > 0 {
+		values[":dates"] = dynamodb.AttributeValue{SS: dates}
+	}
+	if lastAnalyzedDate != nil {
+		values[":last"] = dynamodb.AttributeValue{S: lastAnalyzedDate}
+	}
+	req := d.client.UpdateItemRequest(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(AnalysisStateTableName),
+		Key:                       map[string]dynamodb.AttributeValue{NameKey: *key},
+		UpdateExpression:          &update,
+		ExpressionAttributeValues: values,
+	})
+	_, err = req.Send(d.requestContext())
+	if err != nil {
+		glog.Errorf("Error updating analysis state %s: %s", name, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package dynamoclient
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/expression"
+	"github.com/golang/glog"
+)
+
+// APIKey - An API key issued to a consumer. Only the hash of the key is stored.
+type APIKey struct {
+	ID string `json:"id"`
+	// Hex encoded SHA-256 hash of the key
+	KeyHash string `json:"keyHash"`
+	// Who the key was issued to
+	Owner string `json:"owner"`
+	// Name of the rate limit tier of the key
+	Tier      string `json:"tier"`
+	CreatedAt string `json:"createdAt"`
+	// Empty unless the key has been revoked
+	RevokedAt string `json:"revokedAt,omitempty"`
+}
+
+// APIKeyUsage - Number of requests made with an API key on a date
+type APIKeyUsage struct {
+	ID       string `json:"id"`
+	Date     string `json:"date"`
+	Requests int64  `json:"requests"`
+}
+
+// QueryAPIKeys - Returns every issued API key, including revoked keys
+func (d *DynamoClient) QueryAPIKeys() ([]*APIKey, error) {
+	req := d.client.ScanRequest(&dynamodb.ScanInput{
+		TableName: aws.String(APIKeysTableName),
+	})
+	p := dynamodb.NewScanPaginator(req)
+	keys := []*APIKey{}
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			key := APIKey{}
+			err := dynamodbattribute.UnmarshalMap(item, &key)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, &key)
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// PutAPIKey - Stores an API key
+func (d *DynamoClient) PutAPIKey(key *APIKey) error {
+	av, err := dynamodbattribute.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+	req := d.client.PutItemRequest(&dynamodb.PutItemInput{
+		TableName: aws.String(APIKeysTableName),
+		Item:      av})
+	_, err = req.Send(d.requestContext())
+	if err != nil {
+		glog.Errorf("Error putting API key %s: %s", key.ID, err)
+		return err
+	}
+	glog.Infof("Successfully Put API key %s", key.ID)
+	return nil
+}
+
+// RevokeAPIKey - Marks the API key with id as revoked at revokedAt, failing if there is no such key
+func (d *DynamoClient) RevokeAPIKey(id string, revokedAt string) error {
+	key, err := dynamodbattribute.Marshal(&id)
+	if err != nil {
+		return err
+	}
+	update := expression.Set(expression.Name("revokedAt"), expression.Value(revokedAt))
+	condition := expression.AttributeExists(expression.Name(APIKeyIDKey))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return err
+	}
+	req := d.client.UpdateItemRequest(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(APIKeysTableName),
+		Key:                       map[string]dynamodb.AttributeValue{APIKeyIDKey: *key},
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	_, err = req.Send(d.requestContext())
+	if err != nil {
+		glog.Errorf("Error revoking API key %s: %s", id, err)
+		return err
+	}
+	glog.Infof("Successfully revoked API key %s", id)
+	return nil
+}
+
+// AddAPIKeyUsage - Adds requests to the count of the API key with id on a date (yyyy-MM-dd), returning the
+// new count
+func (d *DynamoClient) AddAPIKeyUsage(id string, day string, requests int64) (int64, error) {
+	n := strconv.FormatInt(requests, 10)
+	update := "ADD requests :n"
+	req := d.client.UpdateItemRequest(&dynamodb.UpdateItemInput{
+		TableName: aws.String(APIKeyUsageTableName),
+		Key: map[string]dynamodb.AttributeValue{
+			APIKeyUsageIDKey:   dynamodb.AttributeValue{S: &id},
+			APIKeyUsageDateKey: dynamodb.AttributeValue{S: &day},
+		},
+		UpdateExpression:          &update,
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{":n": dynamodb.AttributeValue{N: &n}},
+		ReturnValues:              dynamodb.ReturnValueUpdatedNew,
+	})
+	res, err := req.Send(d.requestContext())
+	if err != nil {
+		glog.Errorf("Error adding usage of API key %s: %s", id, err)
+		return 0, err
+	}
+	usage := APIKeyUsage{}
+	if err := dynamodbattribute.UnmarshalMap(res.Attributes, &usage); err != nil {
+		return 0, err
+	}
+	return usage.Requests, nil
+}
+
+// QueryAPIKeyUsage - Returns the daily usage of the API key with id from startDate to endDate (yyyy-MM-dd,
+// inclusive), either of which may be nil for an open ended range
+func (d *DynamoClient) QueryAPIKeyUsage(id string, startDate *string, endDate *string) ([]*APIKeyUsage, error) {
+	keyCond := expression.Key(APIKeyUsageIDKey).Equal(expression.Value(id))
+	switch {
+	case startDate != nil && endDate != nil:
+		keyCond = keyCond.And(expression.Key(APIKeyUsageDateKey).Between(expression.Value(*startDate), expression.Value(*endDate)))
+	case startDate != nil:
+		keyCond = keyCond.And(expression.Key(APIKeyUsageDateKey).GreaterThanEqual(expression.Value(*startDate)))
+	case endDate != nil:
+		keyCond = keyCond.And(expression.Key(APIKeyUsageDateKey).LessThanEqual(expression.Value(*endDate)))
+	}
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, err
+	}
+	req := d.client.QueryRequest(&dynamodb.QueryInput{
+		TableName:                 aws.String(APIKeyUsageTableName),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	p := dynamodb.NewQueryPaginator(req)
+	usages := []*APIKeyUsage{}
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			usage := APIKeyUsage{}
+			err := dynamodbattribute.UnmarshalMap(item, &usage)
+			if err != nil {
+				return nil, err
+			}
+			usages = append(usages, &usage)
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
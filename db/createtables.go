@@ -1,8 +1,6 @@
 package dynamoclient
 
 import (
-	"context"
-
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/golang/glog"
@@ -33,7 +31,7 @@ func (d *DynamoClient) CreateTables() error {
 func (d *DynamoClient) tableExists(table string) bool {
 	describeReq := d.client.DescribeTableRequest(&dynamodb.DescribeTableInput{
 		TableName: aws.String(table)})
-	_, err := describeReq.Send(context.Background())
+	_, err := describeReq.Send(d.requestContext())
 	return err == nil
 }
 
@@ -48,7 +46,7 @@ func (d *DynamoClient) createTable(table string) {
 		AttributeDefinitions:  attrs,
 		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{ReadCapacityUnits: &read, WriteCapacityUnits: &write},
 		StreamSpecification:   &streamSpec})
-	_, err := createReq.Send(context.Background())
+	_, err := createReq.Send(d.requestContext())
 	if err != nil {
 		glog.Fatalf("Failed to create table %s %v", table, err)
 	}
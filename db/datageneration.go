@@ -0,0 +1,72 @@
+package dynamoclient
+
+import (
+	"github.com/MichiganDiningAPI/internal/util/date"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"github.com/golang/glog"
+)
+
+// DataGenerationName - Name of the item holding the data generation
+const DataGenerationName = "data"
+
+// DataGeneration - Counter bumped each time the served data changes, so web servers know to reload
+type DataGeneration struct {
+	Name       string `json:"name"`
+	Generation int64  `json:"generation"`
+	UpdatedAt  string `json:"updatedAt"`
+	// Executable which made the last change, e.g. fetch
+	UpdatedBy string `json:"updatedBy"`
+}
+
+// GetDataGeneration - Returns the current data generation, generation 0 if it has never been bumped
+func (d *DynamoClient) GetDataGeneration() (*DataGeneration, error) {
+	name := DataGenerationName
+	req := d.client.GetItemRequest(&dynamodb.GetItemInput{
+		TableName:      aws.String(DataGenerationTableName),
+		Key:            map[string]dynamodb.AttributeValue{NameKey: dynamodb.AttributeValue{S: &name}},
+		ConsistentRead: aws.Bool(true)})
+	res, err := req.Send(d.requestContext())
+	if err != nil {
+		return nil, err
+	}
+	generation := DataGeneration{Name: name}
+	if res.Item == nil {
+		return &generation, nil
+	}
+	if err := dynamodbattribute.UnmarshalMap(res.Item, &generation); err != nil {
+		return nil, err
+	}
+	return &generation, nil
+}
+
+// BumpDataGeneration - Increments the data generation after updatedBy changed the data, returning the new generation
+func (d *DynamoClient) BumpDataGeneration(updatedBy string) (int64, error) {
+	name := DataGenerationName
+	now := date.Format(date.Now())
+	one := "1"
+	update := "ADD generation :one SET updatedAt = :now, updatedBy = :by"
+	req := d.client.UpdateItemRequest(&dynamodb.UpdateItemInput{
+		TableName:        aws.String(DataGenerationTableName),
+		Key:              map[string]dynamodb.AttributeValue{NameKey: dynamodb.AttributeValue{S: &name}},
+		UpdateExpression: &update,
+		ExpressionAttributeValues: map[string]dynamodb.AttributeValue{
+			":one": dynamodb.AttributeValue{N: &one},
+			":now": dynamodb.AttributeValue{S: &now},
+			":by":  dynamodb.AttributeValue{S: &updatedBy},
+		},
+		ReturnValues: dynamodb.ReturnValueAllNew,
+	})
+	res, err := req.Send(d.requestContext())
+	if err != nil {
+		glog.Errorf("Error bumping data generation: %s", err)
+		return 0, err
+	}
+	generation := DataGeneration{}
+	if err := dynamodbattribute.UnmarshalMap(res.Attributes, &generation); err != nil {
+		return 0, err
+	}
+	glog.Infof("Bumped data generation to %d", generation.Generation)
+	return generation.Generation, nil
+}
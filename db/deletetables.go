@@ -1,8 +1,6 @@
 package dynamoclient
 
 import (
-	"context"
-
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/golang/glog"
 )
@@ -18,7 +16,7 @@ func (d *DynamoClient) DeleteTables() error {
 func (d *DynamoClient) deleteTable(table string) {
 	deleteReq := d.client.DeleteTableRequest(&dynamodb.DeleteTableInput{
 		TableName: &table})
-	_, err := deleteReq.Send(context.Background())
+	_, err := deleteReq.Send(d.requestContext())
 	if err != nil {
 		glog.Fatalf("Failed to delete table %s %v", table, err)
 	}
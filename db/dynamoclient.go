@@ -2,10 +2,14 @@ package dynamoclient
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"reflect"
+	"time"
 
 	pb "github.com/anders617/mdining-proto/proto/mdining"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
 	"github.com/aws/aws-sdk-go-v2/aws/endpoints"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -14,11 +18,75 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/kv"
+	"go.opentelemetry.io/otel/api/trace"
+	"google.golang.org/grpc/codes"
 )
 
+var (
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "dynamodb_request_duration_seconds",
+		Help: "Latency of DynamoDB requests, including retries.",
+	}, []string{"service", "operation"})
+	requestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dynamodb_request_errors_total",
+		Help: "DynamoDB requests which failed after any retries, by error code.",
+	}, []string{"service", "operation", "code"})
+)
+
+// Records the latency and outcome of a completed request
+func observeRequest(r *aws.Request) {
+	operation := ""
+	if r.Operation != nil {
+		operation = r.Operation.Name
+	}
+	requestDuration.WithLabelValues(r.Metadata.ServiceName, operation).Observe(time.Since(r.Time).Seconds())
+	if r.Error != nil {
+		code := "unknown"
+		if awsErr, ok := r.Error.(awserr.Error); ok {
+			code = awsErr.Code()
+		}
+		requestErrors.WithLabelValues(r.Metadata.ServiceName, operation, code).Inc()
+	}
+	attributes := []kv.KeyValue{
+		kv.String("db.system", "dynamodb"),
+		kv.String("db.operation", operation),
+		kv.Int("aws.retry_count", r.RetryCount),
+	}
+	if table := tableName(r.Params); table != "" {
+		attributes = append(attributes, kv.String("db.name", table))
+	}
+	// The request has completed, so its span is recorded after the fact from its start time
+	ctx, span := global.Tracer("github.com/MichiganDiningAPI/db/dynamoclient").Start(r.Context(),
+		r.Metadata.ServiceName+"."+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithStartTime(r.Time), trace.WithAttributes(attributes...))
+	if r.Error != nil {
+		span.RecordError(ctx, r.Error, trace.WithErrorStatus(codes.Unknown))
+	}
+	span.End()
+}
+
+// Returns the TableName of request params, empty if they have none
+func tableName(params interface{}) string {
+	v := reflect.ValueOf(params)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	field := v.Elem().FieldByName("TableName")
+	if !field.IsValid() || field.Kind() != reflect.Ptr || field.IsNil() || field.Elem().Kind() != reflect.String {
+		return ""
+	}
+	return field.Elem().String()
+}
+
 type DynamoClient struct {
 	client       *dynamodb.Client
 	streamClient *dynamodbstreams.Client
+	// Context of every request, so their spans join the trace of the caller
+	ctx context.Context
 }
 
 func New() *DynamoClient {
@@ -32,11 +100,27 @@ func New() *DynamoClient {
 	}
 	// TODO: Make this configurable
 	cfg.Region = endpoints.UsEast1RegionID
+	cfg.Handlers.Complete.PushBack(observeRequest)
 	dc.client = dynamodb.New(cfg)
 	dc.streamClient = dynamodbstreams.New(cfg)
 	return dc
 }
 
+// WithContext - Returns a client sharing d's connections whose requests are made with ctx, so they are
+// traced as children of its span and canceled with it
+func (d *DynamoClient) WithContext(ctx context.Context) *DynamoClient {
+	dc := *d
+	dc.ctx = ctx
+	return &dc
+}
+
+func (d *DynamoClient) requestContext() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
 func (d *DynamoClient) GetHearts(keys []string) (*[]*pb.HeartCount, error) {
 	paramKeys := []map[string]dynamodb.AttributeValue{}
 	for _, key := range keys {
@@ -55,7 +139,7 @@ func (d *DynamoClient) GetHearts(keys []string) (*[]*pb.HeartCount, error) {
 				Keys: paramKeys}}}
 	req := d.client.BatchGetItemRequest(&params)
 
-	resp, err := req.Send(context.Background())
+	resp, err := req.Send(d.requestContext())
 	if err != nil {
 		return nil, err
 	}
@@ -78,8 +162,38 @@ func (d *DynamoClient) GetHearts(keys []string) (*[]*pb.HeartCount, error) {
 	return &heartCounts, nil
 }
 
+// QueryAllHearts - Returns the heart count of every food with hearts
+func (d *DynamoClient) QueryAllHearts() ([]*pb.HeartCount, error) {
+	req := d.client.ScanRequest(&dynamodb.ScanInput{
+		TableName: &HeartsTableName,
+	})
+	p := dynamodb.NewScanPaginator(req)
+
+	heartCounts := []*pb.HeartCount{}
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			heartCount := pb.HeartCount{}
+			err := dynamodbattribute.UnmarshalMap(item, &heartCount)
+			if err != nil {
+				return nil, err
+			}
+			heartCounts = append(heartCounts, &heartCount)
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return heartCounts, nil
+}
+
 func (d *DynamoClient) AddHeart(key string) (*pb.HeartCount, error) {
-	updateExpression := expression.Add(expression.Name("count"), expression.Value(1))
+	return d.AddHearts(key, 1)
+}
+
+// AddHearts - Adds count hearts to the food with key, returning its new heart count
+func (d *DynamoClient) AddHearts(key string, count int64) (*pb.HeartCount, error) {
+	updateExpression := expression.Add(expression.Name("count"), expression.Value(count))
 	expr, _ := expression.NewBuilder().WithUpdate(updateExpression).Build()
 	dynamoKey, err := dynamodbattribute.Marshal(&key)
 	if err != nil {
@@ -95,7 +209,7 @@ func (d *DynamoClient) AddHeart(key string) (*pb.HeartCount, error) {
 	}
 	req := d.client.UpdateItemRequest(&params)
 
-	resp, err := req.Send(context.Background())
+	resp, err := req.Send(d.requestContext())
 	if err != nil {
 		return nil, err
 	}
@@ -122,7 +236,7 @@ func (d *DynamoClient) GetProto(table string, keys map[string]string, p proto.Me
 	req := d.client.GetItemRequest(&dynamodb.GetItemInput{
 		TableName: &table,
 		Key:       dynamoKeys})
-	res, err := req.Send(context.Background())
+	res, err := req.Send(d.requestContext())
 	if err != nil {
 		glog.Errorf("Error sending get request for %s %s", reflect.TypeOf(p), err)
 		return err
@@ -137,9 +251,88 @@ func (d *DynamoClient) GetProto(table string, keys map[string]string, p proto.Me
 }
 
 func (d *DynamoClient) PutProtoBatch(table *string, protos []proto.Message) error {
+	items := make([]interface{}, len(protos))
+	for idx, p := range protos {
+		items[idx] = p
+	}
+	return d.PutItemBatch(table, items)
+}
+
+// DeleteHearts - Deletes the heart counts of keys
+func (d *DynamoClient) DeleteHearts(keys []string) error {
+	itemKeys := make([]map[string]dynamodb.AttributeValue, 0, len(keys))
+	for i := range keys {
+		itemKeys = append(itemKeys, map[string]dynamodb.AttributeValue{HeartsTableKey: dynamodb.AttributeValue{S: &keys[i]}})
+	}
+	return d.deleteItemBatch(&HeartsTableName, itemKeys)
+}
+
+// DeleteFoods - Deletes the rows of foods from the Foods table
+func (d *DynamoClient) DeleteFoods(foods []*pb.Food) error {
+	itemKeys := make([]map[string]dynamodb.AttributeValue, 0, len(foods))
+	for _, food := range foods {
+		itemKeys = append(itemKeys, map[string]dynamodb.AttributeValue{
+			FoodTableNameKey: dynamodb.AttributeValue{S: aws.String(food.Key)},
+			DateKey:          dynamodb.AttributeValue{S: aws.String(food.Date)},
+		})
+	}
+	return d.deleteItemBatch(&FoodTableName, itemKeys)
+}
+
+// DeleteMenus - Deletes the rows of menus from the Menus table
+func (d *DynamoClient) DeleteMenus(menus []*pb.Menu) error {
+	itemKeys := make([]map[string]dynamodb.AttributeValue, 0, len(menus))
+	for _, menu := range menus {
+		itemKeys = append(itemKeys, map[string]dynamodb.AttributeValue{
+			DateKey:                    dynamodb.AttributeValue{S: aws.String(menu.Date)},
+			MenuTableDiningHallMealKey: dynamodb.AttributeValue{S: aws.String(menu.DiningHallMeal)},
+		})
+	}
+	return d.deleteItemBatch(&MenuTableName, itemKeys)
+}
+
+// DeleteFoodStats - Deletes the FoodStats of dates
+func (d *DynamoClient) DeleteFoodStats(dates []string) error {
+	itemKeys := make([]map[string]dynamodb.AttributeValue, 0, len(dates))
+	for i := range dates {
+		itemKeys = append(itemKeys, map[string]dynamodb.AttributeValue{FoodStatsDateKey: dynamodb.AttributeValue{S: &dates[i]}})
+	}
+	return d.deleteItemBatch(&FoodStatsTableName, itemKeys)
+}
+
+// Deletes the items with keys in batches of 25, returning the first error after attempting every batch
+func (d *DynamoClient) deleteItemBatch(table *string, keys []map[string]dynamodb.AttributeValue) error {
+	var firstErr error
+	for start := 0; start < len(keys); start += 25 {
+		end := start + 25
+		if end > len(keys) {
+			end = len(keys)
+		}
+		reqs := make([]dynamodb.WriteRequest, 0, end-start)
+		for _, key := range keys[start:end] {
+			reqs = append(reqs, dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: key}})
+		}
+		req := d.client.BatchWriteItemRequest(&dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]dynamodb.WriteRequest{*table: reqs}})
+		resp, err := req.Send(d.requestContext())
+		if err == nil && len(resp.UnprocessedItems[*table]) > 0 {
+			err = fmt.Errorf("%d deletes were not processed", len(resp.UnprocessedItems[*table]))
+		}
+		if err != nil {
+			glog.Errorf("Error batch deleting %s: %s", *table, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// PutItemBatch - Puts items marshalled with dynamodbattribute in batches of 25
+func (d *DynamoClient) PutItemBatch(table *string, items []interface{}) error {
 	reqs := make([]dynamodb.WriteRequest, 0)
-	for _, p := range protos {
-		av, err := dynamodbattribute.MarshalMap(&p)
+	for _, p := range items {
+		av, err := dynamodbattribute.MarshalMap(p)
 		if err != nil {
 			return err
 		}
@@ -158,9 +351,9 @@ func (d *DynamoClient) PutProtoBatch(table *string, protos []proto.Message) erro
 		req := d.client.BatchWriteItemRequest(&dynamodb.BatchWriteItemInput{
 			RequestItems: map[string][]dynamodb.WriteRequest{
 				*table: reqs[startIdx:]}})
-		_, err := req.Send(context.Background())
+		_, err := req.Send(d.requestContext())
 		if err != nil {
-			glog.Errorf("Error batch putting %s %s", reflect.TypeOf(protos), err)
+			glog.Errorf("Error batch putting %s %s", *table, err)
 			problematicReqs = append(problematicReqs, reqs[startIdx:]...)
 		}
 		reqs = reqs[:startIdx]
@@ -172,12 +365,12 @@ func (d *DynamoClient) PutProtoBatch(table *string, protos []proto.Message) erro
 		req := d.client.BatchWriteItemRequest(&dynamodb.BatchWriteItemInput{
 			RequestItems: map[string][]dynamodb.WriteRequest{
 				*table: []dynamodb.WriteRequest{probReq}}})
-		_, err := req.Send(context.Background())
+		_, err := req.Send(d.requestContext())
 		if err != nil {
 			glog.Errorf("Error putting item %s", err)
 		}
 	}
-	glog.Infof("Successful Batch Put %s", reflect.TypeOf(protos))
+	glog.Infof("Successful Batch Put %s", *table)
 	return nil
 }
 
@@ -191,7 +384,7 @@ func (d *DynamoClient) PutProto(table *string, p proto.Message) error {
 	req := d.client.PutItemRequest(&dynamodb.PutItemInput{
 		TableName: table,
 		Item:      av})
-	_, err = req.Send(context.Background())
+	_, err = req.Send(d.requestContext())
 	if err != nil {
 		glog.Errorf("Error putting item %s", err)
 		return err
@@ -0,0 +1,41 @@
+package dynamoclient
+
+import (
+	"github.com/MichiganDiningAPI/internal/processing/cooccurrence"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+)
+
+// GetFoodAssociations - Returns the stored associations of a food, nil if there are none
+func (d *DynamoClient) GetFoodAssociations(key string) (*cooccurrence.FoodAssociations, error) {
+	k, err := dynamodbattribute.Marshal(&key)
+	if err != nil {
+		return nil, err
+	}
+	req := d.client.GetItemRequest(&dynamodb.GetItemInput{
+		TableName: aws.String(FoodAssociationsTableName),
+		Key:       map[string]dynamodb.AttributeValue{FoodAssociationsTableKey: *k}})
+	res, err := req.Send(d.requestContext())
+	if err != nil {
+		return nil, err
+	}
+	if res.Item == nil {
+		return nil, nil
+	}
+	associations := cooccurrence.FoodAssociations{}
+	err = dynamodbattribute.UnmarshalMap(res.Item, &associations)
+	if err != nil {
+		return nil, err
+	}
+	return &associations, nil
+}
+
+// PutFoodAssociations - Stores associations, replacing the previous associations of each food
+func (d *DynamoClient) PutFoodAssociations(associations []*cooccurrence.FoodAssociations) error {
+	items := make([]interface{}, len(associations))
+	for idx, a := range associations {
+		items[idx] = a
+	}
+	return d.PutItemBatch(&FoodAssociationsTableName, items)
+}
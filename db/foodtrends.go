@@ -0,0 +1,53 @@
+package dynamoclient
+
+import (
+	"github.com/MichiganDiningAPI/internal/processing/foodtrends"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/expression"
+	"github.com/golang/glog"
+)
+
+// QueryFoodTrends - Returns the stored trends for a window.
+// Trends for foods which were not served in the latest run are kept in the
+// table, callers should only use trends with the latest WindowEnd.
+func (d *DynamoClient) QueryFoodTrends(windowWeeks int) ([]*foodtrends.Trend, error) {
+	glog.Infof("QueryFoodTrends %d", windowWeeks)
+	keyCond := expression.Key(FoodTrendsWindowKey).Equal(expression.Value(windowWeeks))
+	expr, _ := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	params := &dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(FoodTrendsTableName),
+	}
+	req := d.client.QueryRequest(params)
+	p := dynamodb.NewQueryPaginator(req)
+
+	trends := []*foodtrends.Trend{}
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			trend := foodtrends.Trend{}
+			err := dynamodbattribute.UnmarshalMap(item, &trend)
+			if err != nil {
+				return nil, err
+			}
+			trends = append(trends, &trend)
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return trends, nil
+}
+
+// PutFoodTrends - Stores trends, replacing previous trends for the same window and food
+func (d *DynamoClient) PutFoodTrends(trends []*foodtrends.Trend) error {
+	items := make([]interface{}, len(trends))
+	for idx, trend := range trends {
+		items[idx] = trend
+	}
+	return d.PutItemBatch(&FoodTrendsTableName, items)
+}
@@ -0,0 +1,43 @@
+package dynamoclient
+
+import (
+	"github.com/MichiganDiningAPI/internal/processing/hallstats"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+)
+
+// QueryDiningHallSummaries - Returns the summary statistics of every dining hall
+func (d *DynamoClient) QueryDiningHallSummaries() ([]*hallstats.Summary, error) {
+	params := &dynamodb.ScanInput{
+		TableName: aws.String(DiningHallSummariesTableName),
+	}
+	req := d.client.ScanRequest(params)
+	p := dynamodb.NewScanPaginator(req)
+
+	summaries := []*hallstats.Summary{}
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			summary := hallstats.Summary{}
+			err := dynamodbattribute.UnmarshalMap(item, &summary)
+			if err != nil {
+				return nil, err
+			}
+			summaries = append(summaries, &summary)
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// PutDiningHallSummaries - Stores summaries, replacing the previous summary of each dining hall
+func (d *DynamoClient) PutDiningHallSummaries(summaries []*hallstats.Summary) error {
+	items := make([]interface{}, len(summaries))
+	for idx, summary := range summaries {
+		items[idx] = summary
+	}
+	return d.PutItemBatch(&DiningHallSummariesTableName, items)
+}
@@ -0,0 +1,84 @@
+package dynamoclient
+
+import (
+	"github.com/MichiganDiningAPI/internal/processing/nutrition"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/expression"
+)
+
+// QueryNutritionAggregates - Returns the nutrition aggregates of every meal on a date, optionally for a single dining hall
+func (d *DynamoClient) QueryNutritionAggregates(date string, diningHallName *string) ([]*nutrition.Aggregate, error) {
+	keyCond := expression.Key(NutritionDateKey).Equal(expression.Value(date))
+	if diningHallName != nil {
+		keyCond = keyCond.And(expression.Key(NutritionDiningHallMealKey).BeginsWith(*diningHallName))
+	}
+	expr, _ := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	req := d.client.QueryRequest(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(NutritionAggregatesTableName),
+	})
+	p := dynamodb.NewQueryPaginator(req)
+
+	aggregates := []*nutrition.Aggregate{}
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			aggregate := nutrition.Aggregate{}
+			err := dynamodbattribute.UnmarshalMap(item, &aggregate)
+			if err != nil {
+				return nil, err
+			}
+			aggregates = append(aggregates, &aggregate)
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return aggregates, nil
+}
+
+// PutNutritionAggregates - Stores aggregates, replacing the previous aggregate of each dining hall meal
+func (d *DynamoClient) PutNutritionAggregates(aggregates []*nutrition.Aggregate) error {
+	items := make([]interface{}, len(aggregates))
+	for idx, aggregate := range aggregates {
+		items[idx] = aggregate
+	}
+	return d.PutItemBatch(&NutritionAggregatesTableName, items)
+}
+
+// GetFoodNutrition - Returns the stored nutrition history of a food, nil if there is none
+func (d *DynamoClient) GetFoodNutrition(key string) (*nutrition.FoodNutrition, error) {
+	k, err := dynamodbattribute.Marshal(&key)
+	if err != nil {
+		return nil, err
+	}
+	req := d.client.GetItemRequest(&dynamodb.GetItemInput{
+		TableName: aws.String(FoodNutritionTableName),
+		Key:       map[string]dynamodb.AttributeValue{FoodNutritionTableKey: *k}})
+	res, err := req.Send(d.requestContext())
+	if err != nil {
+		return nil, err
+	}
+	if res.Item == nil {
+		return nil, nil
+	}
+	food := nutrition.FoodNutrition{}
+	err = dynamodbattribute.UnmarshalMap(res.Item, &food)
+	if err != nil {
+		return nil, err
+	}
+	return &food, nil
+}
+
+// PutFoodNutrition - Stores nutrition histories, replacing the previous history of each food
+func (d *DynamoClient) PutFoodNutrition(foods []*nutrition.FoodNutrition) error {
+	items := make([]interface{}, len(foods))
+	for idx, food := range foods {
+		items[idx] = food
+	}
+	return d.PutItemBatch(&FoodNutritionTableName, items)
+}
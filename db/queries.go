@@ -1,7 +1,6 @@
 package dynamoclient
 
 import (
-	"context"
 	"errors"
 
 	pb "github.com/anders617/mdining-proto/proto/mdining"
@@ -21,7 +20,7 @@ func (d *DynamoClient) QueryFoodStats() (*[]*pb.FoodStat, error) {
 	p := dynamodb.NewScanPaginator(req)
 
 	foodStats := make([]*pb.FoodStat, 0)
-	for p.Next(context.Background()) {
+	for p.Next(d.requestContext()) {
 		page := p.CurrentPage()
 		for _, item := range page.Items {
 			stat := pb.FoodStat{}
@@ -35,6 +34,45 @@ func (d *DynamoClient) QueryFoodStats() (*[]*pb.FoodStat, error) {
 	return &foodStats, nil
 }
 
+// ForEachFoodStat - Calls fn with every FoodStat dated between startDate and endDate (both optional), one page at a time
+func (d *DynamoClient) ForEachFoodStat(startDate *string, endDate *string, fn func(*pb.FoodStat)) error {
+	params := &dynamodb.ScanInput{
+		TableName: aws.String(FoodStatsTableName),
+	}
+	if startDate != nil || endDate != nil {
+		var filter expression.ConditionBuilder
+		if startDate != nil && endDate != nil {
+			filter = expression.Name(DateKey).Between(expression.Value(*startDate), expression.Value(*endDate))
+		} else if startDate != nil {
+			filter = expression.Name(DateKey).GreaterThanEqual(expression.Value(*startDate))
+		} else {
+			filter = expression.Name(DateKey).LessThanEqual(expression.Value(*endDate))
+		}
+		expr, _ := expression.NewBuilder().WithFilter(filter).Build()
+		params.FilterExpression = expr.Filter()
+		params.ExpressionAttributeNames = expr.Names()
+		params.ExpressionAttributeValues = expr.Values()
+	}
+	req := d.client.ScanRequest(params)
+	p := dynamodb.NewScanPaginator(req)
+
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			stat := pb.FoodStat{}
+			if err := dynamodbattribute.UnmarshalMap(item, &stat); err != nil {
+				return err
+			}
+			fn(&stat)
+		}
+	}
+
+	if err := p.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (d *DynamoClient) ForEachFood(startDate *string, endDate *string, fn func(*pb.Food)) error {
 	var filter expression.ConditionBuilder
 	if startDate != nil && endDate != nil {
@@ -48,7 +86,6 @@ func (d *DynamoClient) ForEachFood(startDate *string, endDate *string, fn func(*
 		TableName: aws.String(FoodTableName),
 	}
 	if startDate != nil || endDate != nil {
-		glog.Info(*startDate)
 		expr, _ := expression.NewBuilder().WithFilter(filter).Build()
 		params.FilterExpression = expr.Filter()
 		params.ExpressionAttributeNames = expr.Names()
@@ -57,7 +94,7 @@ func (d *DynamoClient) ForEachFood(startDate *string, endDate *string, fn func(*
 	req := d.client.ScanRequest(params)
 	p := dynamodb.NewScanPaginator(req)
 
-	for p.Next(context.Background()) {
+	for p.Next(d.requestContext()) {
 		page := p.CurrentPage()
 		for _, item := range page.Items {
 			food := pb.Food{}
@@ -72,13 +109,79 @@ func (d *DynamoClient) ForEachFood(startDate *string, endDate *string, fn func(*
 	return nil
 }
 
+// ForEachMenu - Calls fn with every menu dated between startDate and endDate (both optional)
+func (d *DynamoClient) ForEachMenu(startDate *string, endDate *string, fn func(*pb.Menu)) error {
+	params := &dynamodb.ScanInput{
+		TableName: aws.String(MenuTableName),
+	}
+	if startDate != nil || endDate != nil {
+		var filter expression.ConditionBuilder
+		if startDate != nil && endDate != nil {
+			filter = expression.Name(DateKey).Between(expression.Value(*startDate), expression.Value(*endDate))
+		} else if startDate != nil {
+			filter = expression.Name(DateKey).GreaterThanEqual(expression.Value(*startDate))
+		} else {
+			filter = expression.Name(DateKey).LessThanEqual(expression.Value(*endDate))
+		}
+		expr, _ := expression.NewBuilder().WithFilter(filter).Build()
+		params.FilterExpression = expr.Filter()
+		params.ExpressionAttributeNames = expr.Names()
+		params.ExpressionAttributeValues = expr.Values()
+	}
+	req := d.client.ScanRequest(params)
+	p := dynamodb.NewScanPaginator(req)
+
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			menu := pb.Menu{}
+			dynamodbattribute.UnmarshalMap(item, &menu)
+			fn(&menu)
+		}
+	}
+
+	if err := p.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// QueryMenusOnDate - Returns every menu on date, reading all pages of the query
+func (d *DynamoClient) QueryMenusOnDate(date string) ([]*pb.Menu, error) {
+	keyCond := expression.Key(DateKey).Equal(expression.Value(date))
+	expr, _ := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	req := d.client.QueryRequest(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(MenuTableName),
+	})
+	p := dynamodb.NewQueryPaginator(req)
+
+	menus := []*pb.Menu{}
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			menu := pb.Menu{}
+			if err := dynamodbattribute.UnmarshalMap(item, &menu); err != nil {
+				return nil, err
+			}
+			menus = append(menus, &menu)
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return menus, nil
+}
+
 func (d *DynamoClient) QueryDiningHalls() (*pb.DiningHalls, error) {
 	params := &dynamodb.ScanInput{
 		TableName: aws.String(DiningHallsTableName),
 	}
 	// Make the DynamoDB Query API call
 	req := d.client.ScanRequest(params)
-	result, err := req.Send(context.Background())
+	result, err := req.Send(d.requestContext())
 	if err != nil {
 		return nil, err
 	}
@@ -149,7 +252,7 @@ func (d *DynamoClient) QueryFoods(name *string, date *string) (*[]*pb.Food, erro
 
 func (d *DynamoClient) queryFoods(params *dynamodb.QueryInput) (*[]*pb.Food, error) {
 	req := d.client.QueryRequest(params)
-	result, err := req.Send(context.Background())
+	result, err := req.Send(d.requestContext())
 	if err != nil {
 		return nil, err
 	}
@@ -210,7 +313,7 @@ func (d *DynamoClient) QueryMenus(diningHallName *string, date *string, meal *st
 // Execute a query with the given parameters and marshal the output into a slice of *pb.Menu
 func (d *DynamoClient) queryMenus(params *dynamodb.QueryInput) (*[]*pb.Menu, error) {
 	req := d.client.QueryRequest(params)
-	result, err := req.Send(context.Background())
+	result, err := req.Send(d.requestContext())
 	if err != nil {
 		return nil, err
 	}
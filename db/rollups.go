@@ -0,0 +1,66 @@
+package dynamoclient
+
+import (
+	"github.com/MichiganDiningAPI/internal/processing/rollups"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/expression"
+	"github.com/golang/glog"
+)
+
+// QueryRollups - Returns the rollups of a granularity whose period starts between startPeriod and endPeriod (both optional)
+func (d *DynamoClient) QueryRollups(granularity string, startPeriod *string, endPeriod *string) ([]*rollups.Rollup, error) {
+	glog.Infof("QueryRollups %s %v %v", granularity, startPeriod, endPeriod)
+	keyCond := expression.Key(RollupGranularityKey).Equal(expression.Value(granularity))
+	if startPeriod != nil && endPeriod != nil {
+		keyCond = keyCond.And(expression.Key(RollupPeriodKey).Between(expression.Value(*startPeriod), expression.Value(*endPeriod)))
+	} else if startPeriod != nil {
+		keyCond = keyCond.And(expression.Key(RollupPeriodKey).GreaterThanEqual(expression.Value(*startPeriod)))
+	} else if endPeriod != nil {
+		keyCond = keyCond.And(expression.Key(RollupPeriodKey).LessThanEqual(expression.Value(*endPeriod)))
+	}
+	expr, _ := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	params := &dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(FoodStatRollupsTableName),
+	}
+	req := d.client.QueryRequest(params)
+	p := dynamodb.NewQueryPaginator(req)
+
+	result := []*rollups.Rollup{}
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			rollup := rollups.Rollup{}
+			err := dynamodbattribute.UnmarshalMap(item, &rollup)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, &rollup)
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// PutRollup - Stores a rollup, replacing any previous rollup for the same period
+func (d *DynamoClient) PutRollup(rollup *rollups.Rollup) error {
+	av, err := dynamodbattribute.MarshalMap(rollup)
+	if err != nil {
+		return err
+	}
+	req := d.client.PutItemRequest(&dynamodb.PutItemInput{
+		TableName: aws.String(FoodStatRollupsTableName),
+		Item:      av})
+	_, err = req.Send(d.requestContext())
+	if err != nil {
+		glog.Errorf("Error putting %s rollup %s: %s", rollup.Granularity, rollup.Period, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package dynamoclient
+
+import (
+	"github.com/MichiganDiningAPI/internal/processing/rotation"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+)
+
+// QueryMenuRotations - Returns the detected menu rotation of every dining hall
+func (d *DynamoClient) QueryMenuRotations() ([]*rotation.Rotation, error) {
+	params := &dynamodb.ScanInput{
+		TableName: aws.String(MenuRotationsTableName),
+	}
+	req := d.client.ScanRequest(params)
+	p := dynamodb.NewScanPaginator(req)
+
+	rotations := []*rotation.Rotation{}
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			r := rotation.Rotation{}
+			err := dynamodbattribute.UnmarshalMap(item, &r)
+			if err != nil {
+				return nil, err
+			}
+			rotations = append(rotations, &r)
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return rotations, nil
+}
+
+// PutMenuRotations - Stores rotations, replacing the previous rotation of each dining hall
+func (d *DynamoClient) PutMenuRotations(rotations []*rotation.Rotation) error {
+	items := make([]interface{}, len(rotations))
+	for idx, r := range rotations {
+		items[idx] = r
+	}
+	return d.PutItemBatch(&MenuRotationsTableName, items)
+}
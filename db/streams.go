@@ -1,7 +1,6 @@
 package dynamoclient
 
 import (
-	"context"
 	"errors"
 	"sync"
 	"time"
@@ -144,7 +143,7 @@ func (d *DynamoClient) getRecords(shardIterator string) (*string, *[]dynamodbstr
 	}
 	req := d.streamClient.GetRecordsRequest(&params)
 
-	resp, err := req.Send(context.Background())
+	resp, err := req.Send(d.requestContext())
 	if err != nil {
 		return nil, nil, err
 	}
@@ -160,7 +159,7 @@ func (d *DynamoClient) getShardIterator(arn string, shard dynamodbstreams.Shard)
 
 	req := d.streamClient.GetShardIteratorRequest(&params)
 
-	resp, err := req.Send(context.Background())
+	resp, err := req.Send(d.requestContext())
 	if err != nil {
 		return nil, err
 	}
@@ -173,7 +172,7 @@ func (d *DynamoClient) getStreamShards(arn string) (*[]dynamodbstreams.Shard, er
 	}
 	req := d.streamClient.DescribeStreamRequest(&params)
 
-	resp, err := req.Send(context.Background())
+	resp, err := req.Send(d.requestContext())
 	if err != nil {
 		return nil, err
 	}
@@ -187,7 +186,7 @@ func (d *DynamoClient) getTableStreamArn(table string) (*string, error) {
 	}
 	req := d.streamClient.ListStreamsRequest(&params)
 
-	resp, err := req.Send(context.Background())
+	resp, err := req.Send(d.requestContext())
 	if err != nil { // resp is now filled
 		return nil, err
 	}
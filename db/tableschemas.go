@@ -11,17 +11,55 @@ var (
 	FoodTableName        = "Foods"
 	FoodStatsTableName   = "FoodStats"
 	HeartsTableName      = "Hearts"
+	// Baseline of upstream json fields used for schema drift detection
+	UpstreamSchemasTableName = "UpstreamSchemas"
+	// Watermarks and dirty dates used for incremental analysis
+	AnalysisStateTableName = "AnalysisState"
+	// FoodStats aggregated by week, month and academic term
+	FoodStatRollupsTableName = "FoodStatRollups"
+	// Per food trends over each analysis window
+	FoodTrendsTableName = "FoodTrends"
+	// Detected menu cycles and projected menus per dining hall
+	MenuRotationsTableName = "MenuRotations"
+	// Foods most often served on the same meal as each food
+	FoodAssociationsTableName = "FoodAssociations"
+	// Summary statistics per dining hall
+	DiningHallSummariesTableName = "DiningHallSummaries"
+	// Nutrition aggregates per dining hall meal, keyed like Menus
+	NutritionAggregatesTableName = "NutritionAggregates"
+	// Nutrition history of each food
+	FoodNutritionTableName = "FoodNutrition"
+	// API keys issued to known consumers, with their quota tier
+	APIKeysTableName = "APIKeys"
+	// Daily request counts of each API key
+	APIKeyUsageTableName = "APIKeyUsage"
+	// Generation of the data, bumped whenever fetch or analyze finish writing
+	DataGenerationTableName = "DataGeneration"
 )
 
 var (
-	NameKey                    = "name"
-	DiningHallDateMealKey      = "key"
-	DateKey                    = "date"
-	NameDateKey                = "key"
-	FoodTableNameKey           = "key"
-	MenuTableDiningHallMealKey = "diningHallMeal"
-	FoodStatsDateKey           = "date"
-	HeartsTableKey             = "key"
+	NameKey                     = "name"
+	DiningHallDateMealKey       = "key"
+	DateKey                     = "date"
+	NameDateKey                 = "key"
+	FoodTableNameKey            = "key"
+	MenuTableDiningHallMealKey  = "diningHallMeal"
+	FoodStatsDateKey            = "date"
+	HeartsTableKey              = "key"
+	UpstreamSchemasTableKey     = "endpoint"
+	RollupGranularityKey        = "granularity"
+	RollupPeriodKey             = "period"
+	FoodTrendsWindowKey         = "windowWeeks"
+	FoodTrendsFoodKey           = "key"
+	MenuRotationsDiningHallKey  = "diningHall"
+	FoodAssociationsTableKey    = "key"
+	DiningHallSummariesTableKey = "diningHall"
+	NutritionDateKey            = "date"
+	NutritionDiningHallMealKey  = "diningHallMeal"
+	FoodNutritionTableKey       = "key"
+	APIKeyIDKey                 = "id"
+	APIKeyUsageIDKey            = "id"
+	APIKeyUsageDateKey          = "date"
 )
 
 var (
@@ -36,7 +74,19 @@ var (
 		MenuTableName,
 		FoodTableName,
 		FoodStatsTableName,
-		HeartsTableName}
+		HeartsTableName,
+		UpstreamSchemasTableName,
+		AnalysisStateTableName,
+		FoodStatRollupsTableName,
+		FoodTrendsTableName,
+		MenuRotationsTableName,
+		FoodAssociationsTableName,
+		DiningHallSummariesTableName,
+		NutritionAggregatesTableName,
+		FoodNutritionTableName,
+		APIKeysTableName,
+		APIKeyUsageTableName,
+		DataGenerationTableName}
 	TableKeys = map[string][]dynamodb.KeySchemaElement{
 		DiningHallsTableName: []dynamodb.KeySchemaElement{
 			dynamodb.KeySchemaElement{
@@ -69,6 +119,82 @@ var (
 			dynamodb.KeySchemaElement{
 				AttributeName: &HeartsTableKey,
 				KeyType:       "HASH",
+			}},
+		UpstreamSchemasTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &UpstreamSchemasTableKey,
+				KeyType:       "HASH",
+			}},
+		AnalysisStateTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &NameKey,
+				KeyType:       "HASH",
+			}},
+		FoodStatRollupsTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &RollupGranularityKey,
+				KeyType:       "HASH",
+			},
+			dynamodb.KeySchemaElement{
+				AttributeName: &RollupPeriodKey,
+				KeyType:       "RANGE",
+			}},
+		FoodTrendsTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &FoodTrendsWindowKey,
+				KeyType:       "HASH",
+			},
+			dynamodb.KeySchemaElement{
+				AttributeName: &FoodTrendsFoodKey,
+				KeyType:       "RANGE",
+			}},
+		MenuRotationsTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &MenuRotationsDiningHallKey,
+				KeyType:       "HASH",
+			}},
+		FoodAssociationsTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &FoodAssociationsTableKey,
+				KeyType:       "HASH",
+			}},
+		DiningHallSummariesTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &DiningHallSummariesTableKey,
+				KeyType:       "HASH",
+			}},
+		NutritionAggregatesTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &NutritionDateKey,
+				KeyType:       "HASH",
+			},
+			dynamodb.KeySchemaElement{
+				AttributeName: &NutritionDiningHallMealKey,
+				KeyType:       "RANGE",
+			}},
+		FoodNutritionTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &FoodNutritionTableKey,
+				KeyType:       "HASH",
+			}},
+		APIKeysTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &APIKeyIDKey,
+				KeyType:       "HASH",
+			}},
+		APIKeyUsageTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &APIKeyUsageIDKey,
+				KeyType:       "HASH",
+			},
+			dynamodb.KeySchemaElement{
+				AttributeName: &APIKeyUsageDateKey,
+				KeyType:       "RANGE",
+			}},
+		DataGenerationTableName: []dynamodb.KeySchemaElement{
+			dynamodb.KeySchemaElement{
+				AttributeName: &NameKey,
+				KeyType:       "HASH",
 			}}}
 	TableAttributes = map[string][]dynamodb.AttributeDefinition{
 		DiningHallsTableName: []dynamodb.AttributeDefinition{
@@ -100,13 +226,85 @@ var (
 		HeartsTableName: []dynamodb.AttributeDefinition{
 			dynamodb.AttributeDefinition{
 				AttributeName: &HeartsTableKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		UpstreamSchemasTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &UpstreamSchemasTableKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		AnalysisStateTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &NameKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		FoodStatRollupsTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &RollupGranularityKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS},
+			dynamodb.AttributeDefinition{
+				AttributeName: &RollupPeriodKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		FoodTrendsTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &FoodTrendsWindowKey,
+				AttributeType: dynamodb.ScalarAttributeTypeN},
+			dynamodb.AttributeDefinition{
+				AttributeName: &FoodTrendsFoodKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		MenuRotationsTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &MenuRotationsDiningHallKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		FoodAssociationsTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &FoodAssociationsTableKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		DiningHallSummariesTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &DiningHallSummariesTableKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		NutritionAggregatesTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &NutritionDateKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS},
+			dynamodb.AttributeDefinition{
+				AttributeName: &NutritionDiningHallMealKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		FoodNutritionTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &FoodNutritionTableKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		APIKeysTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &APIKeyIDKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		APIKeyUsageTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &APIKeyUsageIDKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS},
+			dynamodb.AttributeDefinition{
+				AttributeName: &APIKeyUsageDateKey,
+				AttributeType: dynamodb.ScalarAttributeTypeS}},
+		DataGenerationTableName: []dynamodb.AttributeDefinition{
+			dynamodb.AttributeDefinition{
+				AttributeName: &NameKey,
 				AttributeType: dynamodb.ScalarAttributeTypeS}}}
 	TableStreamSpecs = map[string]dynamodb.StreamSpecification{
-		DiningHallsTableName: dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
-		ItemsTableName:       dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
-		MenuTableName:        dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
-		FoodTableName:        dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
-		FoodStatsTableName:   dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
-		HeartsTableName:      dynamodb.StreamSpecification{StreamEnabled: &trueValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		DiningHallsTableName:         dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		ItemsTableName:               dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		MenuTableName:                dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		FoodTableName:                dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		FoodStatsTableName:           dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		HeartsTableName:              dynamodb.StreamSpecification{StreamEnabled: &trueValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		UpstreamSchemasTableName:     dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		AnalysisStateTableName:       dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		FoodStatRollupsTableName:     dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		FoodTrendsTableName:          dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		MenuRotationsTableName:       dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		FoodAssociationsTableName:    dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		DiningHallSummariesTableName: dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		NutritionAggregatesTableName: dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		FoodNutritionTableName:       dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		APIKeysTableName:             dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		APIKeyUsageTableName:         dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
+		DataGenerationTableName:      dynamodb.StreamSpecification{StreamEnabled: &falseValue, StreamViewType: dynamodb.StreamViewTypeNewImage},
 	}
 )
@@ -0,0 +1,53 @@
+package dynamoclient
+
+import (
+	"github.com/MichiganDiningAPI/api/mdining/schemawatch"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"github.com/golang/glog"
+)
+
+// QueryUpstreamSchemas - Returns the stored upstream schema baselines keyed by endpoint
+func (d *DynamoClient) QueryUpstreamSchemas() (map[string]*schemawatch.Schema, error) {
+	params := &dynamodb.ScanInput{
+		TableName: aws.String(UpstreamSchemasTableName),
+	}
+	req := d.client.ScanRequest(params)
+	p := dynamodb.NewScanPaginator(req)
+
+	schemas := map[string]*schemawatch.Schema{}
+	for p.Next(d.requestContext()) {
+		page := p.CurrentPage()
+		for _, item := range page.Items {
+			schema := schemawatch.Schema{}
+			err := dynamodbattribute.UnmarshalMap(item, &schema)
+			if err != nil {
+				return nil, err
+			}
+			schemas[schema.Endpoint] = &schema
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+// PutUpstreamSchema - Stores schema as the baseline for its endpoint
+func (d *DynamoClient) PutUpstreamSchema(schema *schemawatch.Schema) error {
+	av, err := dynamodbattribute.MarshalMap(schema)
+	if err != nil {
+		return err
+	}
+	req := d.client.PutItemRequest(&dynamodb.PutItemInput{
+		TableName: aws.String(UpstreamSchemasTableName),
+		Item:      av})
+	_, err = req.Send(d.requestContext())
+	if err != nil {
+		glog.Errorf("Error putting upstream schema %s: %s", schema.Endpoint, err)
+		return err
+	}
+	glog.Infof("Successfully Put upstream schema %s", schema.Endpoint)
+	return nil
+}
@@ -0,0 +1,206 @@
+package autocomplete
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	"github.com/MichiganDiningAPI/internal/util/date"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Type ahead suggestions of food and dining hall names
+//
+// Every word of a name starts an entry of a sorted array, so a prefix matches
+// both "chicken tenders" and "tenders" with a binary search. Matches are ranked by
+//     HeartWeight * ln(1 + hearts) + ln(1 + recent servings)
+// plus startBonus when the prefix matches the start of the name. Recent
+// servings are counted over the RecentDays before today and the published
+// menus after it. Dining halls have no hearts and are ranked by meals served.
+//
+
+// Suggestion types
+const (
+	Food       = "food"
+	DiningHall = "diningHall"
+)
+
+// DefaultLimit - Number of suggestions returned when a request has no limit
+const DefaultLimit = 10
+
+// Bonus for matching the start of a name rather than a later word
+const startBonus = 1.0
+
+// Options - Parameters of the ranking
+type Options struct {
+	RecentDays  int
+	HeartWeight float64
+}
+
+// DefaultOptions - Four weeks of servings with hearts weighted half as much
+var DefaultOptions = Options{RecentDays: 28, HeartWeight: 0.5}
+
+// Suggestion - A name completing a prefix
+type Suggestion struct {
+	Text           string  `json:"text"`
+	Key            string  `json:"key"`
+	Type           string  `json:"type"`
+	Hearts         int64   `json:"hearts"`
+	RecentServings int64   `json:"recentServings"`
+	Score          float64 `json:"score"`
+}
+
+type doc struct {
+	text   string
+	key    string
+	kind   string
+	hearts int64
+	recent int64
+}
+
+type entry struct {
+	// Lowercase name starting at one of its words
+	text  string
+	doc   int
+	start bool
+}
+
+// Index - Prefix index over names
+type Index struct {
+	opts    Options
+	docs    []*doc
+	entries []entry
+	// Map from food key to doc, for heart updates
+	foods map[string]int
+	// Guards heart counts, which change as hearts are added
+	mu sync.RWMutex
+}
+
+// Build - Indexes the foods served on or after RecentDays before today, using the display names of items
+// where known, and every dining hall
+func Build(stats []*pb.FoodStat, items *pb.Items, diningHalls *pb.DiningHalls, hearts []*pb.HeartCount, today time.Time, opts Options) *Index {
+	index := &Index{opts: opts, foods: map[string]int{}}
+	halls := map[string]int{}
+	recentStart := date.FormatNoTime(date.DayStart(today).AddDate(0, 0, -opts.RecentDays))
+	addFood := func(key string, text string) *doc {
+		id, exists := index.foods[key]
+		if !exists {
+			id = len(index.docs)
+			index.foods[key] = id
+			index.docs = append(index.docs, &doc{text: text, key: key, kind: Food})
+		}
+		return index.docs[id]
+	}
+	for _, stat := range stats {
+		if stat.Date < recentStart {
+			continue
+		}
+		for key, count := range stat.TimesServed {
+			addFood(foodnames.Key(key), key).recent += count
+		}
+		for name, count := range stat.DiningHallMealsServed {
+			id, exists := halls[name]
+			if !exists {
+				id = len(index.docs)
+				halls[name] = id
+				index.docs = append(index.docs, &doc{text: name, key: name, kind: DiningHall})
+			}
+			index.docs[id].recent += count
+		}
+	}
+	if items != nil {
+		for key, item := range items.Items {
+			addFood(foodnames.Key(key), item.Name).text = item.Name
+		}
+	}
+	if diningHalls != nil {
+		for _, hall := range diningHalls.DiningHalls {
+			if _, exists := halls[hall.Name]; !exists {
+				halls[hall.Name] = len(index.docs)
+				index.docs = append(index.docs, &doc{text: hall.Name, key: hall.Name, kind: DiningHall})
+			}
+		}
+	}
+	for _, heartCount := range hearts {
+		if id, exists := index.foods[foodnames.Key(heartCount.Key)]; exists {
+			index.docs[id].hearts = heartCount.Count
+		}
+	}
+	for id, d := range index.docs {
+		words := strings.Fields(strings.ToLower(d.text))
+		for i := range words {
+			index.entries = append(index.entries, entry{text: strings.Join(words[i:], " "), doc: id, start: i == 0})
+		}
+	}
+	sort.Slice(index.entries, func(i, j int) bool { return index.entries[i].text < index.entries[j].text })
+	return index
+}
+
+// Len - Number of names in the index
+func (index *Index) Len() int {
+	return len(index.docs)
+}
+
+// SetHearts - Updates the heart count of a food
+func (index *Index) SetHearts(key string, count int64) {
+	id, exists := index.foods[foodnames.Key(key)]
+	if !exists {
+		return
+	}
+	index.mu.Lock()
+	index.docs[id].hearts = count
+	index.mu.Unlock()
+}
+
+// Complete - Returns up to limit names with a word starting with prefix, best ranked first
+func (index *Index) Complete(prefix string, limit int) []*Suggestion {
+	prefix = strings.Join(strings.Fields(strings.ToLower(prefix)), " ")
+	if prefix == "" {
+		return []*Suggestion{}
+	}
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	// Best score of each doc matching the prefix
+	scores := map[int]float64{}
+	first := sort.Search(len(index.entries), func(i int) bool { return index.entries[i].text >= prefix })
+	for i := first; i < len(index.entries) && strings.HasPrefix(index.entries[i].text, prefix); i++ {
+		e := index.entries[i]
+		d := index.docs[e.doc]
+		score := index.opts.HeartWeight*math.Log1p(float64(d.hearts)) + math.Log1p(float64(d.recent))
+		if e.start {
+			score += startBonus
+		}
+		if best, exists := scores[e.doc]; !exists || score > best {
+			scores[e.doc] = score
+		}
+	}
+	suggestions := make([]*Suggestion, 0, len(scores))
+	for id, score := range scores {
+		d := index.docs[id]
+		suggestions = append(suggestions, &Suggestion{
+			Text:           d.text,
+			Key:            d.key,
+			Type:           d.kind,
+			Hearts:         d.hearts,
+			RecentServings: d.recent,
+			Score:          score,
+		})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Text < suggestions[j].Text
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
@@ -0,0 +1,99 @@
+package autocomplete
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+func testIndex() *Index {
+	stats := []*pb.FoodStat{
+		// Before the recent days, so not indexed
+		{Date: "2019-12-01", TimesServed: map[string]int64{"old soup": 50}},
+		{
+			Date:                  "2020-01-10",
+			TimesServed:           map[string]int64{"chicken tenders": 4, "chicken noodle soup": 1, "tenderloin": 2},
+			DiningHallMealsServed: map[string]int64{"Bursley": 30, "East Quad": 10},
+		},
+		// Published menus after today count as recent servings
+		{Date: "2020-02-01", TimesServed: map[string]int64{"chicken tenders": 2}},
+	}
+	items := &pb.Items{Items: map[string]*pb.Item{"chicken tenders": {Name: "Chicken Tenders"}}}
+	diningHalls := &pb.DiningHalls{DiningHalls: []*pb.DiningHall{{Name: "Bursley"}, {Name: "East Quad"}, {Name: "Chicago House"}}}
+	hearts := []*pb.HeartCount{{Key: "chicken noodle soup", Count: 100}}
+	today := time.Date(2020, 1, 29, 12, 0, 0, 0, time.Local)
+	return Build(stats, items, diningHalls, hearts, today, Options{RecentDays: 28, HeartWeight: 0.5})
+}
+
+func TestComplete(t *testing.T) {
+	index := testIndex()
+	tests := []struct {
+		name   string
+		prefix string
+		limit  int
+		want   []string
+	}{
+		// 0.5 ln(101) + ln(2) + 1 > ln(7) + 1 > 0 + 1
+		{"ranked by hearts and recent servings", "chic", 0, []string{"chicken noodle soup", "Chicken Tenders", "Chicago House"}},
+		{"limit", "chic", 1, []string{"chicken noodle soup"}},
+		// ln(3) + 1 > ln(7)
+		{"start of name ranked above later word", "tender", 0, []string{"tenderloin", "Chicken Tenders"}},
+		{"case and spacing ignored", "  CHICKEN   t", 0, []string{"Chicken Tenders"}},
+		{"dining hall by later word", "quad", 0, []string{"East Quad"}},
+		{"dining halls ranked by meals served", "b", 0, []string{"Bursley"}},
+		{"foods not served recently not indexed", "old", 0, []string{}},
+		{"empty prefix", " ", 0, []string{}},
+		{"no matches", "waffle", 0, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := []string{}
+			for _, suggestion := range index.Complete(test.prefix, test.limit) {
+				got = append(got, suggestion.Text)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Complete(%q, %d) = %q, want %q", test.prefix, test.limit, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompleteSuggestion(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   Suggestion
+	}{
+		{"chicken t", Suggestion{Text: "Chicken Tenders", Key: "chicken tenders", Type: Food, RecentServings: 6}},
+		{"chicken n", Suggestion{Text: "chicken noodle soup", Key: "chicken noodle soup", Type: Food, Hearts: 100, RecentServings: 1}},
+		{"east", Suggestion{Text: "East Quad", Key: "East Quad", Type: DiningHall, RecentServings: 10}},
+	}
+	index := testIndex()
+	for _, test := range tests {
+		suggestions := index.Complete(test.prefix, 0)
+		if len(suggestions) != 1 {
+			t.Errorf("Complete(%q) returned %d suggestions, want 1", test.prefix, len(suggestions))
+			continue
+		}
+		got := *suggestions[0]
+		got.Score = 0
+		if got != test.want {
+			t.Errorf("Complete(%q) = %+v, want %+v", test.prefix, got, test.want)
+		}
+	}
+}
+
+func TestSetHearts(t *testing.T) {
+	index := testIndex()
+	index.SetHearts("Chicken Tenders", 1000)
+	// Unknown foods are ignored
+	index.SetHearts("waffles", 5)
+	got := []string{}
+	for _, suggestion := range index.Complete("chic", 2) {
+		got = append(got, suggestion.Text)
+	}
+	if want := []string{"Chicken Tenders", "chicken noodle soup"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Complete after SetHearts = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,189 @@
+package cooccurrence
+
+import (
+	"sort"
+
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Co-occurrence of foods on the same dining hall meal
+//
+// Every menu (one dining hall meal on one date) is treated as a basket of
+// foods. For a pair of foods A and B over N menus:
+//     support    = menus with A and B / N
+//     confidence = menus with A and B / menus with A
+//     lift       = confidence / (menus with B / N)
+// A lift above 1 means B is served with A more often than chance.
+//
+// The number of pairs grows with the square of the foods on a menu, so the
+// Counter holds at most maxPairs pair counts. When it is full the pairs seen on
+// the fewest menus are dropped, so pairs rarer than the dropped counts may be
+// undercounted while common pairs are counted exactly.
+//
+
+// Association - How often another food is served on the same meal as a food
+type Association struct {
+	Key        string  `json:"key"`
+	Name       string  `json:"name"`
+	Count      int64   `json:"count"`
+	Support    float64 `json:"support"`
+	Confidence float64 `json:"confidence"`
+	Lift       float64 `json:"lift"`
+}
+
+// FoodAssociations - The foods most often served with a food
+type FoodAssociations struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	// Number of menus the food appeared on out of NumMenus
+	Count    int64 `json:"count"`
+	NumMenus int64 `json:"numMenus"`
+	// Range of menu dates (yyyy-MM-dd) analyzed
+	StartDate    string        `json:"startDate"`
+	EndDate      string        `json:"endDate"`
+	Associations []Association `json:"associations"`
+}
+
+// Counter - Accumulates food and food pair counts over menus
+type Counter struct {
+	ids      map[string]int32
+	keys     []string
+	names    []string
+	counts   []int64
+	pairs    map[uint64]int64
+	maxPairs int
+	// Pairs with counts below this have been dropped to stay within maxPairs
+	pruneFloor int64
+	numMenus   int64
+	startDate  string
+	endDate    string
+}
+
+// NewCounter - Create an empty Counter holding at most maxPairs pair counts, unbounded when maxPairs <= 0
+func NewCounter(maxPairs int) *Counter {
+	return &Counter{ids: map[string]int32{}, pairs: map[uint64]int64{}, maxPairs: maxPairs}
+}
+
+func (c *Counter) id(key string, name string) int32 {
+	id, exists := c.ids[key]
+	if !exists {
+		id = int32(len(c.keys))
+		c.ids[key] = id
+		c.keys = append(c.keys, key)
+		c.names = append(c.names, name)
+		c.counts = append(c.counts, 0)
+	}
+	return id
+}
+
+// AddMenu - Counts the foods and food pairs on a menu
+func (c *Counter) AddMenu(menu *pb.Menu) {
+	seen := map[int32]bool{}
+	for _, cat := range menu.Category {
+		if cat == nil {
+			continue
+		}
+		for _, item := range cat.MenuItem {
+			if item == nil {
+				continue
+			}
+			seen[c.id(foodnames.Key(item.Name), item.Name)] = true
+		}
+	}
+	if len(seen) == 0 {
+		return
+	}
+	c.numMenus++
+	if c.startDate == "" || menu.Date < c.startDate {
+		c.startDate = menu.Date
+	}
+	if menu.Date > c.endDate {
+		c.endDate = menu.Date
+	}
+	ids := make([]int32, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+		c.counts[id]++
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			c.pairs[pairKey(ids[i], ids[j])]++
+		}
+	}
+	if c.maxPairs > 0 && len(c.pairs) > c.maxPairs {
+		c.prune()
+	}
+}
+
+// Drops the least counted pairs until at most three quarters of maxPairs remain
+func (c *Counter) prune() {
+	for len(c.pairs) > c.maxPairs*3/4 {
+		c.pruneFloor++
+		for pair, count := range c.pairs {
+			if count < c.pruneFloor {
+				delete(c.pairs, pair)
+			}
+		}
+	}
+}
+
+// Pruned - Returns the count below which pairs may have been dropped, 0 when every pair was counted
+func (c *Counter) Pruned() int64 {
+	return c.pruneFloor
+}
+
+// Pair key with the smaller id in the high bits
+func pairKey(a int32, b int32) uint64 {
+	return uint64(a)<<32 | uint64(b)
+}
+
+// Top - Returns, for every food, up to limit foods served with it on at least
+// minCount menus, highest lift first
+func (c *Counter) Top(minCount int64, limit int) []*FoodAssociations {
+	all := make([]*FoodAssociations, len(c.keys))
+	for id, key := range c.keys {
+		all[id] = &FoodAssociations{
+			Key:          key,
+			Name:         c.names[id],
+			Count:        c.counts[id],
+			NumMenus:     c.numMenus,
+			StartDate:    c.startDate,
+			EndDate:      c.endDate,
+			Associations: []Association{},
+		}
+	}
+	n := float64(c.numMenus)
+	for pair, count := range c.pairs {
+		if count < minCount {
+			continue
+		}
+		a, b := int32(pair>>32), int32(pair&0xffffffff)
+		support := float64(count) / n
+		lift := support * n * n / float64(c.counts[a]*c.counts[b])
+		all[a].Associations = append(all[a].Associations, Association{
+			Key: c.keys[b], Name: c.names[b], Count: count, Support: support,
+			Confidence: float64(count) / float64(c.counts[a]), Lift: lift})
+		all[b].Associations = append(all[b].Associations, Association{
+			Key: c.keys[a], Name: c.names[a], Count: count, Support: support,
+			Confidence: float64(count) / float64(c.counts[b]), Lift: lift})
+	}
+	for _, food := range all {
+		assocs := food.Associations
+		sort.Slice(assocs, func(i, j int) bool {
+			if assocs[i].Lift != assocs[j].Lift {
+				return assocs[i].Lift > assocs[j].Lift
+			}
+			if assocs[i].Count != assocs[j].Count {
+				return assocs[i].Count > assocs[j].Count
+			}
+			return assocs[i].Key < assocs[j].Key
+		})
+		if len(assocs) > limit {
+			food.Associations = assocs[:limit]
+		}
+	}
+	return all
+}
@@ -0,0 +1,191 @@
+package entryfilter
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	"github.com/MichiganDiningAPI/internal/processing/taxonomy"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Server side filtering of FilterableEntries
+//
+// Every condition of a Query which is set must hold for an entry to match.
+// Attributes and allergens are compared through the taxonomy so raw upstream
+// names, implied attributes and groups (e.g. "nuts") all match.
+//
+
+// Sort orders
+const (
+	ByDate       = "date"
+	ByName       = "name"
+	ByDiningHall = "diningHall"
+)
+
+// SortOrders - All supported sort orders
+var SortOrders = []string{ByDate, ByName, ByDiningHall}
+
+// DefaultLimit - Page size used when a query has no limit
+const DefaultLimit = 100
+
+// MaxLimit - Largest allowed page size
+const MaxLimit = 1000
+
+// Query - Conditions on entries along with the order and page to return
+type Query struct {
+	DiningHall string `json:"diningHall"`
+	// Range of dates (yyyy-MM-dd), inclusive
+	StartDate string `json:"startDate"`
+	EndDate   string `json:"endDate"`
+	Meal      string `json:"meal"`
+	// Attributes and allergens entries must all have, and must have none of
+	Attributes         []string `json:"attributes"`
+	ExcludedAttributes []string `json:"excludedAttributes"`
+	Allergens          []string `json:"allergens"`
+	ExcludedAllergens  []string `json:"excludedAllergens"`
+	// Case insensitive substring of the item name
+	Name string `json:"name"`
+	// One of SortOrders, ByDate by default. Ties are broken by date, dining hall and then name
+	Sort       string `json:"sort"`
+	Descending bool   `json:"descending"`
+	Offset     int    `json:"offset"`
+	Limit      int    `json:"limit"`
+}
+
+// Result - A page of matching entries
+type Result struct {
+	Entries []*pb.FilterableEntry `json:"entries"`
+	// Number of matching entries over all pages
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// ValidSort - Whether sort is a supported sort order, the empty string meaning the default
+func ValidSort(sort string) bool {
+	if sort == "" {
+		return true
+	}
+	for _, s := range SortOrders {
+		if s == sort {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter - Returns the requested page of entries matching q, allergens maps food keys to the food's allergens
+// Entries whose food is missing from allergens never match a query excluding allergens.
+func Filter(entries []*pb.FilterableEntry, allergens map[string][]string, q Query) *Result {
+	name := strings.ToLower(q.Name)
+	matches := []*pb.FilterableEntry{}
+	for _, entry := range entries {
+		if q.DiningHall != "" && entry.DiningHallName != q.DiningHall {
+			continue
+		}
+		if (q.StartDate != "" && entry.Date < q.StartDate) || (q.EndDate != "" && entry.Date > q.EndDate) {
+			continue
+		}
+		if q.Meal != "" && !containsFold(entry.MealNames, q.Meal) {
+			continue
+		}
+		if name != "" && !strings.Contains(strings.ToLower(entry.ItemName), name) {
+			continue
+		}
+		if !matchesAll(taxonomy.Attributes, entry.Attributes, q.Attributes, q.ExcludedAttributes) {
+			continue
+		}
+		if len(q.Allergens) > 0 || len(q.ExcludedAllergens) > 0 {
+			foodAllergens, known := allergens[foodnames.Key(entry.ItemName)]
+			// A food without allergen information may contain any allergen
+			if !known && len(q.ExcludedAllergens) > 0 {
+				continue
+			}
+			if !matchesAll(taxonomy.Allergens, foodAllergens, q.Allergens, q.ExcludedAllergens) {
+				continue
+			}
+		}
+		matches = append(matches, entry)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if q.Descending {
+			return less(matches[j], matches[i], q.Sort)
+		}
+		return less(matches[i], matches[j], q.Sort)
+	})
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	result := &Result{Entries: []*pb.FilterableEntry{}, Total: len(matches), Offset: q.Offset, Limit: limit}
+	if q.Offset < len(matches) {
+		end := q.Offset + limit
+		if end > len(matches) {
+			end = len(matches)
+		}
+		result.Entries = matches[q.Offset:end]
+	}
+	return result
+}
+
+// FoodAllergens - Returns a map from food key to the canonical allergens of the food
+func FoodAllergens(foods []*pb.Food) map[string][]string {
+	allergens := map[string][]string{}
+	for _, food := range foods {
+		if food.MenuItem == nil {
+			continue
+		}
+		allergens[foodnames.Key(food.Key)] = taxonomy.Allergens.CanonicalList(food.MenuItem.Allergens)
+	}
+	return allergens
+}
+
+// Whether values have every one of required and none of excluded
+func matchesAll(t *taxonomy.Taxonomy, values []string, required []string, excluded []string) bool {
+	for _, want := range required {
+		if !t.Matches(values, want) {
+			return false
+		}
+	}
+	for _, unwanted := range excluded {
+		if t.Matches(values, unwanted) {
+			return false
+		}
+	}
+	return true
+}
+
+func less(a *pb.FilterableEntry, b *pb.FilterableEntry, order string) bool {
+	switch order {
+	case ByName:
+		if a.ItemName != b.ItemName {
+			return a.ItemName < b.ItemName
+		}
+	case ByDiningHall:
+		if a.DiningHallName != b.DiningHallName {
+			return a.DiningHallName < b.DiningHallName
+		}
+	}
+	if a.Date != b.Date {
+		return a.Date < b.Date
+	}
+	if a.DiningHallName != b.DiningHallName {
+		return a.DiningHallName < b.DiningHallName
+	}
+	return a.ItemName < b.ItemName
+}
+
+func containsFold(values []string, want string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package entryfilter
+
+import (
+	"reflect"
+	"testing"
+
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+func entry(name string, day string, hall string, meals []string, attributes ...string) *pb.FilterableEntry {
+	return &pb.FilterableEntry{ItemName: name, Date: day, DiningHallName: hall, MealNames: meals, Attributes: attributes}
+}
+
+var (
+	testEntries = []*pb.FilterableEntry{
+		entry("Peanut Noodles", "2020-01-03", "East Quad", []string{"LUNCH", "DINNER"}, "vegan"),
+		entry("Chicken Tenders", "2020-01-01", "Bursley", []string{"LUNCH"}),
+		entry("Almond Cake", "2020-01-02", "East Quad", []string{"DINNER"}, "vegetarian"),
+		entry("Tofu Stir Fry", "2020-01-01", "Bursley", []string{"DINNER"}, "vegan"),
+		entry("Fruit Cup", "2020-01-02", "Bursley", []string{"BREAKFAST"}, "Vegan", "Gluten-Free"),
+	}
+	testAllergens = map[string][]string{
+		"peanut noodles":  {"peanuts", "soy"},
+		"chicken tenders": {"wheat", "eggs"},
+		"almond cake":     {"tree nuts", "eggs", "milk"},
+		"tofu stir fry":   {"soy"},
+		"fruit cup":       {},
+	}
+)
+
+func names(entries []*pb.FilterableEntry) []string {
+	got := []string{}
+	for _, entry := range entries {
+		got = append(got, entry.ItemName)
+	}
+	return got
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"no conditions sorted by date, hall and name", Query{},
+			[]string{"Chicken Tenders", "Tofu Stir Fry", "Fruit Cup", "Almond Cake", "Peanut Noodles"}},
+		{"dining hall", Query{DiningHall: "East Quad"}, []string{"Almond Cake", "Peanut Noodles"}},
+		{"date range inclusive", Query{StartDate: "2020-01-02", EndDate: "2020-01-02"}, []string{"Fruit Cup", "Almond Cake"}},
+		{"open ended date range", Query{StartDate: "2020-01-02"}, []string{"Fruit Cup", "Almond Cake", "Peanut Noodles"}},
+		{"meal ignores case", Query{Meal: "dinner"}, []string{"Tofu Stir Fry", "Almond Cake", "Peanut Noodles"}},
+		{"name substring ignores case", Query{Name: "NOODLE"}, []string{"Peanut Noodles"}},
+		{"implied attribute", Query{Attributes: []string{"vegetarian"}},
+			[]string{"Tofu Stir Fry", "Fruit Cup", "Almond Cake", "Peanut Noodles"}},
+		{"raw attribute names", Query{Attributes: []string{"vegan", "gluten free"}}, []string{"Fruit Cup"}},
+		{"excluded attribute", Query{ExcludedAttributes: []string{"vegan"}}, []string{"Chicken Tenders", "Almond Cake"}},
+		{"implied allergen", Query{Allergens: []string{"gluten"}}, []string{"Chicken Tenders"}},
+		{"excluded allergen group", Query{ExcludedAllergens: []string{"nuts"}}, []string{"Chicken Tenders", "Tofu Stir Fry", "Fruit Cup"}},
+		{"excluded allergens", Query{ExcludedAllergens: []string{"eggs", "soy"}}, []string{"Fruit Cup"}},
+		{"every condition must hold", Query{DiningHall: "Bursley", Meal: "DINNER", ExcludedAllergens: []string{"soy"}}, []string{}},
+		{"sort by name", Query{Sort: ByName},
+			[]string{"Almond Cake", "Chicken Tenders", "Fruit Cup", "Peanut Noodles", "Tofu Stir Fry"}},
+		{"sort by dining hall descending", Query{Sort: ByDiningHall, Descending: true},
+			[]string{"Peanut Noodles", "Almond Cake", "Fruit Cup", "Tofu Stir Fry", "Chicken Tenders"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Filter(testEntries, testAllergens, test.q)
+			if got := names(result.Entries); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Filter(%+v) = %q, want %q", test.q, got, test.want)
+			}
+			if result.Total != len(test.want) {
+				t.Errorf("Filter(%+v) total = %d, want %d", test.q, result.Total, len(test.want))
+			}
+		})
+	}
+}
+
+func TestFilterUnknownAllergens(t *testing.T) {
+	// Mystery Soup has no allergen information
+	entries := append([]*pb.FilterableEntry{entry("Mystery Soup", "2020-01-01", "Bursley", []string{"LUNCH"})}, testEntries...)
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"excluded allergen", Query{ExcludedAllergens: []string{"nuts"}}, []string{"Chicken Tenders", "Tofu Stir Fry", "Fruit Cup"}},
+		{"required allergen", Query{Allergens: []string{"soy"}}, []string{"Tofu Stir Fry", "Peanut Noodles"}},
+		{"no allergen conditions", Query{DiningHall: "Bursley", Meal: "LUNCH"}, []string{"Chicken Tenders", "Mystery Soup"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Filter(entries, testAllergens, test.q)
+			if got := names(result.Entries); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Filter(%+v) = %q, want %q", test.q, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFilterPagination(t *testing.T) {
+	tests := []struct {
+		name      string
+		offset    int
+		limit     int
+		want      []string
+		wantLimit int
+	}{
+		{"first page", 0, 2, []string{"Chicken Tenders", "Tofu Stir Fry"}, 2},
+		{"middle page", 2, 2, []string{"Fruit Cup", "Almond Cake"}, 2},
+		{"partial last page", 4, 2, []string{"Peanut Noodles"}, 2},
+		{"offset at end", 5, 2, []string{}, 2},
+		{"offset past end", 10, 2, []string{}, 2},
+		{"default limit", 0, 0, []string{"Chicken Tenders", "Tofu Stir Fry", "Fruit Cup", "Almond Cake", "Peanut Noodles"}, DefaultLimit},
+		{"limit capped", 3, MaxLimit + 1, []string{"Almond Cake", "Peanut Noodles"}, MaxLimit},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Filter(testEntries, testAllergens, Query{Offset: test.offset, Limit: test.limit})
+			if got := names(result.Entries); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Filter offset %d limit %d = %q, want %q", test.offset, test.limit, got, test.want)
+			}
+			if result.Total != len(testEntries) || result.Offset != test.offset || result.Limit != test.wantLimit {
+				t.Errorf("Filter offset %d limit %d returned total %d offset %d limit %d, want %d %d %d", test.offset, test.limit,
+					result.Total, result.Offset, result.Limit, len(testEntries), test.offset, test.wantLimit)
+			}
+		})
+	}
+}
+
+func TestValidSort(t *testing.T) {
+	for _, sort := range []string{"", ByDate, ByName, ByDiningHall} {
+		if !ValidSort(sort) {
+			t.Errorf("ValidSort(%q) = false, want true", sort)
+		}
+	}
+	for _, sort := range []string{"price", "Name"} {
+		if ValidSort(sort) {
+			t.Errorf("ValidSort(%q) = true, want false", sort)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package foodnames
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/golang/glog"
+)
+
+//
+// Canonical food identity
+//
+// Menu item names arrive from upstream with inconsistent casing, spacing,
+// punctuation and trailing qualifiers, e.g. "Chicken Tenders",
+// "Chicken  Tenders " and "Chicken Tenders (GF)". Key runs a name through a
+// normalization pipeline so that all of these map to the same canonical food
+// key ("chicken tenders") which is used for Foods, Items, FoodStats and Hearts.
+// Display names are left untouched by callers.
+//
+
+// Normalizer - Converts display names into canonical food keys
+type Normalizer struct {
+	// Map from normalized alias to normalized canonical name
+	aliases map[string]string
+	mu      sync.RWMutex
+}
+
+// Default - The normalizer used by the package level Key function
+var Default = New()
+
+// New - Create a Normalizer with an empty alias table
+func New() *Normalizer {
+	return &Normalizer{aliases: map[string]string{}}
+}
+
+// Key - Returns the canonical food key for the given name using the Default normalizer
+func Key(name string) string {
+	return Default.Key(name)
+}
+
+// Keys - Returns the canonical food keys for the given names using the Default normalizer
+func Keys(names []string) []string {
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, Default.Key(name))
+	}
+	return keys
+}
+
+// LoadAliases - Loads an alias table into the Default normalizer
+func LoadAliases(path string) error {
+	return Default.LoadAliases(path)
+}
+
+// LoadAliases - Reads a json object mapping alias names to canonical names from
+// the given path and adds each entry to the alias table.
+//
+// Example: {"chicken fingers": "chicken tenders", "mac & cheese": "macaroni and cheese"}
+func (n *Normalizer) LoadAliases(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		glog.Errorf("Failed to read food aliases %s: %s", path, err)
+		return err
+	}
+	aliases := map[string]string{}
+	if err = json.Unmarshal(data, &aliases); err != nil {
+		glog.Errorf("Failed to parse food aliases %s: %s", path, err)
+		return err
+	}
+	for alias, canonical := range aliases {
+		n.AddAlias(alias, canonical)
+	}
+	glog.Infof("Loaded %d food aliases from %s", len(aliases), path)
+	return nil
+}
+
+// AddAlias - Makes names normalizing to alias resolve to the key of canonical
+func (n *Normalizer) AddAlias(alias string, canonical string) {
+	from, to := normalize(alias), normalize(canonical)
+	if from == "" || to == "" || from == to {
+		return
+	}
+	n.mu.Lock()
+	n.aliases[from] = to
+	n.mu.Unlock()
+}
+
+// Key - Returns the canonical food key for the given name
+func (n *Normalizer) Key(name string) string {
+	key := normalize(name)
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	// Follow alias chains but guard against cycles in the table
+	for i := 0; i < len(n.aliases); i++ {
+		canonical, exists := n.aliases[key]
+		if !exists {
+			break
+		}
+		key = canonical
+	}
+	return key
+}
+
+// Same - Whether two names refer to the same canonical food
+func (n *Normalizer) Same(a string, b string) bool {
+	return n.Key(a) == n.Key(b)
+}
+
+func normalize(name string) string {
+	s := strings.ToLower(name)
+	s = stripQualifiers(s)
+	s = strings.ReplaceAll(s, "&", " and ")
+	b := strings.Builder{}
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case r == '\'' || r == '’':
+			// Drop apostrophes so "chef's" and "chefs" match
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Removes trailing qualifiers such as "(gf)", "[v]" or a trailing "*"
+func stripQualifiers(s string) string {
+	for {
+		s = strings.TrimRightFunc(s, func(r rune) bool { return unicode.IsSpace(r) || r == '*' })
+		if len(s) == 0 {
+			return s
+		}
+		var open byte
+		switch s[len(s)-1] {
+		case ')':
+			open = '('
+		case ']':
+			open = '['
+		default:
+			return s
+		}
+		idx := strings.LastIndexByte(s, open)
+		if idx <= 0 {
+			// Either unbalanced or the whole name is a qualifier, leave it alone
+			return s
+		}
+		s = s[:idx]
+	}
+}
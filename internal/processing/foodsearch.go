@@ -0,0 +1,275 @@
+package foodsearch
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Full text and fuzzy search over food names
+//
+// Names are split into lowercase alphanumeric tokens which are stemmed by
+// stripping common English suffixes, so "tenders" finds "tender". Each query
+// token is scored against the terms of the index:
+//     exact stem match                  1
+//     term starting with the token      prefixWeight (for search as you type)
+//     trigram similarity of the terms   fuzzyWeight * similarity, if above minSimilarity
+// A food's score is the mean over query tokens of its best term score, so
+// "chiken tendrs" still finds "chicken tenders". Ties go to the most served food.
+//
+
+const (
+	prefixWeight  = 0.8
+	fuzzyWeight   = 0.7
+	minSimilarity = 0.3
+	// Bonus for a food whose whole name matches the query
+	exactBonus = 0.5
+)
+
+// DefaultLimit - Number of results returned when a search has no limit
+const DefaultLimit = 20
+
+// Result - A food matching a search
+type Result struct {
+	Key   string  `json:"key"`
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+	// Last date (yyyy-MM-dd) before today the food was served, empty if never
+	LastServed string `json:"lastServed"`
+	// First date on or after today the food is on a published menu, empty if none
+	NextServed  string `json:"nextServed"`
+	TimesServed int64  `json:"timesServed"`
+}
+
+type doc struct {
+	key         string
+	name        string
+	lastServed  string
+	nextServed  string
+	timesServed int64
+	terms       []int
+}
+
+// Index - Search index over food names
+type Index struct {
+	docs []*doc
+	// Map from term to id and the docs containing each term id
+	termIDs  map[string]int
+	terms    []string
+	postings [][]int
+	// Map from trigram to the ids of terms containing it
+	trigrams map[string][]int
+}
+
+// Build - Indexes every food in stats and items. stats provide served counts and dates for every date
+// analyzed, items the display names and dates of the published menus. today is formatted yyyy-MM-dd.
+func Build(stats []*pb.FoodStat, items *pb.Items, today string) *Index {
+	docs := map[string]*doc{}
+	get := func(key string) *doc {
+		d, exists := docs[key]
+		if !exists {
+			d = &doc{key: key, name: key}
+			docs[key] = d
+		}
+		return d
+	}
+	for _, stat := range stats {
+		for key, count := range stat.TimesServed {
+			if count <= 0 {
+				continue
+			}
+			d := get(foodnames.Key(key))
+			d.timesServed += count
+			if stat.Date < today && stat.Date > d.lastServed {
+				d.lastServed = stat.Date
+			}
+			if stat.Date >= today && (d.nextServed == "" || stat.Date < d.nextServed) {
+				d.nextServed = stat.Date
+			}
+		}
+	}
+	if items != nil {
+		for key, item := range items.Items {
+			d := get(foodnames.Key(key))
+			d.name = item.Name
+			for _, match := range item.DiningHallMatches {
+				for mealDate := range match.MealTimes {
+					if mealDate >= today && (d.nextServed == "" || mealDate < d.nextServed) {
+						d.nextServed = mealDate
+					}
+				}
+			}
+		}
+	}
+
+	index := &Index{termIDs: map[string]int{}, trigrams: map[string][]int{}}
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for id, key := range keys {
+		d := docs[key]
+		seen := map[int]bool{}
+		for _, token := range tokenize(d.name + " " + d.key) {
+			termID := index.termID(stem(token))
+			if seen[termID] {
+				continue
+			}
+			seen[termID] = true
+			d.terms = append(d.terms, termID)
+			index.postings[termID] = append(index.postings[termID], id)
+		}
+		index.docs = append(index.docs, d)
+	}
+	return index
+}
+
+func (index *Index) termID(term string) int {
+	id, exists := index.termIDs[term]
+	if !exists {
+		id = len(index.terms)
+		index.termIDs[term] = id
+		index.terms = append(index.terms, term)
+		index.postings = append(index.postings, []int{})
+		for trigram := range trigramSet(term) {
+			index.trigrams[trigram] = append(index.trigrams[trigram], id)
+		}
+	}
+	return id
+}
+
+// Len - Number of foods in the index
+func (index *Index) Len() int {
+	return len(index.docs)
+}
+
+// Search - Returns up to limit foods matching query, best match first
+func (index *Index) Search(query string, limit int) []*Result {
+	tokens := tokenize(query)
+	if len(tokens) == 0 {
+		return []*Result{}
+	}
+	// Map from doc id to the sum over query tokens of the best term score
+	scores := map[int]float64{}
+	for _, token := range tokens {
+		best := map[int]float64{}
+		for termID, score := range index.termScores(stem(token)) {
+			for _, docID := range index.postings[termID] {
+				if score > best[docID] {
+					best[docID] = score
+				}
+			}
+		}
+		for docID, score := range best {
+			scores[docID] += score
+		}
+	}
+	normalized := strings.Join(tokens, " ")
+	results := make([]*Result, 0, len(scores))
+	for docID, score := range scores {
+		d := index.docs[docID]
+		score /= float64(len(tokens))
+		if strings.Join(tokenize(d.name), " ") == normalized || d.key == foodnames.Key(query) {
+			score += exactBonus
+		}
+		results = append(results, &Result{
+			Key:         d.key,
+			Name:        d.name,
+			Score:       score,
+			LastServed:  d.lastServed,
+			NextServed:  d.nextServed,
+			TimesServed: d.timesServed,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].TimesServed != results[j].TimesServed {
+			return results[i].TimesServed > results[j].TimesServed
+		}
+		return results[i].Key < results[j].Key
+	})
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// Returns a map from term id to the score of the term for a query token
+func (index *Index) termScores(token string) map[int]float64 {
+	scores := map[int]float64{}
+	if id, exists := index.termIDs[token]; exists {
+		scores[id] = 1
+	}
+	tokenTrigrams := trigramSet(token)
+	// Number of trigrams each candidate term shares with the token
+	shared := map[int]int{}
+	for trigram := range tokenTrigrams {
+		for _, id := range index.trigrams[trigram] {
+			shared[id]++
+		}
+	}
+	for id, count := range shared {
+		if _, exact := scores[id]; exact {
+			continue
+		}
+		term := index.terms[id]
+		score := 0.0
+		if strings.HasPrefix(term, token) {
+			score = prefixWeight
+		}
+		similarity := float64(count) / float64(len(tokenTrigrams)+len(trigramSet(term))-count)
+		if similarity >= minSimilarity {
+			score = math.Max(score, fuzzyWeight*similarity)
+		}
+		if score > 0 {
+			scores[id] = score
+		}
+	}
+	return scores
+}
+
+// Splits s into lowercase runs of letters and digits
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Strips common English plural and verb suffixes
+func stem(token string) string {
+	switch {
+	case len(token) > 4 && strings.HasSuffix(token, "ies"):
+		return token[:len(token)-3] + "y"
+	case len(token) > 4 && (strings.HasSuffix(token, "ches") || strings.HasSuffix(token, "shes") ||
+		strings.HasSuffix(token, "sses") || strings.HasSuffix(token, "xes") || strings.HasSuffix(token, "oes")):
+		return token[:len(token)-2]
+	case len(token) > 5 && strings.HasSuffix(token, "ing"):
+		return token[:len(token)-3]
+	case len(token) > 4 && strings.HasSuffix(token, "ed") && !strings.HasSuffix(token, "eed"):
+		return token[:len(token)-2]
+	case len(token) > 3 && strings.HasSuffix(token, "s") && !strings.HasSuffix(token, "ss") && !strings.HasSuffix(token, "us"):
+		return token[:len(token)-1]
+	}
+	return token
+}
+
+// Trigrams of a term padded so its start is weighted more
+func trigramSet(term string) map[string]bool {
+	padded := []rune("  " + term + " ")
+	set := map[string]bool{}
+	for i := 0; i+3 <= len(padded); i++ {
+		set[string(padded[i:i+3])] = true
+	}
+	return set
+}
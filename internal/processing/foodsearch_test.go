@@ -0,0 +1,147 @@
+package foodsearch
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{"berries", "berry"},
+		{"fries", "fry"},
+		{"pies", "pie"},
+		{"sandwiches", "sandwich"},
+		{"dishes", "dish"},
+		{"glasses", "glass"},
+		{"boxes", "box"},
+		{"potatoes", "potato"},
+		{"roasting", "roast"},
+		{"icing", "icing"},
+		{"steamed", "steam"},
+		{"breed", "breed"},
+		{"tenders", "tender"},
+		{"peas", "pea"},
+		{"gas", "gas"},
+		{"bass", "bass"},
+		{"hummus", "hummus"},
+		{"rice", "rice"},
+	}
+	for _, test := range tests {
+		if got := stem(test.token); got != test.want {
+			t.Errorf("stem(%q) = %q, want %q", test.token, got, test.want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"Chicken Tenders", []string{"chicken", "tenders"}},
+		{"  Mac & Cheese (V) ", []string{"mac", "cheese", "v"}},
+		{"7-Layer Dip", []string{"7", "layer", "dip"}},
+		{"Crème Brûlée", []string{"crème", "brûlée"}},
+		{" - ", []string{}},
+	}
+	for _, test := range tests {
+		if got := tokenize(test.s); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestTrigramSet(t *testing.T) {
+	want := map[string]bool{"  e": true, " eg": true, "egg": true, "gg ": true}
+	if got := trigramSet("egg"); !reflect.DeepEqual(got, want) {
+		t.Errorf("trigramSet(egg) = %v, want %v", got, want)
+	}
+}
+
+func testIndex() *Index {
+	stats := []*pb.FoodStat{
+		{Date: "2020-01-01", TimesServed: map[string]int64{
+			"chicken tenders":     5,
+			"chicken noodle soup": 10,
+			"chickpea salad":      1,
+			"tenderloin":          2,
+		}},
+	}
+	return Build(stats, nil, "2020-01-02")
+}
+
+func TestTermScores(t *testing.T) {
+	index := testIndex()
+	tests := []struct {
+		name  string
+		token string
+		// Map from term to expected score, terms not listed are expected not to match
+		want map[string]float64
+	}{
+		{"exact", "chicken", map[string]float64{
+			"chicken": 1,
+			// 5 shared of 12 trigrams
+			"chickpea": fuzzyWeight * 5 / 12,
+		}},
+		{"prefix beats fuzzy", "chick", map[string]float64{"chicken": prefixWeight, "chickpea": prefixWeight}},
+		{"exact and prefix", "tender", map[string]float64{"tender": 1, "tenderloin": prefixWeight}},
+		// chickpea shares 3 of 13 trigrams, below minSimilarity
+		{"fuzzy", "chiken", map[string]float64{
+			// 5 shared of 10 trigrams
+			"chicken": fuzzyWeight * 5 / 10,
+		}},
+		{"below min similarity", "soap", map[string]float64{}},
+		{"no shared trigrams", "waffle", map[string]float64{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := map[string]float64{}
+			for id, score := range index.termScores(test.token) {
+				got[index.terms[id]] = score
+			}
+			if len(got) != len(test.want) {
+				t.Errorf("termScores(%q) = %v, want %v", test.token, got, test.want)
+			}
+			for term, want := range test.want {
+				if score, exists := got[term]; !exists || math.Abs(score-want) > 1e-9 {
+					t.Errorf("termScores(%q)[%q] = %v, want %v", test.token, term, score, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	index := testIndex()
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []string
+	}{
+		{"exact name first", "chicken tenders", 0, []string{"chicken tenders", "chicken noodle soup", "tenderloin", "chickpea salad"}},
+		{"misspelled", "chiken tendrs", 1, []string{"chicken tenders"}},
+		{"ties by times served", "chicken", 0, []string{"chicken noodle soup", "chicken tenders", "chickpea salad"}},
+		{"stemmed query", "Tenders!", 2, []string{"chicken tenders", "tenderloin"}},
+		{"prefix before fuzzy", "chickp", 0, []string{"chickpea salad", "chicken noodle soup", "chicken tenders"}},
+		{"no tokens", " ? ", 0, []string{}},
+		{"no matches", "waffles", 0, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := []string{}
+			for _, result := range index.Search(test.query, test.limit) {
+				got = append(got, result.Key)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Search(%q, %d) = %q, want %q", test.query, test.limit, got, test.want)
+			}
+		})
+	}
+}
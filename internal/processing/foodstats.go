@@ -0,0 +1,110 @@
+package foodstats
+
+import (
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	"github.com/MichiganDiningAPI/internal/processing/taxonomy"
+	"github.com/MichiganDiningAPI/internal/util/date"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Accumulation of per date FoodStats from Foods
+//
+
+// CountTimesServed - Number of dining hall meals the food was served at
+func CountTimesServed(food *pb.Food) int64 {
+	count := int64(0)
+	for _, dh := range food.DiningHallMatch {
+		if dh.Campus != "" && dh.Campus != "DINING HALLS" {
+			// For now, only analyze actual Dining Hall foods
+			continue
+		}
+		for _, mealTime := range dh.MealTime {
+			count += int64(len(mealTime.MealNames))
+		}
+	}
+	return count
+}
+
+// Update - Adds the servings of food to foodStats
+func Update(foodStats *pb.FoodStat, food *pb.Food) {
+	timesServed := CountTimesServed(food)
+	if timesServed == 0 {
+		return
+	}
+	key := foodnames.Key(food.Key)
+	foodStats.TotalFoodMealsServed += timesServed
+	foodStats.TimesServed[key] += timesServed
+	for _, cat := range food.Category {
+		foodStats.CategoryCounts[cat] += timesServed
+	}
+	_, e := foodStats.FoodWeekdayCounts[key]
+	if !e {
+		foodStats.FoodWeekdayCounts[key] = &pb.StringToInt{Data: make(map[string]int64)}
+	}
+	d, _ := date.ParseNoTime(&food.Date)
+	foodStats.FoodWeekdayCounts[key].Data[d.Weekday().String()] += timesServed
+	_, e = foodStats.WeekdayFoodCounts[d.Weekday().String()]
+	if !e {
+		foodStats.WeekdayFoodCounts[d.Weekday().String()] = &pb.StringToInt{Data: make(map[string]int64)}
+	}
+	foodStats.WeekdayFoodCounts[d.Weekday().String()].Data[key] += timesServed
+	_, e = foodStats.FoodDiningHallCounts[key]
+	if !e {
+		foodStats.FoodDiningHallCounts[key] = &pb.StringToInt{Data: make(map[string]int64)}
+	}
+	for dhName, dh := range food.DiningHallMatch {
+		if dh.Campus != "" && dh.Campus != "DINING HALLS" {
+			// For now, only analyze actual Dining Hall foods
+			continue
+		}
+		_, e = foodStats.DiningHallFoodCounts[dhName]
+		if !e {
+			foodStats.DiningHallFoodCounts[dhName] = &pb.StringToInt{Data: make(map[string]int64)}
+		}
+		for range dh.MealTime {
+			foodStats.FoodDiningHallCounts[key].Data[dhName]++
+			foodStats.DiningHallFoodCounts[dhName].Data[key]++
+			foodStats.DiningHallMealsServed[dhName]++
+		}
+	}
+	// Stored values are raw upstream strings, count their canonical keys along with implied ones so the
+	// counts agree with filtering
+	allergens := taxonomy.Allergens.Expand(taxonomy.Allergens.CanonicalList(food.MenuItem.Allergens))
+	if len(allergens) == 0 {
+		foodStats.AllergenCounts[taxonomy.None] += timesServed
+	}
+	for _, allergen := range allergens {
+		foodStats.AllergenCounts[allergen] += timesServed
+	}
+	attributes := taxonomy.Attributes.Expand(taxonomy.Attributes.CanonicalList(food.MenuItem.Attribute))
+	if len(attributes) == 0 {
+		foodStats.AttributeCounts[taxonomy.None] += timesServed
+	}
+	for _, attribute := range attributes {
+		foodStats.AttributeCounts[attribute] += timesServed
+	}
+}
+
+// New - Create an empty FoodStat for the given date
+func New(date string) *pb.FoodStat {
+	return &pb.FoodStat{
+		Date:                  date,
+		TimesServed:           map[string]int64{},
+		FoodDiningHallCounts:  map[string]*pb.StringToInt{},
+		DiningHallFoodCounts:  map[string]*pb.StringToInt{},
+		CategoryCounts:        map[string]int64{},
+		AllergenCounts:        map[string]int64{},
+		AttributeCounts:       map[string]int64{},
+		WeekdayFoodCounts:     map[string]*pb.StringToInt{},
+		FoodWeekdayCounts:     map[string]*pb.StringToInt{},
+		NumUniqueFoods:        0,
+		TotalFoodMealsServed:  0,
+		DiningHallMealsServed: map[string]int64{},
+	}
+}
+
+// Finalize - Fills in fields derived from the accumulated counts
+func Finalize(foodStats *pb.FoodStat) {
+	foodStats.NumUniqueFoods = int64(len(foodStats.TimesServed))
+}
@@ -0,0 +1,289 @@
+package foodtrends
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MichiganDiningAPI/internal/processing/rollups"
+	"github.com/MichiganDiningAPI/internal/util/date"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Per food trend and seasonality analysis of daily FoodStats
+//
+// Each food's servings are bucketed into weekly counts. A trend is the least
+// squares slope of the weekly counts over the last N complete weeks, divided by
+// the mean weekly count so that foods served at very different rates can be
+// ranked against each other. Seasonality is computed over the full history.
+//
+
+// DefaultWindows - Trend windows in weeks computed by analyze and served by web unless configured otherwise
+const DefaultWindows = "4,12,26"
+
+// ParseWindows - Parses comma separated trend windows in weeks, each at least 2
+func ParseWindows(windows string) ([]int, error) {
+	weeks := []int{}
+	for _, window := range strings.Split(windows, ",") {
+		windowWeeks, err := strconv.Atoi(strings.TrimSpace(window))
+		if err != nil || windowWeeks < 2 {
+			return nil, fmt.Errorf("invalid trend window %s, expected a number of weeks >= 2", window)
+		}
+		weeks = append(weeks, windowWeeks)
+	}
+	return weeks, nil
+}
+
+// Options - Parameters of a trend computation
+type Options struct {
+	// Number of complete weeks in the trend window
+	WindowWeeks int
+	// Trends are computed for the weeks before the week containing AsOf
+	AsOf time.Time
+	// Foods last served at least this many days before AsOf are considered retired
+	RetiredAfterDays int
+}
+
+// Trend - How often a food has been served over a trend window
+type Trend struct {
+	WindowWeeks int    `json:"windowWeeks"`
+	Key         string `json:"key"`
+	// First and last day (yyyy-MM-dd) of the window
+	WindowStart string `json:"windowStart"`
+	WindowEnd   string `json:"windowEnd"`
+	// Dining hall meals served in each week of the window, oldest first
+	Weekly []int64 `json:"weekly"`
+	// Total and mean weekly servings in the window
+	Total int64   `json:"total"`
+	Mean  float64 `json:"mean"`
+	// Least squares slope of Weekly in servings per week
+	Slope float64 `json:"slope"`
+	// Slope divided by Mean, the fractional change per week
+	RelativeSlope float64 `json:"relativeSlope"`
+	// First and last dates the food was ever served
+	FirstServed string `json:"firstServed"`
+	LastServed  string `json:"lastServed"`
+	// Whether the food was first served within the window
+	New bool `json:"new"`
+	// Whether the food was served in the window but not in the last RetiredAfterDays
+	Retired bool `json:"retired"`
+	// Average servings per day with stats while classes are in session and during breaks
+	TermServingsPerDay  float64 `json:"termServingsPerDay"`
+	BreakServingsPerDay float64 `json:"breakServingsPerDay"`
+	// Total servings in each month of the year, January first
+	MonthCounts []int64 `json:"monthCounts"`
+}
+
+// WindowBounds - Returns the first and last day of the window of complete weeks before asOf
+func WindowBounds(windowWeeks int, asOf time.Time) (time.Time, time.Time) {
+	weekStart, _, _ := rollups.PeriodOf(rollups.Week, asOf)
+	return weekStart.AddDate(0, 0, -7*windowWeeks), weekStart.AddDate(0, 0, -1)
+}
+
+// Accumulator - Computes trends from daily stats streamed into it, holding
+// one Trend per food rather than every stat
+type Accumulator struct {
+	opts                         Options
+	windowStart, windowEnd       time.Time
+	windowStartStr, windowEndStr string
+	retiredBefore                string
+	trends                       map[string]*Trend
+	termServings, breakServings  map[string]int64
+	termDays, breakDays          int64
+}
+
+// NewAccumulator - Create an Accumulator of trends with the given options
+func NewAccumulator(opts Options) *Accumulator {
+	windowStart, windowEnd := WindowBounds(opts.WindowWeeks, opts.AsOf)
+	return &Accumulator{
+		opts:           opts,
+		windowStart:    windowStart,
+		windowEnd:      windowEnd,
+		windowStartStr: date.FormatNoTime(windowStart),
+		windowEndStr:   date.FormatNoTime(windowEnd),
+		retiredBefore:  date.FormatNoTime(date.DayStart(opts.AsOf).AddDate(0, 0, -opts.RetiredAfterDays)),
+		trends:         map[string]*Trend{},
+		termServings:   map[string]int64{},
+		breakServings:  map[string]int64{},
+	}
+}
+
+func (a *Accumulator) get(key string) *Trend {
+	trend, exists := a.trends[key]
+	if !exists {
+		trend = &Trend{
+			WindowWeeks: a.opts.WindowWeeks,
+			Key:         key,
+			WindowStart: a.windowStartStr,
+			WindowEnd:   a.windowEndStr,
+			Weekly:      make([]int64, a.opts.WindowWeeks),
+			MonthCounts: make([]int64, 12),
+		}
+		a.trends[key] = trend
+	}
+	return trend
+}
+
+// Add - Adds the servings of a daily stat
+func (a *Accumulator) Add(stat *pb.FoodStat) {
+	t, err := date.ParseNoTime(&stat.Date)
+	if err != nil {
+		return
+	}
+	inSession := rollups.TermOf(t).InSession
+	if inSession {
+		a.termDays++
+	} else {
+		a.breakDays++
+	}
+	week := -1
+	if !t.Before(a.windowStart) && !t.After(a.windowEnd) {
+		week = int(date.DayStart(t).Sub(a.windowStart).Hours()+12) / (24 * 7)
+	}
+	for key, count := range stat.TimesServed {
+		trend := a.get(key)
+		if trend.FirstServed == "" || stat.Date < trend.FirstServed {
+			trend.FirstServed = stat.Date
+		}
+		if stat.Date > trend.LastServed {
+			trend.LastServed = stat.Date
+		}
+		trend.MonthCounts[t.Month()-1] += count
+		if inSession {
+			a.termServings[key] += count
+		} else {
+			a.breakServings[key] += count
+		}
+		if week >= 0 && week < a.opts.WindowWeeks {
+			trend.Weekly[week] += count
+			trend.Total += count
+		}
+	}
+}
+
+// Trends - Returns the trend of every food served in the window, sorted by key
+func (a *Accumulator) Trends() []*Trend {
+	result := []*Trend{}
+	for key, trend := range a.trends {
+		if trend.Total == 0 {
+			continue
+		}
+		trend.Mean = float64(trend.Total) / float64(a.opts.WindowWeeks)
+		trend.Slope = slope(trend.Weekly)
+		trend.RelativeSlope = trend.Slope / trend.Mean
+		trend.New = trend.FirstServed >= a.windowStartStr
+		trend.Retired = trend.LastServed < a.retiredBefore
+		if a.termDays > 0 {
+			trend.TermServingsPerDay = float64(a.termServings[key]) / float64(a.termDays)
+		}
+		if a.breakDays > 0 {
+			trend.BreakServingsPerDay = float64(a.breakServings[key]) / float64(a.breakDays)
+		}
+		result = append(result, trend)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// Compute - Returns the trend of every food served in the window
+func Compute(stats []*pb.FoodStat, opts Options) []*Trend {
+	accumulator := NewAccumulator(opts)
+	for _, stat := range stats {
+		accumulator.Add(stat)
+	}
+	return accumulator.Trends()
+}
+
+// Least squares slope of ys against their index
+func slope(ys []int64) float64 {
+	n := float64(len(ys))
+	if n < 2 {
+		return 0
+	}
+	meanX := (n - 1) / 2
+	meanY := 0.0
+	for _, y := range ys {
+		meanY += float64(y)
+	}
+	meanY /= n
+	num, den := 0.0, 0.0
+	for x, y := range ys {
+		dx := float64(x) - meanX
+		num += dx * (float64(y) - meanY)
+		den += dx * dx
+	}
+	return num / den
+}
+
+// Rank - Returns the foods with at least minTotal servings in the window whose
+// relative slope is positive (rising, steepest first) and negative (falling,
+// steepest first), each limited to limit entries when limit > 0
+func Rank(trends []*Trend, minTotal int64, limit int) ([]*Trend, []*Trend) {
+	rising, falling := []*Trend{}, []*Trend{}
+	for _, trend := range trends {
+		if trend.Total < minTotal {
+			continue
+		}
+		if trend.RelativeSlope > 0 {
+			rising = append(rising, trend)
+		} else if trend.RelativeSlope < 0 {
+			falling = append(falling, trend)
+		}
+	}
+	sort.Slice(rising, func(i, j int) bool { return rising[i].RelativeSlope > rising[j].RelativeSlope })
+	sort.Slice(falling, func(i, j int) bool { return falling[i].RelativeSlope < falling[j].RelativeSlope })
+	if limit > 0 && len(rising) > limit {
+		rising = rising[:limit]
+	}
+	if limit > 0 && len(falling) > limit {
+		falling = falling[:limit]
+	}
+	return rising, falling
+}
+
+// Latest - Returns the trends with the latest WindowEnd, dropping trends left
+// over from earlier runs for foods which are no longer served
+func Latest(trends []*Trend) []*Trend {
+	windowEnd := ""
+	for _, trend := range trends {
+		if trend.WindowEnd > windowEnd {
+			windowEnd = trend.WindowEnd
+		}
+	}
+	latest := []*Trend{}
+	for _, trend := range trends {
+		if trend.WindowEnd == windowEnd {
+			latest = append(latest, trend)
+		}
+	}
+	return latest
+}
+
+// NewFoods - Returns the foods first served in the window, most served first,
+// limited to limit entries when limit > 0
+func NewFoods(trends []*Trend, limit int) []*Trend {
+	return mostServed(trends, func(trend *Trend) bool { return trend.New }, limit)
+}
+
+// RetiredFoods - Returns the foods served in the window which have since been
+// retired, most served first, limited to limit entries when limit > 0
+func RetiredFoods(trends []*Trend, limit int) []*Trend {
+	return mostServed(trends, func(trend *Trend) bool { return trend.Retired }, limit)
+}
+
+func mostServed(trends []*Trend, include func(*Trend) bool, limit int) []*Trend {
+	result := []*Trend{}
+	for _, trend := range trends {
+		if include(trend) {
+			result = append(result, trend)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Total > result[j].Total })
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
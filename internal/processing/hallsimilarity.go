@@ -0,0 +1,201 @@
+package hallsimilarity
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Pairwise similarity of the menus served at each dining hall
+//
+// For every date and meal served at both of a pair of halls the Jaccard
+// similarity of their item sets is computed. A pair's similarity is the
+// average of these, weighted by the weight of each meal (1 unless given).
+//
+
+// Matrix - Similarity between every pair of dining halls over a date range
+type Matrix struct {
+	StartDate   string   `json:"startDate"`
+	EndDate     string   `json:"endDate"`
+	DiningHalls []string `json:"diningHalls"`
+	// Similarity[i][j] is the similarity of DiningHalls[i] and DiningHalls[j], 1 on the diagonal
+	Similarity [][]float64 `json:"similarity"`
+	// Number of date and meal pairs compared for each pair of halls
+	MealsCompared [][]int64 `json:"mealsCompared"`
+	// Map from dining hall to names of foods served there and at no other hall, sorted
+	Unique map[string][]string `json:"unique"`
+}
+
+// Builder - Accumulates menus
+type Builder struct {
+	// Map from date+meal to dining hall to food keys
+	meals map[string]map[string]map[string]bool
+	// Meal name of each date+meal key
+	mealNames map[string]string
+	// Map from dining hall to food keys served over the whole range
+	served map[string]map[string]bool
+	names  map[string]string
+	start  string
+	end    string
+}
+
+// NewBuilder - Create an empty Builder
+func NewBuilder() *Builder {
+	return &Builder{
+		meals:     map[string]map[string]map[string]bool{},
+		mealNames: map[string]string{},
+		served:    map[string]map[string]bool{},
+		names:     map[string]string{},
+	}
+}
+
+// AddMenu - Records the items of a dining hall menu
+func (b *Builder) AddMenu(menu *pb.Menu) {
+	if menu.DiningHallCampus != "" && menu.DiningHallCampus != "DINING HALLS" {
+		// For now, only compare actual Dining Halls
+		return
+	}
+	items := map[string]bool{}
+	for _, cat := range menu.Category {
+		if cat == nil {
+			continue
+		}
+		for _, item := range cat.MenuItem {
+			if item == nil {
+				continue
+			}
+			key := foodnames.Key(item.Name)
+			items[key] = true
+			if _, exists := b.names[key]; !exists {
+				b.names[key] = item.Name
+			}
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+	if b.start == "" || menu.Date < b.start {
+		b.start = menu.Date
+	}
+	if menu.Date > b.end {
+		b.end = menu.Date
+	}
+	// Meals are upper cased so weights match however upstream cased them
+	meal := strings.ToUpper(menu.Meal)
+	mealKey := menu.Date + meal
+	b.mealNames[mealKey] = meal
+	if _, exists := b.meals[mealKey]; !exists {
+		b.meals[mealKey] = map[string]map[string]bool{}
+	}
+	if _, exists := b.meals[mealKey][menu.DiningHallName]; !exists {
+		b.meals[mealKey][menu.DiningHallName] = map[string]bool{}
+	}
+	if _, exists := b.served[menu.DiningHallName]; !exists {
+		b.served[menu.DiningHallName] = map[string]bool{}
+	}
+	for key := range items {
+		b.meals[mealKey][menu.DiningHallName][key] = true
+		b.served[menu.DiningHallName][key] = true
+	}
+}
+
+// Build - Computes the similarity matrix, mealWeights maps meal names to weights (missing meals weigh 1)
+func (b *Builder) Build(mealWeights map[string]float64) *Matrix {
+	// Meal names are stored upper cased
+	upperWeights := make(map[string]float64, len(mealWeights))
+	for meal, weight := range mealWeights {
+		upperWeights[strings.ToUpper(meal)] = weight
+	}
+	halls := make([]string, 0, len(b.served))
+	for hall := range b.served {
+		halls = append(halls, hall)
+	}
+	sort.Strings(halls)
+	index := map[string]int{}
+	for idx, hall := range halls {
+		index[hall] = idx
+	}
+	n := len(halls)
+	total := make([][]float64, n)
+	weights := make([][]float64, n)
+	compared := make([][]int64, n)
+	for i := range halls {
+		total[i] = make([]float64, n)
+		weights[i] = make([]float64, n)
+		compared[i] = make([]int64, n)
+	}
+	for mealKey, byHall := range b.meals {
+		weight, exists := upperWeights[b.mealNames[mealKey]]
+		if !exists {
+			weight = 1
+		}
+		for hallA, itemsA := range byHall {
+			for hallB, itemsB := range byHall {
+				i, j := index[hallA], index[hallB]
+				if i >= j {
+					continue
+				}
+				similarity := jaccard(itemsA, itemsB) * weight
+				total[i][j] += similarity
+				total[j][i] += similarity
+				weights[i][j] += weight
+				weights[j][i] += weight
+				compared[i][j]++
+				compared[j][i]++
+			}
+		}
+	}
+	matrix := &Matrix{
+		StartDate:     b.start,
+		EndDate:       b.end,
+		DiningHalls:   halls,
+		Similarity:    make([][]float64, n),
+		MealsCompared: compared,
+		Unique:        map[string][]string{},
+	}
+	for i := range halls {
+		matrix.Similarity[i] = make([]float64, n)
+		for j := range halls {
+			if i == j {
+				matrix.Similarity[i][j] = 1
+			} else if weights[i][j] > 0 {
+				matrix.Similarity[i][j] = total[i][j] / weights[i][j]
+			}
+		}
+	}
+	// Number of halls serving each food
+	hallsServing := map[string]int{}
+	for _, foods := range b.served {
+		for key := range foods {
+			hallsServing[key]++
+		}
+	}
+	for hall, foods := range b.served {
+		unique := []string{}
+		for key := range foods {
+			if hallsServing[key] == 1 {
+				unique = append(unique, b.names[key])
+			}
+		}
+		sort.Strings(unique)
+		matrix.Unique[hall] = unique
+	}
+	return matrix
+}
+
+func jaccard(a map[string]bool, b map[string]bool) float64 {
+	intersection := 0
+	for key := range a {
+		if b[key] {
+			intersection++
+		}
+	}
+	union := len(a) + len(b) - intersection
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
@@ -0,0 +1,200 @@
+package hallstats
+
+import (
+	"math"
+	"sort"
+
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	"github.com/MichiganDiningAPI/internal/processing/rollups"
+	"github.com/MichiganDiningAPI/internal/processing/taxonomy"
+	"github.com/MichiganDiningAPI/internal/util/date"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Per dining hall summary statistics
+//
+// A serving is one food at one meal of a dining hall. Shares are fractions of
+// a hall's servings. The variety index is the effective number of foods served
+// (the exponential of the Shannon entropy of the servings), which is highest
+// when many foods are served equally often rather than a few very often.
+//
+
+// Overlap - Jaccard similarity of the foods served at two halls
+type Overlap struct {
+	DiningHall string  `json:"diningHall"`
+	Jaccard    float64 `json:"jaccard"`
+}
+
+// Summary - Statistics of a dining hall over a date range
+type Summary struct {
+	DiningHall string `json:"diningHall"`
+	StartDate  string `json:"startDate"`
+	EndDate    string `json:"endDate"`
+	// Number of servings and distinct foods
+	Servings    int64 `json:"servings"`
+	UniqueFoods int64 `json:"uniqueFoods"`
+	// Mean distinct foods per week over weeks with any servings
+	UniqueFoodsPerWeek float64 `json:"uniqueFoodsPerWeek"`
+	VarietyIndex       float64 `json:"varietyIndex"`
+	AllergenFreeShare  float64 `json:"allergenFreeShare"`
+	VeganShare         float64 `json:"veganShare"`
+	VegetarianShare    float64 `json:"vegetarianShare"`
+	// Number of foods not served at any other hall
+	ExclusiveFoods int64 `json:"exclusiveFoods"`
+	// Overlap with every other hall, most similar first
+	Overlaps []Overlap `json:"overlaps"`
+}
+
+type hall struct {
+	servings     map[string]int64
+	weeks        map[string]map[string]bool
+	total        int64
+	allergenFree int64
+	vegan        int64
+	vegetarian   int64
+}
+
+// Accumulator - Accumulates servings per dining hall from Foods
+type Accumulator struct {
+	halls     map[string]*hall
+	startDate string
+	endDate   string
+}
+
+// NewAccumulator - Create an empty Accumulator
+func NewAccumulator() *Accumulator {
+	return &Accumulator{halls: map[string]*hall{}}
+}
+
+// AddFood - Records the servings of a food at every dining hall
+func (a *Accumulator) AddFood(food *pb.Food) {
+	key := foodnames.Key(food.Key)
+	allergenFree, vegan, vegetarian := true, false, false
+	if food.MenuItem != nil {
+		allergenFree = len(taxonomy.Allergens.CanonicalList(food.MenuItem.Allergens)) == 0
+		vegan = taxonomy.Attributes.Matches(food.MenuItem.Attribute, "vegan")
+		vegetarian = taxonomy.Attributes.Matches(food.MenuItem.Attribute, "vegetarian")
+	}
+	for name, match := range food.DiningHallMatch {
+		if match.Campus != "" && match.Campus != "DINING HALLS" {
+			// For now, only analyze actual Dining Hall foods
+			continue
+		}
+		h, exists := a.halls[name]
+		if !exists {
+			h = &hall{servings: map[string]int64{}, weeks: map[string]map[string]bool{}}
+			a.halls[name] = h
+		}
+		for d, mealTime := range match.MealTime {
+			count := int64(len(mealTime.MealNames))
+			if count == 0 {
+				continue
+			}
+			if a.startDate == "" || d < a.startDate {
+				a.startDate = d
+			}
+			if d > a.endDate {
+				a.endDate = d
+			}
+			h.servings[key] += count
+			h.total += count
+			if allergenFree {
+				h.allergenFree += count
+			}
+			if vegan {
+				h.vegan += count
+			}
+			if vegetarian {
+				h.vegetarian += count
+			}
+			week, err := rollups.Period(rollups.Week, d)
+			if err != nil {
+				continue
+			}
+			if _, exists := h.weeks[week]; !exists {
+				h.weeks[week] = map[string]bool{}
+			}
+			h.weeks[week][key] = true
+		}
+	}
+}
+
+// Summaries - Returns the summary of every hall, sorted by name
+func (a *Accumulator) Summaries() []*Summary {
+	// Number of halls serving each food
+	hallsServing := map[string]int{}
+	for _, h := range a.halls {
+		for key := range h.servings {
+			hallsServing[key]++
+		}
+	}
+	summaries := []*Summary{}
+	for name, h := range a.halls {
+		if h.total == 0 {
+			continue
+		}
+		summary := &Summary{
+			DiningHall:        name,
+			StartDate:         a.startDate,
+			EndDate:           a.endDate,
+			Servings:          h.total,
+			UniqueFoods:       int64(len(h.servings)),
+			AllergenFreeShare: float64(h.allergenFree) / float64(h.total),
+			VeganShare:        float64(h.vegan) / float64(h.total),
+			VegetarianShare:   float64(h.vegetarian) / float64(h.total),
+			Overlaps:          []Overlap{},
+		}
+		entropy := 0.0
+		for key, count := range h.servings {
+			p := float64(count) / float64(h.total)
+			entropy -= p * math.Log(p)
+			if hallsServing[key] == 1 {
+				summary.ExclusiveFoods++
+			}
+		}
+		summary.VarietyIndex = math.Exp(entropy)
+		weekly := 0
+		for _, foods := range h.weeks {
+			weekly += len(foods)
+		}
+		if len(h.weeks) > 0 {
+			summary.UniqueFoodsPerWeek = float64(weekly) / float64(len(h.weeks))
+		}
+		for other, o := range a.halls {
+			if other == name || o.total == 0 {
+				continue
+			}
+			summary.Overlaps = append(summary.Overlaps, Overlap{DiningHall: other, Jaccard: jaccard(h.servings, o.servings)})
+		}
+		sort.Slice(summary.Overlaps, func(i, j int) bool {
+			if summary.Overlaps[i].Jaccard != summary.Overlaps[j].Jaccard {
+				return summary.Overlaps[i].Jaccard > summary.Overlaps[j].Jaccard
+			}
+			return summary.Overlaps[i].DiningHall < summary.Overlaps[j].DiningHall
+		})
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].DiningHall < summaries[j].DiningHall })
+	return summaries
+}
+
+// Window - Returns the first and last dates (yyyy-MM-dd) of the given number of complete weeks before today
+func Window(weeks int) (string, string) {
+	weekStart, _, _ := rollups.PeriodOf(rollups.Week, date.Now())
+	return date.FormatNoTime(weekStart.AddDate(0, 0, -7*weeks)), date.FormatNoTime(weekStart.AddDate(0, 0, -1))
+}
+
+func jaccard(a map[string]int64, b map[string]int64) float64 {
+	intersection := 0
+	for key := range a {
+		if _, exists := b[key]; exists {
+			intersection++
+		}
+	}
+	union := len(a) + len(b) - intersection
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
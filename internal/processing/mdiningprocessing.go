@@ -1,8 +1,7 @@
 package mdiningprocessing
 
 import (
-	"strings"
-
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
 	util "github.com/MichiganDiningAPI/internal/util/containers"
 	pb "github.com/anders617/mdining-proto/proto/mdining"
 	"github.com/golang/glog"
@@ -109,7 +108,9 @@ func ItemsToFilterableEntries(items *pb.Items) *pb.FilterableEntries {
 func FoodsToItems(foods *[]*pb.Food) *pb.Items {
 	items := pb.Items{Items: map[string]*pb.Item{}}
 	for _, food := range *foods {
-		item, exists := items.Items[food.Key]
+		// Rows written before canonical keys existed may carry raw keys
+		key := foodnames.Key(food.Key)
+		item, exists := items.Items[key]
 		if !exists {
 			item = &pb.Item{
 				Name:       food.Name,
@@ -117,7 +118,7 @@ func FoodsToItems(foods *[]*pb.Food) *pb.Items {
 			}
 			item.DiningHallMatches = make(map[string]*pb.Item_DiningHallMatch)
 			item.DiningHallMatchesArray = make([]*pb.Item_DiningHallMatch, 0, len(food.DiningHallMatch))
-			items.Items[food.Key] = item
+			items.Items[key] = item
 		}
 		for _, match := range food.DiningHallMatch {
 			itemMatch, exists := item.DiningHallMatches[match.Name]
@@ -144,7 +145,7 @@ func FoodsToItems(foods *[]*pb.Food) *pb.Items {
 		}
 		if len(item.DiningHallMatches) == 0 {
 			// If we didn't add any dining hall matches, having an item is pointless
-			delete(items.Items, food.Key)
+			delete(items.Items, key)
 		}
 	}
 	return &items
@@ -177,16 +178,19 @@ func MenusToFoods(menus *[]proto.Message) ([]proto.Message, error) {
 					glog.Warningf("MenuItem is nil for category %s in menu %s", cat.Name, m.DiningHallMeal+m.Date)
 					continue
 				}
-				food, exists := foods[menuItem.Name+m.Date]
+				// Group on the canonical key so name variants become one food.
+				// The first display name seen is kept as the food name.
+				key := foodnames.Key(menuItem.Name)
+				food, exists := foods[key+m.Date]
 				if !exists {
-					foods[menuItem.Name+m.Date] = &pb.Food{
-						Key:             strings.ToLower(menuItem.Name),
+					foods[key+m.Date] = &pb.Food{
+						Key:             key,
 						Date:            m.Date,
 						Name:            menuItem.Name,
 						Category:        []string{},
 						MenuItem:        menuItem,
 						DiningHallMatch: map[string]*pb.FoodDiningHallMatch{}}
-					food, _ = foods[menuItem.Name+m.Date]
+					food, _ = foods[key+m.Date]
 				}
 				f := food.(*pb.Food)
 				containsCategory := false
@@ -210,7 +214,16 @@ func MenusToFoods(menus *[]proto.Message) ([]proto.Message, error) {
 					mealTime = &pb.MealTime{Date: m.Date, FormattedDate: m.FormattedDate, MealNames: []string{}}
 					match.MealTime[m.Date] = mealTime
 				}
-				mealTime.MealNames = append(mealTime.MealNames, m.Meal)
+				// Variants grouped under one key may be served at the same meal
+				containsMeal := false
+				for _, meal := range mealTime.MealNames {
+					if meal == m.Meal {
+						containsMeal = true
+					}
+				}
+				if !containsMeal {
+					mealTime.MealNames = append(mealTime.MealNames, m.Meal)
+				}
 			}
 		}
 	}
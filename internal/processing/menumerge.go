@@ -0,0 +1,257 @@
+package menumerge
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+	"github.com/golang/glog"
+)
+
+//
+// Merging of menus retrieved from multiple upstream sources
+//
+// Each source produces dining halls and menus independently. Merge combines
+// them per (date, dining hall, meal) preferring sources earlier in the priority
+// list, falls back to lower priority sources when a higher one failed or had
+// nothing for a slot, and records discrepancies where more than one source
+// returned items for the same slot but the items disagree.
+//
+// Sources do not always spell dining hall names the same way. Halls are
+// matched on DiningHallNames keys, so names which only differ in case,
+// spacing or punctuation match, and names which differ otherwise can be
+// joined with an alias. Merged menus take the name of the merged dining hall.
+//
+
+// DiningHallNames - Normalizes dining hall names into keys which match across sources
+var DiningHallNames = foodnames.New()
+
+// LoadDiningHallAliases - Loads a json object mapping dining hall name aliases to canonical names
+// into DiningHallNames.
+//
+// Example: {"Bursley": "Bursley Dining Hall", "Mary Markley": "Markley Dining Hall"}
+func LoadDiningHallAliases(path string) error {
+	return DiningHallNames.LoadAliases(path)
+}
+
+// Source - The result of fetching from a single upstream source
+type Source struct {
+	Name        string
+	DiningHalls []*pb.DiningHall
+	Menus       []*pb.Menu
+	// Non-nil if the source failed entirely
+	Err error
+}
+
+// Discrepancy - A (date, dining hall, meal) slot where sources disagree
+type Discrepancy struct {
+	Date       string `json:"date"`
+	DiningHall string `json:"diningHall"`
+	Meal       string `json:"meal"`
+	// Source whose menu was kept
+	Chosen string `json:"chosen"`
+	// Source that disagreed with the chosen one
+	Other string `json:"other"`
+	// Canonical food keys only present in the chosen source
+	OnlyInChosen []string `json:"onlyInChosen,omitempty"`
+	// Canonical food keys only present in the other source
+	OnlyInOther []string `json:"onlyInOther,omitempty"`
+	// True if the other source had no menu for the slot even though it returned the dining hall for that date
+	MissingInOther bool `json:"missingInOther,omitempty"`
+}
+
+// Report - Summary of a merge
+type Report struct {
+	// Which source each merged menu came from, keyed by source name
+	MenusBySource map[string]int `json:"menusBySource"`
+	// Sources which failed and the error they returned
+	FailedSources map[string]string `json:"failedSources"`
+	Discrepancies []Discrepancy     `json:"discrepancies"`
+}
+
+// Result - Merged dining halls and menus along with a report
+type Result struct {
+	DiningHalls []*pb.DiningHall
+	Menus       []*pb.Menu
+	Report      Report
+}
+
+// Key for a menu slot that is stable across sources
+func slotKey(m *pb.Menu) string {
+	return strings.Join([]string{m.Date, DiningHallNames.Key(m.DiningHallName), strings.ToUpper(m.Meal)}, "|")
+}
+
+func hallDateKey(m *pb.Menu) string {
+	return m.Date + "|" + DiningHallNames.Key(m.DiningHallName)
+}
+
+func foodKeys(m *pb.Menu) map[string]bool {
+	keys := map[string]bool{}
+	for _, cat := range m.Category {
+		if cat == nil {
+			continue
+		}
+		for _, item := range cat.MenuItem {
+			if item == nil {
+				continue
+			}
+			keys[foodnames.Key(item.Name)] = true
+		}
+	}
+	return keys
+}
+
+func difference(a map[string]bool, b map[string]bool) []string {
+	diff := []string{}
+	for key := range a {
+		if !b[key] {
+			diff = append(diff, key)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// Orders sources by the given priority list, unknown sources go last in their original order
+func prioritize(priority []string, sources []*Source) []*Source {
+	rank := map[string]int{}
+	for idx, name := range priority {
+		rank[name] = idx
+	}
+	ordered := append([]*Source{}, sources...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, iok := rank[ordered[i].Name]
+		rj, jok := rank[ordered[j].Name]
+		if iok != jok {
+			return iok
+		}
+		return ri < rj
+	})
+	return ordered
+}
+
+// Merge - Combine sources, preferring those earlier in priority
+func Merge(priority []string, sources []*Source) *Result {
+	result := &Result{
+		DiningHalls: []*pb.DiningHall{},
+		Menus:       []*pb.Menu{},
+		Report: Report{
+			MenusBySource: map[string]int{},
+			FailedSources: map[string]string{},
+			Discrepancies: []Discrepancy{},
+		},
+	}
+	ordered := prioritize(priority, sources)
+
+	// Dining halls: first source to provide a hall wins
+	hallNames := map[string]string{}
+	for _, source := range ordered {
+		if source.Err != nil {
+			result.Report.FailedSources[source.Name] = source.Err.Error()
+			glog.Warningf("Source %s failed: %s", source.Name, source.Err)
+			continue
+		}
+		for _, dh := range source.DiningHalls {
+			key := DiningHallNames.Key(dh.Name)
+			if _, exists := hallNames[key]; exists {
+				continue
+			}
+			hallNames[key] = dh.Name
+			result.DiningHalls = append(result.DiningHalls, dh)
+		}
+	}
+
+	// Index each successful source's menus by slot
+	type indexed struct {
+		source    *Source
+		slots     map[string]*pb.Menu
+		hallDates map[string]bool
+	}
+	indexes := []*indexed{}
+	slotOrder := []string{}
+	seenSlots := map[string]bool{}
+	for _, source := range ordered {
+		if source.Err != nil {
+			continue
+		}
+		idx := &indexed{source: source, slots: map[string]*pb.Menu{}, hallDates: map[string]bool{}}
+		for _, menu := range source.Menus {
+			if menu == nil {
+				continue
+			}
+			key := slotKey(menu)
+			// Prefer a menu with items if the source reported the slot more than once
+			if existing, exists := idx.slots[key]; exists && len(existing.Category) > 0 {
+				continue
+			}
+			idx.slots[key] = menu
+			idx.hallDates[hallDateKey(menu)] = true
+			if !seenSlots[key] {
+				seenSlots[key] = true
+				slotOrder = append(slotOrder, key)
+			}
+		}
+		indexes = append(indexes, idx)
+	}
+
+	for _, key := range slotOrder {
+		// Choose the highest priority source with items, or failing that any menu for the slot
+		var chosen *indexed
+		for _, idx := range indexes {
+			menu, exists := idx.slots[key]
+			if !exists {
+				continue
+			}
+			if len(foodKeys(menu)) > 0 {
+				chosen = idx
+				break
+			}
+			if chosen == nil {
+				chosen = idx
+			}
+		}
+		chosenMenu := chosen.slots[key]
+		if name, exists := hallNames[DiningHallNames.Key(chosenMenu.DiningHallName)]; exists && name != chosenMenu.DiningHallName {
+			chosenMenu.DiningHallName = name
+			chosenMenu.DiningHallMeal = name + chosenMenu.Meal
+		}
+		result.Menus = append(result.Menus, chosenMenu)
+		result.Report.MenusBySource[chosen.source.Name]++
+
+		// Cross check against every other source
+		chosenFoods := foodKeys(chosenMenu)
+		for _, idx := range indexes {
+			if idx == chosen {
+				continue
+			}
+			d := Discrepancy{
+				Date:       chosenMenu.Date,
+				DiningHall: chosenMenu.DiningHallName,
+				Meal:       chosenMenu.Meal,
+				Chosen:     chosen.source.Name,
+				Other:      idx.source.Name,
+			}
+			other, exists := idx.slots[key]
+			if !exists {
+				if len(chosenFoods) > 0 && idx.hallDates[hallDateKey(chosenMenu)] {
+					d.MissingInOther = true
+					result.Report.Discrepancies = append(result.Report.Discrepancies, d)
+				}
+				continue
+			}
+			otherFoods := foodKeys(other)
+			if len(chosenFoods) == 0 || len(otherFoods) == 0 {
+				// Nothing to compare, one side simply had no published items
+				continue
+			}
+			d.OnlyInChosen = difference(chosenFoods, otherFoods)
+			d.OnlyInOther = difference(otherFoods, chosenFoods)
+			if len(d.OnlyInChosen) > 0 || len(d.OnlyInOther) > 0 {
+				result.Report.Discrepancies = append(result.Report.Discrepancies, d)
+			}
+		}
+	}
+	glog.Infof("Merged %d menus from %d sources with %d discrepancies", len(result.Menus), len(sources), len(result.Report.Discrepancies))
+	return result
+}
@@ -0,0 +1,268 @@
+package menumerge
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+// Returns a menu from source with one category of foods. The source is recorded in the description so tests
+// can tell which source a merged menu came from.
+func menu(source string, date string, hall string, meal string, foods ...string) *pb.Menu {
+	m := &pb.Menu{Date: date, DiningHallName: hall, Meal: meal, Description: source}
+	if len(foods) > 0 {
+		category := &pb.Category{Name: "Entrees"}
+		for _, food := range foods {
+			category.MenuItem = append(category.MenuItem, &pb.MenuItem{Name: food})
+		}
+		m.Category = []*pb.Category{category}
+	}
+	return m
+}
+
+func TestPrioritize(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority []string
+		sources  []string
+		want     []string
+	}{
+		{"priority order", []string{"a", "b", "c"}, []string{"c", "a", "b"}, []string{"a", "b", "c"}},
+		{"unknown sources last in original order", []string{"b"}, []string{"y", "b", "x"}, []string{"b", "y", "x"}},
+		{"no priority keeps order", nil, []string{"b", "a"}, []string{"b", "a"}},
+		{"unused priorities ignored", []string{"z", "a"}, []string{"b", "a"}, []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sources := []*Source{}
+			for _, name := range test.sources {
+				sources = append(sources, &Source{Name: name})
+			}
+			got := []string{}
+			for _, source := range prioritize(test.priority, sources) {
+				got = append(got, source.Name)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("prioritize(%q, %q) = %q, want %q", test.priority, test.sources, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	failed := errors.New("unavailable")
+	tests := []struct {
+		name     string
+		priority []string
+		sources  []*Source
+		// Source of each merged menu in order
+		wantMenus         []string
+		wantMenusBySource map[string]int
+		wantFailed        map[string]string
+		wantDiscrepancies []Discrepancy
+	}{
+		{
+			name:     "highest priority wins",
+			priority: []string{"a", "b"},
+			sources: []*Source{
+				{Name: "b", Menus: []*pb.Menu{menu("b", "2020-01-01", "Bursley", "LUNCH", "Pizza")}},
+				{Name: "a", Menus: []*pb.Menu{menu("a", "2020-01-01", "Bursley", "LUNCH", "Pizza")}},
+			},
+			wantMenus:         []string{"a"},
+			wantMenusBySource: map[string]int{"a": 1},
+			wantFailed:        map[string]string{},
+			wantDiscrepancies: []Discrepancy{},
+		},
+		{
+			name:     "slots match across hall and meal case",
+			priority: []string{"a", "b"},
+			sources: []*Source{
+				{Name: "a", Menus: []*pb.Menu{menu("a", "2020-01-01", "Bursley", "Lunch", "Pizza")}},
+				{Name: "b", Menus: []*pb.Menu{menu("b", "2020-01-01", "BURSLEY", "LUNCH", "pizza")}},
+			},
+			wantMenus:         []string{"a"},
+			wantMenusBySource: map[string]int{"a": 1},
+			wantFailed:        map[string]string{},
+			wantDiscrepancies: []Discrepancy{},
+		},
+		{
+			name:     "falls back when preferred source failed",
+			priority: []string{"a", "b"},
+			sources: []*Source{
+				{Name: "a", Err: failed},
+				{Name: "b", Menus: []*pb.Menu{menu("b", "2020-01-01", "Bursley", "LUNCH", "Pizza")}},
+			},
+			wantMenus:         []string{"b"},
+			wantMenusBySource: map[string]int{"b": 1},
+			wantFailed:        map[string]string{"a": "unavailable"},
+			wantDiscrepancies: []Discrepancy{},
+		},
+		{
+			name:     "falls back when preferred source has no items",
+			priority: []string{"a", "b"},
+			sources: []*Source{
+				{Name: "a", Menus: []*pb.Menu{menu("a", "2020-01-01", "Bursley", "LUNCH")}},
+				{Name: "b", Menus: []*pb.Menu{menu("b", "2020-01-01", "Bursley", "LUNCH", "Pizza")}},
+			},
+			wantMenus:         []string{"b"},
+			wantMenusBySource: map[string]int{"b": 1},
+			wantFailed:        map[string]string{},
+			wantDiscrepancies: []Discrepancy{},
+		},
+		{
+			name:     "keeps highest priority menu when none have items",
+			priority: []string{"a", "b"},
+			sources: []*Source{
+				{Name: "b", Menus: []*pb.Menu{menu("b", "2020-01-01", "Bursley", "LUNCH")}},
+				{Name: "a", Menus: []*pb.Menu{menu("a", "2020-01-01", "Bursley", "LUNCH")}},
+			},
+			wantMenus:         []string{"a"},
+			wantMenusBySource: map[string]int{"a": 1},
+			wantFailed:        map[string]string{},
+			wantDiscrepancies: []Discrepancy{},
+		},
+		{
+			name:     "prefers a duplicate slot with items within a source",
+			priority: []string{"a"},
+			sources: []*Source{
+				{Name: "a", Menus: []*pb.Menu{
+					menu("a", "2020-01-01", "Bursley", "LUNCH"),
+					menu("a", "2020-01-01", "Bursley", "LUNCH", "Pizza"),
+					menu("a", "2020-01-01", "Bursley", "LUNCH"),
+				}},
+			},
+			wantMenus:         []string{"a"},
+			wantMenusBySource: map[string]int{"a": 1},
+			wantFailed:        map[string]string{},
+			wantDiscrepancies: []Discrepancy{},
+		},
+		{
+			name:     "falls back for slot missing in preferred source",
+			priority: []string{"a", "b"},
+			sources: []*Source{
+				{Name: "a", Menus: []*pb.Menu{menu("a", "2020-01-01", "Bursley", "LUNCH", "Pizza")}},
+				{Name: "b", Menus: []*pb.Menu{
+					menu("b", "2020-01-01", "Bursley", "LUNCH", "Pizza"),
+					menu("b", "2020-01-01", "Bursley", "DINNER", "Tacos"),
+					// a did not return Mosher-Jordan on this date so its absence is not a discrepancy
+					menu("b", "2020-01-01", "Mosher-Jordan", "DINNER", "Soup"),
+				}},
+			},
+			wantMenus:         []string{"a", "b", "b"},
+			wantMenusBySource: map[string]int{"a": 1, "b": 2},
+			wantFailed:        map[string]string{},
+			wantDiscrepancies: []Discrepancy{
+				{Date: "2020-01-01", DiningHall: "Bursley", Meal: "DINNER", Chosen: "b", Other: "a", MissingInOther: true},
+			},
+		},
+		{
+			name:     "disagreeing items",
+			priority: []string{"a", "b"},
+			sources: []*Source{
+				{Name: "a", Menus: []*pb.Menu{menu("a", "2020-01-01", "Bursley", "LUNCH", "Pizza", "Caesar Salad")}},
+				{Name: "b", Menus: []*pb.Menu{menu("b", "2020-01-01", "Bursley", "LUNCH", "Pizza (V)", "Tomato Soup")}},
+			},
+			wantMenus:         []string{"a"},
+			wantMenusBySource: map[string]int{"a": 1},
+			wantFailed:        map[string]string{},
+			wantDiscrepancies: []Discrepancy{
+				{
+					Date: "2020-01-01", DiningHall: "Bursley", Meal: "LUNCH", Chosen: "a", Other: "b",
+					OnlyInChosen: []string{"caesar salad"}, OnlyInOther: []string{"tomato soup"},
+				},
+			},
+		},
+		{
+			name:     "unknown sources have lowest priority",
+			priority: []string{"a"},
+			sources: []*Source{
+				{Name: "x", Menus: []*pb.Menu{menu("x", "2020-01-01", "Bursley", "LUNCH", "Pizza")}},
+				{Name: "a", Menus: []*pb.Menu{menu("a", "2020-01-01", "Bursley", "LUNCH", "Pizza")}},
+			},
+			wantMenus:         []string{"a"},
+			wantMenusBySource: map[string]int{"a": 1},
+			wantFailed:        map[string]string{},
+			wantDiscrepancies: []Discrepancy{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Merge(test.priority, test.sources)
+			gotMenus := []string{}
+			for _, m := range result.Menus {
+				gotMenus = append(gotMenus, m.Description)
+			}
+			if !reflect.DeepEqual(gotMenus, test.wantMenus) {
+				t.Errorf("Merge chose menus from %q, want %q", gotMenus, test.wantMenus)
+			}
+			if !reflect.DeepEqual(result.Report.MenusBySource, test.wantMenusBySource) {
+				t.Errorf("Merge reported menus by source %v, want %v", result.Report.MenusBySource, test.wantMenusBySource)
+			}
+			if !reflect.DeepEqual(result.Report.FailedSources, test.wantFailed) {
+				t.Errorf("Merge reported failed sources %v, want %v", result.Report.FailedSources, test.wantFailed)
+			}
+			if !reflect.DeepEqual(result.Report.Discrepancies, test.wantDiscrepancies) {
+				t.Errorf("Merge reported discrepancies %+v, want %+v", result.Report.Discrepancies, test.wantDiscrepancies)
+			}
+		})
+	}
+}
+
+func TestMergeDiningHalls(t *testing.T) {
+	sources := []*Source{
+		{Name: "b", DiningHalls: []*pb.DiningHall{{Name: "Bursley", Campus: "b"}, {Name: "East Quad", Campus: "b"}}},
+		{Name: "c", Err: errors.New("unavailable"), DiningHalls: []*pb.DiningHall{{Name: "South Quad", Campus: "c"}}},
+		{Name: "a", DiningHalls: []*pb.DiningHall{{Name: "BURSLEY", Campus: "a"}}},
+	}
+	got := []string{}
+	for _, hall := range Merge([]string{"a", "b", "c"}, sources).DiningHalls {
+		got = append(got, hall.Name+" from "+hall.Campus)
+	}
+	want := []string{"BURSLEY from a", "East Quad from b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge kept dining halls %q, want %q", got, want)
+	}
+}
+
+func TestMergeDiningHallAliases(t *testing.T) {
+	defer func(names *foodnames.Normalizer) { DiningHallNames = names }(DiningHallNames)
+	DiningHallNames = foodnames.New()
+	DiningHallNames.AddAlias("Bursley", "Bursley Dining Hall")
+	sources := []*Source{
+		{Name: "a", DiningHalls: []*pb.DiningHall{{Name: "Bursley"}}, Menus: []*pb.Menu{
+			menu("a", "2020-01-01", "Bursley", "LUNCH", "Pizza"),
+		}},
+		{Name: "b", DiningHalls: []*pb.DiningHall{{Name: "Bursley Dining Hall"}}, Menus: []*pb.Menu{
+			menu("b", "2020-01-01", "Bursley Dining Hall", "LUNCH", "Pizza", "Tacos"),
+			menu("b", "2020-01-01", "Bursley Dining Hall", "DINNER", "Soup"),
+		}},
+	}
+	result := Merge([]string{"a", "b"}, sources)
+	if len(result.DiningHalls) != 1 || result.DiningHalls[0].Name != "Bursley" {
+		t.Errorf("Merge kept dining halls %+v, want only Bursley", result.DiningHalls)
+	}
+	got := []string{}
+	for _, m := range result.Menus {
+		got = append(got, m.Description+" "+m.DiningHallName+" "+m.DiningHallMeal)
+	}
+	want := []string{"a Bursley ", "b Bursley BursleyDINNER"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge chose menus %q, want %q", got, want)
+	}
+	wantDiscrepancies := []Discrepancy{
+		{Date: "2020-01-01", DiningHall: "Bursley", Meal: "LUNCH", Chosen: "a", Other: "b", OnlyInChosen: []string{}, OnlyInOther: []string{"tacos"}},
+		{Date: "2020-01-01", DiningHall: "Bursley", Meal: "DINNER", Chosen: "b", Other: "a", MissingInOther: true},
+	}
+	if !reflect.DeepEqual(result.Report.Discrepancies, wantDiscrepancies) {
+		t.Errorf("Merge reported discrepancies %+v, want %+v", result.Report.Discrepancies, wantDiscrepancies)
+	}
+
+	// Without the alias the names are different halls
+	DiningHallNames = foodnames.New()
+	if got := len(Merge([]string{"a", "b"}, sources).DiningHalls); got != 2 {
+		t.Errorf("Merge without alias kept %d dining halls, want 2", got)
+	}
+}
@@ -0,0 +1,195 @@
+package nextserving
+
+import (
+	"sort"
+	"time"
+
+	"github.com/MichiganDiningAPI/internal/processing/rotation"
+	"github.com/MichiganDiningAPI/internal/util/date"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Prediction of when and where a food will next be served
+//
+// For each day past the published menus and each dining hall the chance of the
+// food being served combines two estimates. The hall's historical serving rate
+// is scaled by how much more often the food is served on that weekday than on
+// an average day. If the hall has a detected menu rotation, this is blended,
+// by the rotation's confidence, with whether the food was served a whole
+// number of cycles earlier on the latest published day. The most likely next
+// serving is the day and hall with the highest chance of being the first time
+// the food is served again.
+//
+
+// Options - Parameters of a prediction
+type Options struct {
+	// Days of history before today used to estimate serving rates
+	HistoryDays int
+	// Days past the last published menu to predict
+	HorizonDays int
+	// Maximum number of alternative predictions returned
+	NumAlternatives int
+}
+
+// DefaultOptions - Six months of history predicting four weeks ahead
+var DefaultOptions = Options{HistoryDays: 180, HorizonDays: 28, NumAlternatives: 5}
+
+// Input - Everything known about a food
+type Input struct {
+	// Servings of the food from HistoryDays ago onward, including published future menus
+	History []*pb.Food
+	// Map from weekday name to servings on that weekday, from FoodStat.FoodWeekdayCounts
+	WeekdayCounts map[string]int64
+	// Map from dining hall to its detected menu rotation
+	Rotations map[string]*rotation.Rotation
+	Today     time.Time
+}
+
+// Serving - A published or predicted serving of a food
+type Serving struct {
+	Date       string   `json:"date"`
+	DiningHall string   `json:"diningHall"`
+	Meals      []string `json:"meals"`
+	Predicted  bool     `json:"predicted"`
+	// Chance this is the first serving after the published menus, 1 for published servings
+	Probability float64 `json:"probability"`
+}
+
+// Prediction - Published future servings and predictions for after them
+type Prediction struct {
+	Published []*Serving `json:"published"`
+	// Most likely next serving past the published menus, nil if there is no history to predict from
+	Next         *Serving   `json:"next"`
+	Alternatives []*Serving `json:"alternatives"`
+}
+
+// Per hall serving history of the food
+type hallHistory struct {
+	// Dates (yyyy-MM-dd) served before the prediction horizon
+	dates map[string]bool
+	// Number of times served at each meal
+	meals map[string]int
+}
+
+// Predict - Predicts the next serving of a food past its published servings
+func Predict(in Input, opts Options) *Prediction {
+	prediction := &Prediction{Published: []*Serving{}, Alternatives: []*Serving{}}
+	today := date.FormatNoTime(in.Today)
+	lastPublished := date.DayStart(in.Today).AddDate(0, 0, -1)
+	halls := map[string]*hallHistory{}
+	for _, food := range in.History {
+		for hall, match := range food.DiningHallMatch {
+			h, exists := halls[hall]
+			if !exists {
+				h = &hallHistory{dates: map[string]bool{}, meals: map[string]int{}}
+				halls[hall] = h
+			}
+			for d, mealTime := range match.MealTime {
+				h.dates[d] = true
+				for _, meal := range mealTime.MealNames {
+					h.meals[meal]++
+				}
+				if d < today {
+					continue
+				}
+				prediction.Published = append(prediction.Published, &Serving{
+					Date: d, DiningHall: hall, Meals: mealTime.MealNames, Probability: 1})
+				if t, err := date.ParseNoTime(&d); err == nil && t.After(lastPublished) {
+					lastPublished = t
+				}
+			}
+		}
+	}
+	sort.Slice(prediction.Published, func(i, j int) bool {
+		a, b := prediction.Published[i], prediction.Published[j]
+		return a.Date < b.Date || (a.Date == b.Date && a.DiningHall < b.DiningHall)
+	})
+	if len(halls) == 0 {
+		return prediction
+	}
+
+	// Relative likelihood of each weekday, averaging 1 over the week
+	weekdayWeight := map[time.Weekday]float64{}
+	total := int64(0)
+	for _, count := range in.WeekdayCounts {
+		total += count
+	}
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		weekdayWeight[wd] = 1
+		if total > 0 {
+			weekdayWeight[wd] = float64(in.WeekdayCounts[wd.String()]) * 7 / float64(total)
+		}
+	}
+
+	historyStart := date.DayStart(in.Today).AddDate(0, 0, -opts.HistoryDays)
+	historyDays := float64(int(lastPublished.Sub(historyStart).Hours()+12)/24 + 1)
+	candidates := []*Serving{}
+	notYetServed := 1.0
+	for i := 1; i <= opts.HorizonDays; i++ {
+		day := lastPublished.AddDate(0, 0, i)
+		dayCandidates := []*Serving{}
+		notServedToday := 1.0
+		for hall, h := range halls {
+			rate := float64(len(h.dates)) / historyDays * weekdayWeight[day.Weekday()]
+			p := rate
+			if r, exists := in.Rotations[hall]; exists && r.CycleDays > 0 {
+				p = (1-r.Confidence)*rate + r.Confidence*servedCyclesBefore(h.dates, day, r.CycleDays, lastPublished)
+			}
+			if p > 1 {
+				p = 1
+			}
+			if p <= 0 {
+				continue
+			}
+			notServedToday *= 1 - p
+			dayCandidates = append(dayCandidates, &Serving{
+				Date:        date.FormatNoTime(day),
+				DiningHall:  hall,
+				Meals:       []string{mostCommonMeal(h.meals)},
+				Predicted:   true,
+				Probability: p,
+			})
+		}
+		// Chance the food is first served again at each hall on this day
+		for _, c := range dayCandidates {
+			c.Probability = notYetServed * c.Probability
+		}
+		candidates = append(candidates, dayCandidates...)
+		notYetServed *= notServedToday
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Probability > candidates[j].Probability })
+	if len(candidates) == 0 {
+		return prediction
+	}
+	prediction.Next = candidates[0]
+	for _, c := range candidates[1:] {
+		if len(prediction.Alternatives) >= opts.NumAlternatives {
+			break
+		}
+		prediction.Alternatives = append(prediction.Alternatives, c)
+	}
+	return prediction
+}
+
+// Returns 1 if the food was served on the latest published day a whole number of cycles before day
+func servedCyclesBefore(dates map[string]bool, day time.Time, cycleDays int, lastPublished time.Time) float64 {
+	d := day.AddDate(0, 0, -cycleDays)
+	for d.After(lastPublished) {
+		d = d.AddDate(0, 0, -cycleDays)
+	}
+	if dates[date.FormatNoTime(d)] {
+		return 1
+	}
+	return 0
+}
+
+func mostCommonMeal(meals map[string]int) string {
+	best, bestCount := "", 0
+	for meal, count := range meals {
+		if count > bestCount || (count == bestCount && meal < best) {
+			best, bestCount = meal, count
+		}
+	}
+	return best
+}
@@ -0,0 +1,335 @@
+package nutrition
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Nutrition aggregates per dining hall meal and nutrition history per food
+//
+// Nutrition facts are read from the first size of each menu item. Items
+// without any nutritional info are counted but left out of the distributions.
+// An item is protein rich if it has at least ProteinRichGrams of protein and a
+// sodium outlier if its sodium is above the upper Tukey fence (Q3 + 1.5 IQR) of
+// the other items on the same meal.
+//
+
+// ProteinRichGrams - Minimum grams of protein for an item to be protein rich
+const ProteinRichGrams = 15
+
+// Minimum number of items with sodium values needed to look for outliers
+const minOutlierItems = 4
+
+// Distribution - Summary of the values of a nutrient over the items of a meal
+type Distribution struct {
+	Count  int64   `json:"count"`
+	Min    float64 `json:"min"`
+	Q1     float64 `json:"q1"`
+	Median float64 `json:"median"`
+	Q3     float64 `json:"q3"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+}
+
+// Outlier - An item with an unusually high value of a nutrient
+type Outlier struct {
+	Name  string `json:"name"`
+	Value int32  `json:"value"`
+}
+
+// Aggregate - Nutrition of the items served at a dining hall meal on a date
+type Aggregate struct {
+	Date string `json:"date"`
+	// DiningHall + Meal, matching the key of the menu
+	DiningHallMeal string `json:"diningHallMeal"`
+	DiningHall     string `json:"diningHall"`
+	Meal           string `json:"meal"`
+	NumItems       int64  `json:"numItems"`
+	// Number of items with nutritional info
+	NumWithNutrition int64        `json:"numWithNutrition"`
+	Calories         Distribution `json:"calories"`
+	Protein          Distribution `json:"protein"`
+	Sodium           Distribution `json:"sodium"`
+	ProteinRichItems int64        `json:"proteinRichItems"`
+	// Sodium outliers, highest sodium first
+	SodiumOutliers []Outlier `json:"sodiumOutliers"`
+}
+
+// HistoryEntry - The nutrition facts of a food over a range of dates on which they did not change
+type HistoryEntry struct {
+	StartDate   string                `json:"startDate"`
+	EndDate     string                `json:"endDate"`
+	NumDays     int64                 `json:"numDays"`
+	ServingSize string                `json:"servingSize"`
+	Calories    int32                 `json:"calories"`
+	Protein     int32                 `json:"protein"`
+	Sodium      int32                 `json:"sodium"`
+	Nutrients   []*pb.NutritionalInfo `json:"nutrients"`
+}
+
+// FoodNutrition - The nutrition history of a food, oldest entry first
+type FoodNutrition struct {
+	Key     string         `json:"key"`
+	Name    string         `json:"name"`
+	History []HistoryEntry `json:"history"`
+}
+
+// Facts of the first size of an item, nil if it has no nutritional info
+type facts struct {
+	servingSize string
+	calories    int32
+	protein     int32
+	sodium      int32
+	hasSodium   bool
+	nutrients   []*pb.NutritionalInfo
+}
+
+func factsOf(item *pb.MenuItem) *facts {
+	if len(item.ItemSizes) == 0 || item.ItemSizes[0] == nil || len(item.ItemSizes[0].NutritionalInfo) == 0 {
+		return nil
+	}
+	size := item.ItemSizes[0]
+	f := &facts{servingSize: size.ServingSize, nutrients: size.NutritionalInfo}
+	for _, info := range size.NutritionalInfo {
+		if info == nil {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(info.Name))
+		switch {
+		case name == "calories":
+			f.calories = info.Value
+		case strings.HasPrefix(name, "protein"):
+			f.protein = info.Value
+		case strings.HasPrefix(name, "sodium"):
+			f.sodium = info.Value
+			f.hasSodium = true
+		}
+	}
+	return f
+}
+
+// Summarize - Computes the nutrition aggregate of a menu
+func Summarize(menu *pb.Menu) *Aggregate {
+	aggregate := &Aggregate{
+		Date:           menu.Date,
+		DiningHallMeal: menu.DiningHallName + menu.Meal,
+		DiningHall:     menu.DiningHallName,
+		Meal:           menu.Meal,
+		SodiumOutliers: []Outlier{},
+	}
+	calories, protein, sodium := []float64{}, []float64{}, []float64{}
+	type sodiumItem struct {
+		name  string
+		value int32
+	}
+	sodiumItems := []sodiumItem{}
+	for _, cat := range menu.Category {
+		if cat == nil {
+			continue
+		}
+		for _, item := range cat.MenuItem {
+			if item == nil {
+				continue
+			}
+			aggregate.NumItems++
+			f := factsOf(item)
+			if f == nil {
+				continue
+			}
+			aggregate.NumWithNutrition++
+			calories = append(calories, float64(f.calories))
+			protein = append(protein, float64(f.protein))
+			if f.protein >= ProteinRichGrams {
+				aggregate.ProteinRichItems++
+			}
+			if f.hasSodium {
+				sodium = append(sodium, float64(f.sodium))
+				sodiumItems = append(sodiumItems, sodiumItem{name: item.Name, value: f.sodium})
+			}
+		}
+	}
+	aggregate.Calories = distribution(calories)
+	aggregate.Protein = distribution(protein)
+	aggregate.Sodium = distribution(sodium)
+	if len(sodiumItems) >= minOutlierItems {
+		fence := aggregate.Sodium.Q3 + 1.5*(aggregate.Sodium.Q3-aggregate.Sodium.Q1)
+		for _, item := range sodiumItems {
+			if float64(item.value) > fence {
+				aggregate.SodiumOutliers = append(aggregate.SodiumOutliers, Outlier{Name: item.name, Value: item.value})
+			}
+		}
+		sort.Slice(aggregate.SodiumOutliers, func(i, j int) bool {
+			a, b := aggregate.SodiumOutliers[i], aggregate.SodiumOutliers[j]
+			return a.Value > b.Value || (a.Value == b.Value && a.Name < b.Name)
+		})
+	}
+	return aggregate
+}
+
+func distribution(values []float64) Distribution {
+	d := Distribution{Count: int64(len(values))}
+	if len(values) == 0 {
+		return d
+	}
+	sort.Float64s(values)
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	d.Min = values[0]
+	d.Q1 = percentile(values, 0.25)
+	d.Median = percentile(values, 0.5)
+	d.Q3 = percentile(values, 0.75)
+	d.Max = values[len(values)-1]
+	d.Mean = total / float64(len(values))
+	return d
+}
+
+// Linearly interpolated percentile p (0 to 1) of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+// History - Accumulates the nutrition facts of every food by date
+type History struct {
+	names map[string]string
+	// Map from food key to date to index into facts, for dates not yet compacted
+	dates map[string]map[string]int
+	// Map from food key to the compacted ranges of dates with the same facts, oldest first
+	ranges map[string][]factsRange
+	facts  []*facts
+	// Map from signature to index into facts so repeated facts are stored once
+	ids map[string]int
+}
+
+// Consecutive served dates of a food with the same facts
+type factsRange struct {
+	startDate string
+	endDate   string
+	numDays   int64
+	id        int
+}
+
+// NewHistory - Create an empty History
+func NewHistory() *History {
+	return &History{
+		names:  map[string]string{},
+		dates:  map[string]map[string]int{},
+		ranges: map[string][]factsRange{},
+		ids:    map[string]int{},
+	}
+}
+
+// AddMenu - Records the nutrition facts of the items on a menu
+func (h *History) AddMenu(menu *pb.Menu) {
+	for _, cat := range menu.Category {
+		if cat == nil {
+			continue
+		}
+		for _, item := range cat.MenuItem {
+			if item == nil {
+				continue
+			}
+			f := factsOf(item)
+			if f == nil {
+				continue
+			}
+			key := foodnames.Key(item.Name)
+			if _, exists := h.names[key]; !exists {
+				h.names[key] = item.Name
+			}
+			if _, exists := h.dates[key]; !exists {
+				h.dates[key] = map[string]int{}
+			}
+			h.dates[key][menu.Date] = h.id(f)
+		}
+	}
+}
+
+// Compact - Folds the recorded dates before the yyyy-MM-dd date before into
+// ranges, so a History fed menus in date order holds one range per change of
+// facts instead of every date. Menus dated before before must not be added
+// afterwards.
+func (h *History) Compact(before string) {
+	h.compact(func(d string) bool { return d < before })
+}
+
+// Folds the recorded dates for which fold returns true into ranges
+func (h *History) compact(fold func(string) bool) {
+	for key, byDate := range h.dates {
+		dates := make([]string, 0, len(byDate))
+		for d := range byDate {
+			if fold(d) {
+				dates = append(dates, d)
+			}
+		}
+		sort.Strings(dates)
+		ranges := h.ranges[key]
+		for _, d := range dates {
+			id := byDate[d]
+			delete(byDate, d)
+			if n := len(ranges); n > 0 && ranges[n-1].id == id {
+				ranges[n-1].endDate = d
+				ranges[n-1].numDays++
+				continue
+			}
+			ranges = append(ranges, factsRange{startDate: d, endDate: d, numDays: 1, id: id})
+		}
+		h.ranges[key] = ranges
+		if len(byDate) == 0 {
+			delete(h.dates, key)
+		}
+	}
+}
+
+func (h *History) id(f *facts) int {
+	var b strings.Builder
+	b.WriteString(f.servingSize)
+	for _, info := range f.nutrients {
+		if info != nil {
+			b.WriteString("|" + info.Name + ":" + strconv.Itoa(int(info.Value)) + info.Units)
+		}
+	}
+	signature := b.String()
+	id, exists := h.ids[signature]
+	if !exists {
+		id = len(h.facts)
+		h.ids[signature] = id
+		h.facts = append(h.facts, f)
+	}
+	return id
+}
+
+// Foods - Returns the nutrition history of every food, sorted by key
+func (h *History) Foods() []*FoodNutrition {
+	h.compact(func(string) bool { return true })
+	foods := make([]*FoodNutrition, 0, len(h.ranges))
+	for key, ranges := range h.ranges {
+		food := &FoodNutrition{Key: key, Name: h.names[key], History: []HistoryEntry{}}
+		for _, r := range ranges {
+			f := h.facts[r.id]
+			food.History = append(food.History, HistoryEntry{
+				StartDate:   r.startDate,
+				EndDate:     r.endDate,
+				NumDays:     r.numDays,
+				ServingSize: f.servingSize,
+				Calories:    f.calories,
+				Protein:     f.protein,
+				Sodium:      f.sodium,
+				Nutrients:   f.nutrients,
+			})
+		}
+		foods = append(foods, food)
+	}
+	sort.Slice(foods, func(i, j int) bool { return foods[i].Key < foods[j].Key })
+	return foods
+}
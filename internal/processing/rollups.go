@@ -0,0 +1,234 @@
+package rollups
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/MichiganDiningAPI/internal/util/date"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Aggregation of daily FoodStats into weekly, monthly and academic term rollups
+//
+
+const (
+	// Week - ISO weeks starting on Monday
+	Week = "week"
+	// Month - Calendar months
+	Month = "month"
+	// Term - Academic terms and the breaks between them, see TermOf
+	Term = "term"
+)
+
+// Granularities - All supported rollup granularities
+var Granularities = []string{Week, Month, Term}
+
+// Valid - Whether granularity is one of Granularities
+func Valid(granularity string) bool {
+	for _, g := range Granularities {
+		if g == granularity {
+			return true
+		}
+	}
+	return false
+}
+
+// Rollup - FoodStats aggregated over a period
+type Rollup struct {
+	Granularity string `json:"granularity"`
+	// Start date (yyyy-MM-dd) of the period, rollups sort by this within a granularity
+	Period    string `json:"period"`
+	Label     string `json:"label"`
+	StartDate string `json:"startDate"`
+	EndDate   string `json:"endDate"`
+	// Number of days in the period which had stats
+	NumDays              int64 `json:"numDays"`
+	NumUniqueFoods       int64 `json:"numUniqueFoods"`
+	TotalFoodMealsServed int64 `json:"totalFoodMealsServed"`
+	// Map from food key to dining hall meals it was served at
+	TimesServed     map[string]int64 `json:"timesServed"`
+	CategoryCounts  map[string]int64 `json:"categoryCounts"`
+	AllergenCounts  map[string]int64 `json:"allergenCounts"`
+	AttributeCounts map[string]int64 `json:"attributeCounts"`
+	// Map from dining hall name to meals served and distinct foods served
+	DiningHallMealsServed map[string]int64 `json:"diningHallMealsServed"`
+	DiningHallUniqueFoods map[string]int64 `json:"diningHallUniqueFoods"`
+}
+
+// TermPeriod - An academic term or break
+type TermPeriod struct {
+	Name      string
+	Start     time.Time
+	End       time.Time
+	InSession bool
+}
+
+// Label - e.g. "Fall 2020", breaks are labelled with the year they start in
+func (t TermPeriod) Label() string {
+	return fmt.Sprintf("%s %d", t.Name, t.Start.Year())
+}
+
+// Term boundaries as (month, day) of the first day of each period. Exact term
+// dates move by a few days each year, these approximate the University of
+// Michigan calendar closely enough for dining statistics. Spring and summer
+// half terms are treated as a break since most dining halls are closed.
+var termStarts = []struct {
+	month     time.Month
+	day       int
+	name      string
+	inSession bool
+}{
+	{time.January, 6, "Winter", true},
+	{time.May, 1, "Spring/Summer", false},
+	{time.August, 25, "Fall", true},
+	{time.December, 21, "Winter Break", false},
+}
+
+// TermOf - Returns the academic term or break containing t
+func TermOf(t time.Time) TermPeriod {
+	day := date.DayStart(t)
+	year := day.Year()
+	// Start of the first term of the year, before this the previous year's winter break applies
+	idx := len(termStarts) - 1
+	startYear := year - 1
+	for i, ts := range termStarts {
+		if !day.Before(time.Date(year, ts.month, ts.day, 0, 0, 0, 0, date.USEasternLocation)) {
+			idx = i
+			startYear = year
+		}
+	}
+	ts := termStarts[idx]
+	start := time.Date(startYear, ts.month, ts.day, 0, 0, 0, 0, date.USEasternLocation)
+	next := termStarts[(idx+1)%len(termStarts)]
+	nextYear := startYear
+	if idx == len(termStarts)-1 {
+		nextYear++
+	}
+	end := time.Date(nextYear, next.month, next.day, 0, 0, 0, 0, date.USEasternLocation).AddDate(0, 0, -1)
+	return TermPeriod{Name: ts.name, Start: start, End: end, InSession: ts.inSession}
+}
+
+// PeriodOf - Returns the first and last day and label of the period containing t
+func PeriodOf(granularity string, t time.Time) (time.Time, time.Time, string) {
+	day := date.DayStart(t)
+	switch granularity {
+	case Week:
+		offset := (int(day.Weekday()) + 6) % 7
+		start := day.AddDate(0, 0, -offset)
+		year, week := start.ISOWeek()
+		return start, start.AddDate(0, 0, 6), fmt.Sprintf("%d-W%02d", year, week)
+	case Month:
+		start := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, date.USEasternLocation)
+		return start, start.AddDate(0, 1, -1), start.Format("2006-01")
+	default:
+		term := TermOf(day)
+		return term.Start, term.End, term.Label()
+	}
+}
+
+// Period - Returns the period key (start date) of the period containing the yyyy-MM-dd date d
+func Period(granularity string, d string) (string, error) {
+	t, err := date.ParseNoTime(&d)
+	if err != nil {
+		return "", err
+	}
+	start, _, _ := PeriodOf(granularity, t)
+	return date.FormatNoTime(start), nil
+}
+
+// Accumulator - Aggregates daily stats streamed into it into rollups of one granularity
+type Accumulator struct {
+	granularity string
+	// Periods to aggregate, every period when nil
+	periods   map[string]bool
+	byPeriod  map[string]*Rollup
+	hallFoods map[string]map[string]map[string]bool
+}
+
+// NewAccumulator - Create an Accumulator of rollups of granularity for the
+// given periods (start dates), or every period when periods is nil
+func NewAccumulator(granularity string, periods map[string]bool) *Accumulator {
+	return &Accumulator{
+		granularity: granularity,
+		periods:     periods,
+		byPeriod:    map[string]*Rollup{},
+		hallFoods:   map[string]map[string]map[string]bool{},
+	}
+}
+
+// Add - Adds a daily stat to the rollup of its period
+func (a *Accumulator) Add(stat *pb.FoodStat) {
+	t, err := date.ParseNoTime(&stat.Date)
+	if err != nil {
+		return
+	}
+	start, end, label := PeriodOf(a.granularity, t)
+	period := date.FormatNoTime(start)
+	if a.periods != nil && !a.periods[period] {
+		return
+	}
+	rollup, exists := a.byPeriod[period]
+	if !exists {
+		rollup = &Rollup{
+			Granularity:           a.granularity,
+			Period:                period,
+			Label:                 label,
+			StartDate:             period,
+			EndDate:               date.FormatNoTime(end),
+			TimesServed:           map[string]int64{},
+			CategoryCounts:        map[string]int64{},
+			AllergenCounts:        map[string]int64{},
+			AttributeCounts:       map[string]int64{},
+			DiningHallMealsServed: map[string]int64{},
+			DiningHallUniqueFoods: map[string]int64{},
+		}
+		a.byPeriod[period] = rollup
+		a.hallFoods[period] = map[string]map[string]bool{}
+	}
+	rollup.NumDays++
+	rollup.TotalFoodMealsServed += stat.TotalFoodMealsServed
+	addCounts(rollup.TimesServed, stat.TimesServed)
+	addCounts(rollup.CategoryCounts, stat.CategoryCounts)
+	addCounts(rollup.AllergenCounts, stat.AllergenCounts)
+	addCounts(rollup.AttributeCounts, stat.AttributeCounts)
+	addCounts(rollup.DiningHallMealsServed, stat.DiningHallMealsServed)
+	for hall, foods := range stat.DiningHallFoodCounts {
+		if _, exists := a.hallFoods[period][hall]; !exists {
+			a.hallFoods[period][hall] = map[string]bool{}
+		}
+		for food := range foods.Data {
+			a.hallFoods[period][hall][food] = true
+		}
+	}
+}
+
+// Rollups - Returns the accumulated rollups sorted by period
+func (a *Accumulator) Rollups() []*Rollup {
+	rollups := make([]*Rollup, 0, len(a.byPeriod))
+	for period, rollup := range a.byPeriod {
+		rollup.NumUniqueFoods = int64(len(rollup.TimesServed))
+		for hall, foods := range a.hallFoods[period] {
+			rollup.DiningHallUniqueFoods[hall] = int64(len(foods))
+		}
+		rollups = append(rollups, rollup)
+	}
+	sort.Slice(rollups, func(i, j int) bool { return rollups[i].Period < rollups[j].Period })
+	return rollups
+}
+
+// Compute - Aggregates daily stats into rollups of the given granularity, sorted by period
+func Compute(granularity string, stats []*pb.FoodStat) []*Rollup {
+	accumulator := NewAccumulator(granularity, nil)
+	for _, stat := range stats {
+		accumulator.Add(stat)
+	}
+	return accumulator.Rollups()
+}
+
+func addCounts(dst map[string]int64, src map[string]int64) {
+	for key, count := range src {
+		dst[key] += count
+	}
+}
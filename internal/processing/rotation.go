@@ -0,0 +1,271 @@
+package rotation
+
+import (
+	"sort"
+	"time"
+
+	"github.com/MichiganDiningAPI/internal/util/date"
+	pb "github.com/anders617/mdining-proto/proto/mdining"
+)
+
+//
+// Detection of repeating menu cycles per dining hall
+//
+// For each lag the items served at a hall on a day are compared with the items
+// served lag days later using Jaccard similarity. The cycle length is the
+// shortest lag scoring close to the best score (multiples of the true cycle
+// score about as well), and confidence measures how far that score stands out
+// from the average over all lags. Days with nothing served are ignored.
+//
+
+// Options - Parameters of cycle detection
+type Options struct {
+	// Range of lags in days to consider
+	MinLag int
+	MaxLag int
+	// Minimum number of day pairs needed to score a lag
+	MinPairs int
+	// Number of days past the last published menu to project
+	ProjectionDays int
+}
+
+// DefaultOptions - Lags of up to ten weeks and two weeks of projections
+var DefaultOptions = Options{MinLag: 2, MaxLag: 70, MinPairs: 7, ProjectionDays: 14}
+
+// Lags scoring at least this fraction of the best score are candidates for the cycle length
+const nearBestFraction = 0.95
+
+// ProjectedMenu - Items likely to be served at a hall on a date past the published menus
+type ProjectedMenu struct {
+	Date string `json:"date"`
+	// Published date the projection was copied from
+	SourceDate string `json:"sourceDate"`
+	// Map from meal name to food names
+	Meals map[string][]string `json:"meals"`
+	// Always true, projections are never published menus
+	Predicted  bool    `json:"predicted"`
+	Confidence float64 `json:"confidence"`
+}
+
+// LagScore - Mean similarity of days lag days apart
+type LagScore struct {
+	Lag   int     `json:"lag"`
+	Score float64 `json:"score"`
+}
+
+// Rotation - The detected menu cycle of a dining hall
+type Rotation struct {
+	DiningHall string `json:"diningHall"`
+	// Detected cycle length in days
+	CycleDays int `json:"cycleDays"`
+	// Between 0 (no better than an arbitrary lag) and 1 (menus repeat exactly)
+	Confidence float64 `json:"confidence"`
+	// Mean similarity at the cycle length and over all lags
+	Similarity float64 `json:"similarity"`
+	Baseline   float64 `json:"baseline"`
+	// Mean similarity of every scored lag, shortest lag first
+	LagScores []LagScore `json:"lagScores"`
+	// Range of dates analyzed, the last being the last published menu
+	FirstDate string `json:"firstDate"`
+	LastDate  string `json:"lastDate"`
+	// Projected menus for the days after LastDate
+	Projections []*ProjectedMenu `json:"projections"`
+}
+
+// History - Items served per dining hall, date and meal
+type History struct {
+	// Map from dining hall to date to meal to food keys
+	served map[string]map[string]map[string]map[string]bool
+	// Map from food key to display name
+	names map[string]string
+}
+
+// NewHistory - Create an empty History
+func NewHistory() *History {
+	return &History{served: map[string]map[string]map[string]map[string]bool{}, names: map[string]string{}}
+}
+
+// AddFood - Records every dining hall meal the food was served at
+func (h *History) AddFood(food *pb.Food) {
+	h.names[food.Key] = food.Name
+	for hall, match := range food.DiningHallMatch {
+		if match.Campus != "" && match.Campus != "DINING HALLS" {
+			// Only dining halls have rotating menus
+			continue
+		}
+		dates, exists := h.served[hall]
+		if !exists {
+			dates = map[string]map[string]map[string]bool{}
+			h.served[hall] = dates
+		}
+		for d, mealTime := range match.MealTime {
+			meals, exists := dates[d]
+			if !exists {
+				meals = map[string]map[string]bool{}
+				dates[d] = meals
+			}
+			for _, meal := range mealTime.MealNames {
+				if _, exists := meals[meal]; !exists {
+					meals[meal] = map[string]bool{}
+				}
+				meals[meal][food.Key] = true
+			}
+		}
+	}
+}
+
+// Detect - Returns the rotation of every hall with enough history to score a lag
+func (h *History) Detect(opts Options) []*Rotation {
+	rotations := []*Rotation{}
+	for hall := range h.served {
+		if rotation := h.detectHall(hall, opts); rotation != nil {
+			rotations = append(rotations, rotation)
+		}
+	}
+	sort.Slice(rotations, func(i, j int) bool { return rotations[i].DiningHall < rotations[j].DiningHall })
+	return rotations
+}
+
+func (h *History) detectHall(hall string, opts Options) *Rotation {
+	dates := h.served[hall]
+	keys := make([]string, 0, len(dates))
+	for d := range dates {
+		keys = append(keys, d)
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		return nil
+	}
+	first, err := date.ParseNoTime(&keys[0])
+	if err != nil {
+		return nil
+	}
+	last, err := date.ParseNoTime(&keys[len(keys)-1])
+	if err != nil {
+		return nil
+	}
+	// Daily item sets indexed by days since first, nil when nothing was served
+	numDays := daysBetween(first, last) + 1
+	daily := make([]map[string]bool, numDays)
+	for d, meals := range dates {
+		t, err := date.ParseNoTime(&d)
+		if err != nil {
+			continue
+		}
+		items := map[string]bool{}
+		for _, foods := range meals {
+			for key := range foods {
+				items[key] = true
+			}
+		}
+		daily[daysBetween(first, t)] = items
+	}
+
+	scores := map[int]float64{}
+	for lag := opts.MinLag; lag <= opts.MaxLag && lag < numDays; lag++ {
+		total, pairs := 0.0, 0
+		for i := 0; i+lag < numDays; i++ {
+			if len(daily[i]) == 0 || len(daily[i+lag]) == 0 {
+				continue
+			}
+			total += jaccard(daily[i], daily[i+lag])
+			pairs++
+		}
+		if pairs >= opts.MinPairs {
+			scores[lag] = total / float64(pairs)
+		}
+	}
+	if len(scores) == 0 {
+		return nil
+	}
+	best, baseline := 0.0, 0.0
+	for _, score := range scores {
+		if score > best {
+			best = score
+		}
+		baseline += score
+	}
+	baseline /= float64(len(scores))
+	cycle := 0
+	for lag := opts.MinLag; lag <= opts.MaxLag; lag++ {
+		if score, exists := scores[lag]; exists && score >= best*nearBestFraction {
+			cycle = lag
+			break
+		}
+	}
+	confidence := 0.0
+	if baseline < 1 {
+		confidence = (scores[cycle] - baseline) / (1 - baseline)
+	}
+	if confidence < 0 {
+		confidence = 0
+	}
+
+	rotation := &Rotation{
+		DiningHall:  hall,
+		CycleDays:   cycle,
+		Confidence:  confidence,
+		Similarity:  scores[cycle],
+		Baseline:    baseline,
+		LagScores:   []LagScore{},
+		FirstDate:   keys[0],
+		LastDate:    keys[len(keys)-1],
+		Projections: []*ProjectedMenu{},
+	}
+	for lag := opts.MinLag; lag <= opts.MaxLag; lag++ {
+		if score, exists := scores[lag]; exists {
+			rotation.LagScores = append(rotation.LagScores, LagScore{Lag: lag, Score: score})
+		}
+	}
+	for i := 1; i <= opts.ProjectionDays; i++ {
+		target := last.AddDate(0, 0, i)
+		// Copy the most recent published day a whole number of cycles earlier
+		for source := target.AddDate(0, 0, -cycle); !source.Before(first); source = source.AddDate(0, 0, -cycle) {
+			meals, exists := dates[date.FormatNoTime(source)]
+			if !exists || len(daily[daysBetween(first, source)]) == 0 {
+				continue
+			}
+			rotation.Projections = append(rotation.Projections, &ProjectedMenu{
+				Date:       date.FormatNoTime(target),
+				SourceDate: date.FormatNoTime(source),
+				Meals:      h.mealNames(meals),
+				Predicted:  true,
+				Confidence: confidence,
+			})
+			break
+		}
+	}
+	return rotation
+}
+
+// Returns a map from meal to sorted display names
+func (h *History) mealNames(meals map[string]map[string]bool) map[string][]string {
+	result := map[string][]string{}
+	for meal, foods := range meals {
+		names := make([]string, 0, len(foods))
+		for key := range foods {
+			names = append(names, h.names[key])
+		}
+		sort.Strings(names)
+		result[meal] = names
+	}
+	return result
+}
+
+func daysBetween(from time.Time, to time.Time) int {
+	return int(to.Sub(from).Hours()+12) / 24
+}
+
+func jaccard(a map[string]bool, b map[string]bool) float64 {
+	intersection := 0
+	for key := range a {
+		if b[key] {
+			intersection++
+		}
+	}
+	union := len(a) + len(b) - intersection
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
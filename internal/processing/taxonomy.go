@@ -0,0 +1,208 @@
+package taxonomy
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+)
+
+//
+// Allergen and attribute taxonomy
+//
+// Both upstream clients report allergens and attributes as free-form strings
+// ("Tree Nuts", "treenuts", "Gluten-Free", "glutenfree" ...). The taxonomy maps
+// these raw values onto a canonical vocabulary of keys with display labels,
+// groupings (e.g. "tree nuts" and "peanuts" are both in the "nuts" group) and
+// implications (e.g. anything "vegan" is also "vegetarian"). Menus and foods
+// keep the raw upstream values; the taxonomy is applied when filtering and
+// computing stats so the stored data and the API responses built from it are
+// unchanged.
+//
+
+// None - Synthetic key used when an item lists no allergens or attributes
+const None = "none"
+
+// Term - A single canonical allergen or attribute
+type Term struct {
+	// Canonical key counted in stats and matched by filters
+	Key string
+	// Human readable label for display
+	Label string
+	// Key of the group this term belongs to (may be the term itself)
+	Group string
+	// Keys of other terms that always hold when this one does
+	Implies []string
+	// Raw upstream spellings which map to this term
+	Synonyms []string
+}
+
+// Taxonomy - A canonical vocabulary with a lookup table for raw values
+type Taxonomy struct {
+	terms  map[string]*Term
+	lookup map[string]string
+	// Raw values which did not match any term, logged once each
+	unknown map[string]bool
+	mu      sync.Mutex
+}
+
+// AllergenTerms - The canonical allergen vocabulary
+var AllergenTerms = []Term{
+	{Key: "milk", Label: "Milk", Group: "milk", Synonyms: []string{"dairy"}},
+	{Key: "eggs", Label: "Eggs", Group: "eggs", Synonyms: []string{"egg"}},
+	{Key: "fish", Label: "Fish", Group: "seafood"},
+	{Key: "shellfish", Label: "Shellfish", Group: "seafood", Synonyms: []string{"crustacean shellfish", "crustaceans"}},
+	{Key: "tree nuts", Label: "Tree Nuts", Group: "nuts", Synonyms: []string{"treenuts", "tree nut"}},
+	{Key: "peanuts", Label: "Peanuts", Group: "nuts", Synonyms: []string{"peanut"}},
+	{Key: "coconut", Label: "Coconut", Group: "nuts"},
+	{Key: "wheat", Label: "Wheat", Group: "gluten", Implies: []string{"gluten"}, Synonyms: []string{"wheat barley rye"}},
+	{Key: "gluten", Label: "Gluten", Group: "gluten"},
+	// Oats do not contain gluten, so filtering for gluten should not match them
+	{Key: "oats", Label: "Oats", Group: "oats", Synonyms: []string{"oat"}},
+	{Key: "soy", Label: "Soy", Group: "soy", Synonyms: []string{"soybeans", "soybean"}},
+	{Key: "sesame", Label: "Sesame", Group: "sesame", Synonyms: []string{"sesame seed", "sesame seeds"}},
+	{Key: "pork", Label: "Pork", Group: "meat"},
+	{Key: "beef", Label: "Beef", Group: "meat"},
+	{Key: "alcohol", Label: "Alcohol", Group: "alcohol"},
+}
+
+// AttributeTerms - The canonical attribute vocabulary
+var AttributeTerms = []Term{
+	{Key: "vegan", Label: "Vegan", Group: "diet", Implies: []string{"vegetarian"}},
+	{Key: "vegetarian", Label: "Vegetarian", Group: "diet"},
+	{Key: "gluten free", Label: "Gluten Free", Group: "diet", Synonyms: []string{"glutenfree", "gf"}},
+	{Key: "halal", Label: "Halal", Group: "religious"},
+	{Key: "kosher", Label: "Kosher", Group: "religious"},
+	{Key: "mhealthy", Label: "MHealthy", Group: "health", Synonyms: []string{"m healthy"}},
+	{Key: "spicy", Label: "Spicy", Group: "flavor"},
+	{Key: "sustainable", Label: "Sustainable", Group: "sourcing", Synonyms: []string{"sustainability"}},
+	{Key: "local", Label: "Local", Group: "sourcing", Synonyms: []string{"locally sourced"}},
+	{Key: "carbon footprint low", Label: "Low Carbon Footprint", Group: "carbon footprint", Synonyms: []string{"carbonfootprintlow", "low carbon footprint"}},
+	{Key: "carbon footprint medium", Label: "Medium Carbon Footprint", Group: "carbon footprint", Synonyms: []string{"carbonfootprintmedium", "medium carbon footprint"}},
+	{Key: "carbon footprint high", Label: "High Carbon Footprint", Group: "carbon footprint", Synonyms: []string{"carbonfootprinthigh", "high carbon footprint"}},
+}
+
+var (
+	// Allergens - Taxonomy for allergen values
+	Allergens = New(AllergenTerms)
+	// Attributes - Taxonomy for attribute values
+	Attributes = New(AttributeTerms)
+)
+
+// New - Create a taxonomy from the given terms
+func New(terms []Term) *Taxonomy {
+	t := &Taxonomy{terms: map[string]*Term{}, lookup: map[string]string{}, unknown: map[string]bool{}}
+	for idx := range terms {
+		term := &terms[idx]
+		t.terms[term.Key] = term
+		t.lookup[normalize(term.Key)] = term.Key
+		t.lookup[normalize(term.Label)] = term.Key
+		for _, synonym := range term.Synonyms {
+			t.lookup[normalize(synonym)] = term.Key
+		}
+	}
+	return t
+}
+
+// Canonical - Maps a raw upstream value to its canonical key.
+// Unknown values are kept (normalized) so new upstream values are not lost.
+func (t *Taxonomy) Canonical(raw string) string {
+	n := normalize(raw)
+	if key, exists := t.lookup[n]; exists {
+		return key
+	}
+	if n != "" && n != None {
+		t.mu.Lock()
+		if !t.unknown[n] {
+			t.unknown[n] = true
+			glog.Warningf("Unknown taxonomy value %q", raw)
+		}
+		t.mu.Unlock()
+	}
+	return n
+}
+
+// CanonicalList - Maps raw values to canonical keys, dropping empties and duplicates
+func (t *Taxonomy) CanonicalList(raw []string) []string {
+	keys := make([]string, 0, len(raw))
+	seen := map[string]bool{}
+	for _, r := range raw {
+		key := t.Canonical(r)
+		if key == "" || key == None || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Expand - Returns the given canonical keys along with every key they imply
+func (t *Taxonomy) Expand(keys []string) []string {
+	expanded := make([]string, 0, len(keys))
+	seen := map[string]bool{}
+	var add func(key string)
+	add = func(key string) {
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		expanded = append(expanded, key)
+		if term, exists := t.terms[key]; exists {
+			for _, implied := range term.Implies {
+				add(implied)
+			}
+		}
+	}
+	for _, key := range keys {
+		add(key)
+	}
+	return expanded
+}
+
+// Matches - Whether the raw values contain want, directly, through implication or through its group
+func (t *Taxonomy) Matches(raw []string, want string) bool {
+	wantKey := normalize(want)
+	if key, exists := t.lookup[wantKey]; exists {
+		wantKey = key
+	}
+	for _, key := range t.Expand(t.CanonicalList(raw)) {
+		if key == wantKey || t.Group(key) == wantKey {
+			return true
+		}
+	}
+	return false
+}
+
+// Label - Display label for a canonical key
+func (t *Taxonomy) Label(key string) string {
+	if term, exists := t.terms[key]; exists {
+		return term.Label
+	}
+	if key == None {
+		return "None"
+	}
+	// Capitalize each word of unknown keys, which are already normalized to single spaced words
+	words := strings.Fields(key)
+	for idx, word := range words {
+		r, size := utf8.DecodeRuneInString(word)
+		words[idx] = string(unicode.ToUpper(r)) + word[size:]
+	}
+	return strings.Join(words, " ")
+}
+
+// Group - Group key for a canonical key (the key itself if it is not in a group)
+func (t *Taxonomy) Group(key string) string {
+	if term, exists := t.terms[key]; exists && term.Group != "" {
+		return term.Group
+	}
+	return key
+}
+
+func normalize(s string) string {
+	s = strings.ToLower(s)
+	s = strings.NewReplacer("-", " ", "_", " ", "/", " ", ",", " ").Replace(s)
+	return strings.Join(strings.Fields(s), " ")
+}
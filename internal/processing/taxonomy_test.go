@@ -0,0 +1,101 @@
+package taxonomy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"key", "peanuts", "peanuts"},
+		{"label", "Tree Nuts", "tree nuts"},
+		{"synonym", "treenuts", "tree nuts"},
+		{"case and punctuation ignored", " Crustacean-Shellfish ", "shellfish"},
+		{"unknown kept normalized", "Mustard_Seed", "mustard seed"},
+		{"empty", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Allergens.Canonical(test.raw); got != test.want {
+				t.Errorf("Canonical(%q) = %q, want %q", test.raw, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalList(t *testing.T) {
+	got := Attributes.CanonicalList([]string{"Gluten-Free", "glutenfree", "", "none", "Vegan"})
+	if want := []string{"gluten free", "vegan"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CanonicalList = %q, want %q", got, want)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name     string
+		taxonomy *Taxonomy
+		keys     []string
+		want     []string
+	}{
+		{"implied attribute", Attributes, []string{"vegan"}, []string{"vegan", "vegetarian"}},
+		{"implied allergen", Allergens, []string{"wheat", "milk"}, []string{"wheat", "gluten", "milk"}},
+		{"implied key already present", Allergens, []string{"gluten", "wheat"}, []string{"gluten", "wheat"}},
+		{"oats imply nothing", Allergens, []string{"oats"}, []string{"oats"}},
+		{"unknown keys kept", Allergens, []string{"mustard"}, []string{"mustard"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.taxonomy.Expand(test.keys); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Expand(%q) = %q, want %q", test.keys, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		taxonomy *Taxonomy
+		raw      []string
+		want     string
+		matches  bool
+	}{
+		{"direct", Allergens, []string{"Peanut"}, "peanuts", true},
+		{"group", Allergens, []string{"Tree Nuts"}, "nuts", true},
+		{"wanted by label", Allergens, []string{"treenuts"}, "Tree Nuts", true},
+		{"implication", Attributes, []string{"Vegan"}, "vegetarian", true},
+		{"implication is one way", Attributes, []string{"Vegetarian"}, "vegan", false},
+		{"gluten through wheat", Allergens, []string{"Wheat Barley Rye"}, "gluten", true},
+		{"oats are not gluten", Allergens, []string{"Oats"}, "gluten", false},
+		{"different group", Allergens, []string{"soy"}, "nuts", false},
+		{"no values", Allergens, []string{}, "milk", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.taxonomy.Matches(test.raw, test.want); got != test.matches {
+				t.Errorf("Matches(%q, %q) = %t, want %t", test.raw, test.want, got, test.matches)
+			}
+		})
+	}
+}
+
+func TestLabel(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"carbon footprint low", "Low Carbon Footprint"},
+		{None, "None"},
+		{"mustard seed", "Mustard Seed"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := Attributes.Label(test.key); got != test.want {
+			t.Errorf("Label(%q) = %q, want %q", test.key, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/MichiganDiningAPI/db/dynamoclient"
+	"github.com/MichiganDiningAPI/internal/util/date"
+	"github.com/golang/glog"
+)
+
+//
+// In memory view of the issued API keys along with their daily usage
+//
+// Keys are looked up by the hash of the key so the keys themselves are never
+// stored. Requests are counted in memory and added to the APIKeyUsage table
+// every syncInterval. The totals returned by each addition include requests
+// made through other servers, so a key's count for the day is its last synced
+// total plus the requests made since. Keys are reloaded every syncInterval so
+// revoked keys stop working within a minute.
+//
+
+const syncInterval = time.Minute
+
+// Bytes of randomness in an API key
+const keyBytes = 24
+
+// Generate - Returns a new random API key
+func Generate() (string, error) {
+	b := make([]byte, keyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Hash - Returns the hex encoded SHA-256 hash of an API key
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Usage of a key on a date
+type usageKey struct {
+	id   string
+	date string
+}
+
+type usage struct {
+	// Total of every server as of the last sync
+	synced int64
+	// Requests made through this server since the last sync
+	pending int64
+}
+
+// Store - API keys and usage backed by DynamoDB
+type Store struct {
+	dc *dynamoclient.DynamoClient
+	// Map from key hash to unrevoked key
+	keys  map[string]*dynamoclient.APIKey
+	usage map[usageKey]*usage
+	// Closed by Close to stop syncing
+	stop chan struct{}
+	// Closed once syncing has stopped
+	stopped chan struct{}
+	mu      sync.Mutex
+}
+
+// New - Loads the API keys and starts syncing usage
+func New(dc *dynamoclient.DynamoClient) *Store {
+	s := &Store{
+		dc:      dc,
+		keys:    map[string]*dynamoclient.APIKey{},
+		usage:   map[usageKey]*usage{},
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	s.loadKeys()
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.loadKeys()
+				s.sync()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Close - Stops syncing and saves the usage counted since the last sync. Must be called at most once.
+func (s *Store) Close() {
+	close(s.stop)
+	// A sync in progress must finish first so the final sync does not race it
+	<-s.stopped
+	s.sync()
+}
+
+func (s *Store) loadKeys() {
+	apiKeys, err := s.dc.QueryAPIKeys()
+	if err != nil {
+		// Keep the previously loaded keys
+		glog.Errorf("Error loading API keys: %s", err)
+		return
+	}
+	keys := map[string]*dynamoclient.APIKey{}
+	for _, key := range apiKeys {
+		if key.RevokedAt == "" {
+			keys[key.KeyHash] = key
+		}
+	}
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	glog.Infof("Loaded %d API keys", len(keys))
+}
+
+// Adds the pending requests of every key to the usage table
+func (s *Store) sync() {
+	today := date.FormatNoTime(date.Now())
+	pending := map[usageKey]int64{}
+	s.mu.Lock()
+	for k, u := range s.usage {
+		if u.pending > 0 {
+			pending[k] = u.pending
+			u.pending = 0
+		} else if k.date != today {
+			// Past days are no longer counted once synced
+			delete(s.usage, k)
+		}
+	}
+	s.mu.Unlock()
+	for k, requests := range pending {
+		total, err := s.dc.AddAPIKeyUsage(k.id, k.date, requests)
+		s.mu.Lock()
+		u, exists := s.usage[k]
+		if !exists {
+			u = &usage{}
+			s.usage[k] = u
+		}
+		if err != nil {
+			// Retry with the next sync
+			u.pending += requests
+		} else if total > u.synced {
+			u.synced = total
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Lookup - Returns the id and tier of an API key, false if the key was not issued or has been revoked
+func (s *Store) Lookup(key string) (string, string, bool) {
+	s.mu.Lock()
+	apiKey, exists := s.keys[Hash(key)]
+	s.mu.Unlock()
+	if !exists {
+		return "", "", false
+	}
+	return apiKey.ID, apiKey.Tier, true
+}
+
+// Use - Counts a request made with the API key with id, returning the key's requests today
+func (s *Store) Use(id string) int64 {
+	k := usageKey{id: id, date: date.FormatNoTime(date.Now())}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, exists := s.usage[k]
+	if !exists {
+		u = &usage{}
+		s.usage[k] = u
+	}
+	u.pending++
+	return u.synced + u.pending
+}
@@ -2,30 +2,143 @@ package mdiningserver
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/MichiganDiningAPI/db/dynamoclient"
+	"github.com/MichiganDiningAPI/internal/processing/autocomplete"
+	"github.com/MichiganDiningAPI/internal/processing/entryfilter"
+	"github.com/MichiganDiningAPI/internal/processing/foodnames"
+	"github.com/MichiganDiningAPI/internal/processing/foodsearch"
+	"github.com/MichiganDiningAPI/internal/processing/foodtrends"
+	"github.com/MichiganDiningAPI/internal/processing/hallsimilarity"
+	"github.com/MichiganDiningAPI/internal/processing/hallstats"
 	"github.com/MichiganDiningAPI/internal/processing/mdiningprocessing"
+	"github.com/MichiganDiningAPI/internal/processing/nextserving"
+	"github.com/MichiganDiningAPI/internal/processing/nutrition"
+	"github.com/MichiganDiningAPI/internal/processing/rollups"
+	"github.com/MichiganDiningAPI/internal/processing/rotation"
 	"github.com/MichiganDiningAPI/internal/util/date"
+	extpb "github.com/MichiganDiningAPI/proto/mdiningextensions"
 	pb "github.com/anders617/mdining-proto/proto/mdining"
 	"github.com/golang/glog"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+var (
+	reloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "data_reload_duration_seconds",
+		Help:    "Time taken to reload each dataset, and all datasets together.",
+		Buckets: []float64{.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"dataset"})
+	datasetSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dataset_size",
+		Help: "Number of entries in each dataset as of the last reload.",
+	}, []string{"dataset"})
+	lastReload = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "data_last_reload_timestamp_seconds",
+		Help: "Unix time at which the last reload of all datasets completed.",
+	})
+	reloadFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "data_reload_failures_total",
+		Help: "Loads of each dataset which failed after retrying, leaving the previous version in use.",
+	}, []string{"dataset"})
+	openHeartStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "heart_streams_open",
+		Help: "Number of open StreamHearts calls.",
+	})
+)
+
+// Datasets loaded by each reload
+const (
+	DiningHallsDataset = "diningHalls"
+	ItemsDataset       = "items"
+	FoodStatsDataset   = "foodStats"
+	// The search and autocomplete indexes, built from the other datasets
+	IndexesDataset = "indexes"
+)
+
+var datasets = []string{DiningHallsDataset, ItemsDataset, FoodStatsDataset, IndexesDataset}
+
+const (
+	// Attempts made at each query of a reload, waiting retryBackoff after the first failure and doubling the
+	// wait after each further failure
+	reloadAttempts = 4
+	retryBackoff   = 2 * time.Second
+	// Wait before reloading again after a failed reload, doubling with each consecutive failure
+	failedReloadDelay    = 5 * time.Minute
+	maxFailedReloadDelay = 2 * time.Hour
+	// Data is reloaded daily, so data older than this means reloads have been failing
+	maxDataAge = 26 * time.Hour
+)
+
+// Load state of a dataset
+type datasetState struct {
+	// Zero until the dataset has loaded
+	loadedAt    time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// Whether the data is older than expected, either because the last load failed or it is older than maxDataAge
+func (d *datasetState) stale(now time.Time) bool {
+	if d.loadedAt.IsZero() {
+		return false
+	}
+	return d.lastErrorAt.After(d.loadedAt) || now.Sub(d.loadedAt) > maxDataAge
+}
+
+// DatasetStatus - Load state of a dataset
+type DatasetStatus struct {
+	Name   string `json:"name"`
+	Loaded bool   `json:"loaded"`
+	// RFC 3339 time of the last successful load
+	LoadedAt   string  `json:"loadedAt,omitempty"`
+	AgeSeconds float64 `json:"ageSeconds,omitempty"`
+	// Whether the dataset is older than expected, in which case the last good version is being served
+	Stale bool `json:"stale"`
+	// Last load error, along with its RFC 3339 time
+	LastError   string `json:"lastError,omitempty"`
+	LastErrorAt string `json:"lastErrorAt,omitempty"`
+}
+
 type Server struct {
 	dc                *dynamoclient.DynamoClient
 	diningHalls       *pb.DiningHalls
 	items             *pb.Items
 	filterableEntries *pb.FilterableEntries
+	allergens         map[string][]string
 	foodStats         *[]*pb.FoodStat
 	summaryStats      *pb.SummaryStats
+	foodTrends        map[int][]*foodtrends.Trend
+	// Trend windows in weeks computed by analyze
+	trendWindows      []int
+	searchIndex       *foodsearch.Index
+	autocompleteIndex *autocomplete.Index
 	lastFetch         time.Time
-	heartStreams      map[string]*heartStreamRequest
-	mu                sync.RWMutex
+	datasets          map[string]*datasetState
+	// Data generation as of the last successful reload
+	generation int64
+	// Data generation read by the last reload, whether or not it succeeded
+	attemptedGeneration int64
+	// Consecutive failed reloads and when to try again after them
+	failedReloads int
+	retryReloadAt time.Time
+	heartStreams  map[string]*heartStreamRequest
+	// Held while reloading so reloads never overlap
+	reloadMu sync.Mutex
+	// Closed by Shutdown
+	shutdown chan struct{}
+	mu       sync.RWMutex
 }
 
 func New() *Server {
@@ -34,7 +147,14 @@ func New() *Server {
 	s.items = nil
 	s.filterableEntries = nil
 	s.foodStats = nil
+	s.foodTrends = map[int][]*foodtrends.Trend{}
+	s.trendWindows, _ = foodtrends.ParseWindows(foodtrends.DefaultWindows)
+	s.datasets = map[string]*datasetState{}
+	for _, name := range datasets {
+		s.datasets[name] = &datasetState{}
+	}
 	s.heartStreams = make(map[string]*heartStreamRequest)
+	s.shutdown = make(chan struct{})
 	s.fetchData()
 	s.listenForHearts()
 	return &s
@@ -46,11 +166,14 @@ func (s *Server) listenForHearts() {
 		for heartCount := range heartsChan {
 			glog.Infof("Publishing heart count: %v", heartCount)
 			s.mu.RLock()
+			if s.autocompleteIndex != nil {
+				s.autocompleteIndex.SetHearts(heartCount.Key, heartCount.Count)
+			}
 			glog.Infof("There are currently %d heart streams open", len(s.heartStreams))
 			for id, streamReq := range s.heartStreams {
 				foundKey := false
 				for _, key := range streamReq.request.Keys {
-					if key == heartCount.Key {
+					if key == foodnames.Key(heartCount.Key) {
 						foundKey = true
 						break
 					}
@@ -59,7 +182,11 @@ func (s *Server) listenForHearts() {
 					glog.Infof("Sending to stream %s", id)
 					if err := streamReq.stream.Send(&pb.HeartsReply{Counts: []*pb.HeartCount{&heartCount}}); err != nil {
 						glog.Errorf("Error sending to stream %s: %s", id, err)
-						streamReq.done <- struct{}{}
+						// The stream may already be closing
+						select {
+						case streamReq.done <- struct{}{}:
+						default:
+						}
 					}
 					glog.Infof("Sent to stream %s", id)
 				}
@@ -72,76 +199,395 @@ func (s *Server) listenForHearts() {
 func (s *Server) fetchData() {
 	go func() {
 		for {
-			s.lastFetch = date.Now()
-			wg := &sync.WaitGroup{}
-			wg.Add(3)
-			go s.fetchDiningHalls(wg)
-			go s.fetchItemsAndFilterableEntries(wg)
-			go s.fetchFoodStats(wg)
-			wg.Wait()
 			timeToNextFetch := date.NextFetchTime().Sub(date.Now())
 			// Reload 30 mins after time that fetch is scheduler for to give it some time
 			timeToNextFetch = timeToNextFetch + time.Minute*30
+			if err := s.reload(); err != nil {
+				// Keep serving the last good data and try again sooner than the next scheduled reload
+				s.mu.RLock()
+				failures, retryIn := s.failedReloads, time.Until(s.retryReloadAt)
+				s.mu.RUnlock()
+				if retryIn < timeToNextFetch {
+					timeToNextFetch = retryIn
+				}
+				glog.Errorf("Reload failed %d times in a row, last error: %s", failures, err)
+			}
 			glog.Infof("Scheduling fetch in %v", timeToNextFetch)
 			<-time.After(timeToNextFetch)
 		}
 	}()
 }
 
-func (s *Server) fetchDiningHalls(wg *sync.WaitGroup) {
-	defer wg.Done()
-	tmp, err := s.dc.QueryDiningHalls()
+// Wait before reloading again after failures consecutive failed reloads
+func failedReloadBackoff(failures int) time.Duration {
+	retryIn := failedReloadDelay << uint(failures-1)
+	if retryIn > maxFailedReloadDelay || retryIn <= 0 {
+		retryIn = maxFailedReloadDelay
+	}
+	return retryIn
+}
+
+// Datasets loaded by a reload, nil where loading failed
+type snapshot struct {
+	diningHalls       *pb.DiningHalls
+	items             *pb.Items
+	filterableEntries *pb.FilterableEntries
+	allergens         map[string][]string
+	foodStats         *[]*pb.FoodStat
+	summaryStats      *pb.SummaryStats
+	searchIndex       *foodsearch.Index
+	autocompleteIndex *autocomplete.Index
+}
+
+// Reloads every dataset, keeping the previous version of any dataset which fails to load. Returns the first
+// error encountered.
+func (s *Server) reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	return s.reloadLocked()
+}
+
+// Reloads every dataset. Must be called with s.reloadMu held.
+func (s *Server) reloadLocked() error {
+	s.lastFetch = date.Now()
+	start := time.Now()
+	// Every query of the reload is traced as a child of this span
+	ctx, span := global.Tracer("github.com/MichiganDiningAPI/internal/web/mdiningserver").Start(context.Background(), "reload",
+		trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	// Read before the datasets so changes made while they load trigger another reload
+	generation, err := s.dc.WithContext(ctx).GetDataGeneration()
 	if err != nil {
-		glog.Fatalf("QueryDiningHalls err %s", err)
+		glog.Errorf("GetDataGeneration err %s", err)
 	}
+	snap := &snapshot{}
+	// Each fetch sets its own fields of snap
+	fetches := []func(context.Context, *snapshot) error{s.fetchDiningHalls, s.fetchItemsAndFilterableEntries, s.fetchFoodStats}
+	errs := make([]error, len(fetches))
+	wg := &sync.WaitGroup{}
+	for i, fetch := range fetches {
+		wg.Add(1)
+		go func(i int, fetch func(context.Context, *snapshot) error) {
+			defer wg.Done()
+			errs[i] = fetch(ctx, snap)
+		}(i, fetch)
+	}
+	wg.Wait()
+	errs = append(errs, s.buildIndexes(ctx, snap))
+
+	// Swap in every loaded dataset at once so requests never see a mix of old and new data
 	s.mu.Lock()
-	s.diningHalls = tmp
+	if snap.diningHalls != nil {
+		s.diningHalls = snap.diningHalls
+		s.loaded(DiningHallsDataset)
+	}
+	if snap.items != nil {
+		s.items = snap.items
+		s.filterableEntries = snap.filterableEntries
+		s.allergens = snap.allergens
+		s.loaded(ItemsDataset)
+	}
+	if snap.foodStats != nil {
+		s.foodStats = snap.foodStats
+		s.summaryStats = snap.summaryStats
+		s.loaded(FoodStatsDataset)
+	}
+	if snap.searchIndex != nil {
+		s.foodTrends = map[int][]*foodtrends.Trend{}
+		s.searchIndex = snap.searchIndex
+		s.autocompleteIndex = snap.autocompleteIndex
+		s.loaded(IndexesDataset)
+	}
+	if generation != nil {
+		s.attemptedGeneration = generation.Generation
+	}
+	for _, err := range errs {
+		if err != nil {
+			s.failedReloads++
+			s.retryReloadAt = time.Now().Add(failedReloadBackoff(s.failedReloads))
+			s.mu.Unlock()
+			span.RecordError(ctx, err, trace.WithErrorStatus(codes.Unknown))
+			return err
+		}
+	}
+	s.failedReloads = 0
+	if generation != nil {
+		s.generation = generation.Generation
+	}
 	s.mu.Unlock()
+	reloadDuration.WithLabelValues("all").Observe(time.Since(start).Seconds())
+	lastReload.Set(float64(time.Now().Unix()))
+	return nil
+}
+
+// WatchGeneration - Checks the data generation every interval, reloading when fetch or analyze have changed
+// the data since the last successful reload. Reloads of a generation which failed to load are retried with
+// the same backoff as scheduled reloads.
+func (s *Server) WatchGeneration(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.shutdown:
+				return
+			}
+			generation, err := s.dc.GetDataGeneration()
+			if err != nil {
+				glog.Errorf("GetDataGeneration err %s", err)
+				continue
+			}
+			s.reloadMu.Lock()
+			s.mu.RLock()
+			due := reloadDue(generation.Generation, s.generation, s.attemptedGeneration, s.retryReloadAt, time.Now())
+			s.mu.RUnlock()
+			if due {
+				glog.Infof("Data generation %d written by %s at %s, reloading", generation.Generation, generation.UpdatedBy, generation.UpdatedAt)
+				if err := s.reloadLocked(); err != nil {
+					glog.Errorf("Reload of generation %d failed: %s", generation.Generation, err)
+				}
+			}
+			s.reloadMu.Unlock()
+		}
+	}()
+}
+
+// Whether the server, which has loaded generation loaded and last attempted generation attempted, should reload
+// the latest generation at now
+func reloadDue(latest int64, loaded int64, attempted int64, retryAt time.Time, now time.Time) bool {
+	behind := latest > loaded
+	// A generation whose reload failed is only retried once the failed reload backoff has passed
+	due := latest > attempted || !now.Before(retryAt)
+	return behind && due
+}
+
+// Calls query until it succeeds, making up to reloadAttempts attempts with exponential backoff
+func retry(dataset string, query func() error) error {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := query()
+		if err == nil || attempt == reloadAttempts {
+			return err
+		}
+		glog.Warningf("Loading %s failed (attempt %d/%d), retrying in %v: %s", dataset, attempt, reloadAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Records a successful load of dataset. Must be called with s.mu held.
+func (s *Server) loaded(dataset string) {
+	s.datasets[dataset].loadedAt = time.Now()
+}
+
+// Records a failed load of dataset, whose previous version is kept
+func (s *Server) loadFailed(dataset string, err error) {
+	reloadFailures.WithLabelValues(dataset).Inc()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.datasets[dataset]
+	state.lastError = err.Error()
+	state.lastErrorAt = time.Now()
+}
+
+// Builds the search and autocomplete indexes from the datasets of snap, falling back to the current version of
+// any dataset which failed to load
+func (s *Server) buildIndexes(ctx context.Context, snap *snapshot) error {
+	start := time.Now()
+	hearts, err := s.dc.WithContext(ctx).QueryAllHearts()
+	if err != nil {
+		// Foods are still indexed, just without their hearts
+		glog.Errorf("QueryAllHearts err %s", err)
+	}
+	s.mu.RLock()
+	foodStats, items, diningHalls := snap.foodStats, snap.items, snap.diningHalls
+	if foodStats == nil {
+		foodStats = s.foodStats
+	}
+	if items == nil {
+		items = s.items
+	}
+	if diningHalls == nil {
+		diningHalls = s.diningHalls
+	}
+	s.mu.RUnlock()
+	if foodStats == nil {
+		// Food stats have never loaded, so keep the previous indexes (if any) until they do
+		err := errors.New("Food stats are not loaded")
+		s.loadFailed(IndexesDataset, err)
+		return err
+	}
+	snap.searchIndex = foodsearch.Build(*foodStats, items, date.FormatNoTime(date.Now()))
+	snap.autocompleteIndex = autocomplete.Build(*foodStats, items, diningHalls, hearts, date.Now(), autocomplete.DefaultOptions)
+	glog.Infof("Indexed %d foods for search and %d names for autocomplete", snap.searchIndex.Len(), snap.autocompleteIndex.Len())
+	datasetSize.WithLabelValues("searchIndex").Set(float64(snap.searchIndex.Len()))
+	datasetSize.WithLabelValues("autocompleteIndex").Set(float64(snap.autocompleteIndex.Len()))
+	reloadDuration.WithLabelValues(IndexesDataset).Observe(time.Since(start).Seconds())
+	return nil
+}
+
+func (s *Server) fetchDiningHalls(ctx context.Context, snap *snapshot) error {
+	start := time.Now()
+	var tmp *pb.DiningHalls
+	err := retry(DiningHallsDataset, func() (err error) {
+		tmp, err = s.dc.WithContext(ctx).QueryDiningHalls()
+		return err
+	})
+	if err != nil {
+		glog.Errorf("QueryDiningHalls err %s", err)
+		s.loadFailed(DiningHallsDataset, err)
+		return err
+	}
+	snap.diningHalls = tmp
+	reloadDuration.WithLabelValues("diningHalls").Observe(time.Since(start).Seconds())
+	datasetSize.WithLabelValues("diningHalls").Set(float64(len(tmp.DiningHalls)))
 	glog.Infof("QueryDiningHalls Success")
+	return nil
 }
 
-func (s *Server) fetchItemsAndFilterableEntries(wg *sync.WaitGroup) {
-	defer wg.Done()
-	var err error
+func (s *Server) fetchItemsAndFilterableEntries(ctx context.Context, snap *snapshot) error {
+	start := time.Now()
 	var foods *[]*pb.Food
 	// Get all foods after today
 	startDate := date.FormatNoTime(date.Now())
-	foods, err = s.dc.QueryFoodsDateRange(nil, &startDate, nil)
+	err := retry(ItemsDataset, func() (err error) {
+		foods, err = s.dc.WithContext(ctx).QueryFoodsDateRange(nil, &startDate, nil)
+		return err
+	})
 	if err != nil {
-		glog.Fatalf("QueryFoodsDateRange err %s", err)
+		glog.Errorf("QueryFoodsDateRange err %s", err)
+		s.loadFailed(ItemsDataset, err)
+		return err
 	}
 	glog.Infof("QueryFoodsDateRange Success")
-	tmpItems := mdiningprocessing.FoodsToItems(foods)
-	s.mu.Lock()
-	s.items = tmpItems
-	s.mu.Unlock()
-	tmpFE := mdiningprocessing.ItemsToFilterableEntries(s.items)
-	s.mu.Lock()
-	s.filterableEntries = tmpFE
-	s.mu.Unlock()
+	snap.items = mdiningprocessing.FoodsToItems(foods)
+	snap.filterableEntries = mdiningprocessing.ItemsToFilterableEntries(snap.items)
+	snap.allergens = entryfilter.FoodAllergens(*foods)
+	reloadDuration.WithLabelValues("items").Observe(time.Since(start).Seconds())
+	datasetSize.WithLabelValues("items").Set(float64(len(snap.items.Items)))
+	datasetSize.WithLabelValues("filterableEntries").Set(float64(len(snap.filterableEntries.FilterableEntries)))
+	return nil
 }
 
-func (s *Server) fetchFoodStats(wg *sync.WaitGroup) {
-	defer wg.Done()
-	tmp, err := s.dc.QueryFoodStats()
+func (s *Server) fetchFoodStats(ctx context.Context, snap *snapshot) error {
+	start := time.Now()
+	var tmp *[]*pb.FoodStat
+	err := retry(FoodStatsDataset, func() (err error) {
+		tmp, err = s.dc.WithContext(ctx).QueryFoodStats()
+		return err
+	})
 	if err != nil {
-		glog.Fatalf("QueryFoodStats err %s", err)
+		glog.Errorf("QueryFoodStats err %s", err)
+		s.loadFailed(FoodStatsDataset, err)
+		return err
 	}
-	s.mu.Lock()
-	s.foodStats = tmp
-	s.summaryStats = mdiningprocessing.FoodStatsToSummaryStats(tmp)
-	s.mu.Unlock()
+	snap.foodStats = tmp
+	snap.summaryStats = mdiningprocessing.FoodStatsToSummaryStats(tmp)
+	reloadDuration.WithLabelValues("foodStats").Observe(time.Since(start).Seconds())
+	datasetSize.WithLabelValues("foodStats").Set(float64(len(*tmp)))
 	glog.Infof("QueryFoodStats Success")
+	return nil
 }
 
 // Used for healthcheck to see if data is available.
 func (s *Server) IsAvailable() bool {
+	ready, _ := s.Readiness()
+	return ready
+}
+
+// Readiness - Returns whether the server is ready to serve requests, which is once every dataset has been
+// loaded and until Shutdown is called, along with the status of each dataset
+func (s *Server) Readiness() (bool, []*DatasetStatus) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	if s.diningHalls == nil {
+	ready := !s.isShuttingDown()
+	now := time.Now()
+	statuses := make([]*DatasetStatus, 0, len(datasets))
+	for _, name := range datasets {
+		state := s.datasets[name]
+		dataset := &DatasetStatus{Name: name, Stale: state.stale(now), LastError: state.lastError}
+		if !state.loadedAt.IsZero() {
+			dataset.Loaded = true
+			dataset.LoadedAt = state.loadedAt.Format(time.RFC3339)
+			dataset.AgeSeconds = now.Sub(state.loadedAt).Seconds()
+		} else {
+			ready = false
+		}
+		if !state.lastErrorAt.IsZero() {
+			dataset.LastErrorAt = state.lastErrorAt.Format(time.RFC3339)
+		}
+		statuses = append(statuses, dataset)
+	}
+	return ready, statuses
+}
+
+// Staleness - Returns whether any loaded dataset is older than expected, along with the age of the oldest
+// loaded dataset
+func (s *Server) Staleness() (bool, time.Duration) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	stale := false
+	age := time.Duration(0)
+	for _, state := range s.datasets {
+		if state.loadedAt.IsZero() {
+			continue
+		}
+		stale = stale || state.stale(now)
+		if now.Sub(state.loadedAt) > age {
+			age = now.Sub(state.loadedAt)
+		}
+	}
+	return stale, age
+}
+
+func (s *Server) isShuttingDown() bool {
+	select {
+	case <-s.shutdown:
+		return true
+	default:
 		return false
 	}
-	return true
+}
+
+// Shutdown - Marks the server as not ready and ends every heart stream, so grpc servers can stop gracefully.
+// New heart streams are refused from then on.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isShuttingDown() {
+		return
+	}
+	close(s.shutdown)
+	glog.Infof("Closing %d heart streams for shutdown", len(s.heartStreams))
+}
+
+// Reload - Reloads every dataset, waiting for any reload in progress to finish first. Only API keys of the
+// admin tier may call it, which the grpc servers check before calling it.
+func (s *Server) Reload(ctx context.Context, req *extpb.ReloadRequest) (*extpb.ReloadReply, error) {
+	glog.Infof("Reload req{%v}", req)
+	if err := s.reload(); err != nil {
+		glog.Errorf("Reload Error %s", err)
+		return nil, status.Errorf(codes.Unavailable, "Reload failed, the previous data is still being served: %s", err)
+	}
+	_, datasets := s.Readiness()
+	s.mu.RLock()
+	reply := &extpb.ReloadReply{Generation: s.generation}
+	s.mu.RUnlock()
+	for _, dataset := range datasets {
+		reply.Datasets = append(reply.Datasets, &extpb.DatasetStatus{
+			Name:        dataset.Name,
+			Loaded:      dataset.Loaded,
+			LoadedAt:    dataset.LoadedAt,
+			AgeSeconds:  dataset.AgeSeconds,
+			Stale:       dataset.Stale,
+			LastError:   dataset.LastError,
+			LastErrorAt: dataset.LastErrorAt,
+		})
+	}
+	glog.Infof("Reload res{generation %d}", reply.Generation)
+	return reply, nil
 }
 
 // Handler for GetDiningHalls request
@@ -177,6 +623,122 @@ func (s *Server) GetFilterableEntries(ctx context.Context, req *pb.FilterableEnt
 	return &pb.FilterableEntriesReply{FilterableEntries: s.filterableEntries.FilterableEntries}, nil
 }
 
+// Splits list values which may each be comma separated, as the gateway passes repeated query parameters as
+// given
+func splitList(values []string) []string {
+	list := []string{}
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
+
+func (s *Server) FilterEntries(ctx context.Context, req *extpb.FilterEntriesRequest) (*extpb.FilterEntriesReply, error) {
+	glog.Infof("FilterEntries req{%v}", req)
+	if !entryfilter.ValidSort(req.Sort) {
+		return nil, status.Errorf(codes.InvalidArgument, "sort must be one of %v", entryfilter.SortOrders)
+	}
+	if req.Offset < 0 || req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset and limit must not be negative")
+	}
+	if req.StartDate != "" {
+		if _, err := date.ParseNoTime(&req.StartDate); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "startDate must be formatted yyyy-MM-dd")
+		}
+	}
+	if req.EndDate != "" {
+		if _, err := date.ParseNoTime(&req.EndDate); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "endDate must be formatted yyyy-MM-dd")
+		}
+	}
+	query := entryfilter.Query{
+		DiningHall:         req.DiningHall,
+		StartDate:          req.StartDate,
+		EndDate:            req.EndDate,
+		Meal:               req.Meal,
+		Attributes:         splitList(req.Attributes),
+		ExcludedAttributes: splitList(req.ExcludedAttributes),
+		Allergens:          splitList(req.Allergens),
+		ExcludedAllergens:  splitList(req.ExcludedAllergens),
+		Name:               req.Name,
+		Sort:               req.Sort,
+		Descending:         req.Descending,
+		Offset:             int(req.Offset),
+		Limit:              int(req.Limit),
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.filterableEntries == nil {
+		return nil, status.Error(codes.Unavailable, "Fetching data...")
+	}
+	result := entryfilter.Filter(s.filterableEntries.FilterableEntries, s.allergens, query)
+	glog.Infof("FilterEntries res{%d of %d entries}", len(result.Entries), result.Total)
+	return &extpb.FilterEntriesReply{
+		Entries: result.Entries,
+		Total:   int32(result.Total),
+		Offset:  int32(result.Offset),
+		Limit:   int32(result.Limit),
+	}, nil
+}
+
+func (s *Server) SearchFoods(ctx context.Context, req *extpb.SearchFoodsRequest) (*extpb.SearchFoodsReply, error) {
+	glog.Infof("SearchFoods req{%v}", req)
+	if req.Query == "" {
+		return nil, status.Error(codes.InvalidArgument, "query is required")
+	}
+	if req.Limit < 0 || req.Limit > 100 {
+		return nil, status.Error(codes.InvalidArgument, "limit must be between 0 and 100")
+	}
+	s.mu.RLock()
+	index := s.searchIndex
+	s.mu.RUnlock()
+	if index == nil {
+		return nil, status.Error(codes.Unavailable, "Fetching data...")
+	}
+	reply := &extpb.SearchFoodsReply{Results: []*extpb.FoodSearchResult{}}
+	for _, result := range index.Search(req.Query, int(req.Limit)) {
+		reply.Results = append(reply.Results, &extpb.FoodSearchResult{
+			Key:         result.Key,
+			Name:        result.Name,
+			Score:       result.Score,
+			LastServed:  result.LastServed,
+			NextServed:  result.NextServed,
+			TimesServed: result.TimesServed,
+		})
+	}
+	glog.Infof("SearchFoods res{%d results}", len(reply.Results))
+	return reply, nil
+}
+
+// Requests are not logged since clients autocomplete on every keystroke
+func (s *Server) Autocomplete(ctx context.Context, req *extpb.AutocompleteRequest) (*extpb.AutocompleteReply, error) {
+	if req.Limit < 0 || req.Limit > 50 {
+		return nil, status.Error(codes.InvalidArgument, "limit must be between 0 and 50")
+	}
+	s.mu.RLock()
+	index := s.autocompleteIndex
+	s.mu.RUnlock()
+	if index == nil {
+		return nil, status.Error(codes.Unavailable, "Fetching data...")
+	}
+	reply := &extpb.AutocompleteReply{Suggestions: []*extpb.Suggestion{}}
+	for _, suggestion := range index.Complete(req.Prefix, int(req.Limit)) {
+		reply.Suggestions = append(reply.Suggestions, &extpb.Suggestion{
+			Text:           suggestion.Text,
+			Key:            suggestion.Key,
+			Type:           suggestion.Type,
+			Hearts:         suggestion.Hearts,
+			RecentServings: suggestion.RecentServings,
+			Score:          suggestion.Score,
+		})
+	}
+	return reply, nil
+}
+
 func (s *Server) GetAll(ctx context.Context, req *pb.AllRequest) (*pb.AllReply, error) {
 	glog.Infof("GetAll req{%v}", req)
 	s.mu.RLock()
@@ -201,7 +763,7 @@ func (s *Server) GetMenu(ctx context.Context, req *pb.MenuRequest) (*pb.MenuRepl
 	if *meal == "" {
 		meal = nil
 	}
-	menus, err := s.dc.QueryMenus(diningHall, date, meal)
+	menus, err := s.dc.WithContext(ctx).QueryMenus(diningHall, date, meal)
 	if err != nil {
 		glog.Infof("GetMenu Error %s", err)
 		return nil, err
@@ -212,7 +774,8 @@ func (s *Server) GetMenu(ctx context.Context, req *pb.MenuRequest) (*pb.MenuRepl
 
 func (s *Server) GetFood(ctx context.Context, req *pb.FoodRequest) (*pb.FoodReply, error) {
 	glog.Infof("GetFood req{%v}", req)
-	name, date, startDate, endDate := &req.Name, &req.Date, &req.StartDate, &req.EndDate
+	key := foodnames.Key(req.Name)
+	name, date, startDate, endDate := &key, &req.Date, &req.StartDate, &req.EndDate
 	if *name == "" {
 		name = nil
 	}
@@ -228,9 +791,9 @@ func (s *Server) GetFood(ctx context.Context, req *pb.FoodRequest) (*pb.FoodRepl
 	var foods *[]*pb.Food
 	var err error
 	if startDate != nil || endDate != nil {
-		foods, err = s.dc.QueryFoodsDateRange(name, startDate, endDate)
+		foods, err = s.dc.WithContext(ctx).QueryFoodsDateRange(name, startDate, endDate)
 	} else {
-		foods, err = s.dc.QueryFoods(name, date)
+		foods, err = s.dc.WithContext(ctx).QueryFoods(name, date)
 	}
 	if err != nil {
 		glog.Infof("GetFood Error %s", err)
@@ -260,11 +823,533 @@ func (s *Server) GetSummaryStats(ctx context.Context, req *pb.SummaryStatsReques
 	return &pb.SummaryStatsReply{Stats: s.summaryStats}, nil
 }
 
+func (s *Server) GetRollupStats(ctx context.Context, req *extpb.RollupStatsRequest) (*extpb.RollupStatsReply, error) {
+	glog.Infof("GetRollupStats req{%v}", req)
+	if !rollups.Valid(req.Granularity) {
+		return nil, status.Errorf(codes.InvalidArgument, "granularity must be one of %v", rollups.Granularities)
+	}
+	var startPeriod, endPeriod *string
+	if req.StartDate != "" {
+		period, err := rollups.Period(req.Granularity, req.StartDate)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "startDate must be formatted yyyy-MM-dd")
+		}
+		startPeriod = &period
+	}
+	if req.EndDate != "" {
+		if _, err := date.ParseNoTime(&req.EndDate); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "endDate must be formatted yyyy-MM-dd")
+		}
+		// Periods are keyed by their start date so any period starting by endDate overlaps the range
+		endPeriod = &req.EndDate
+	}
+	result, err := s.dc.WithContext(ctx).QueryRollups(req.Granularity, startPeriod, endPeriod)
+	if err != nil {
+		glog.Errorf("GetRollupStats Error %s", err)
+		return nil, status.Error(codes.Internal, "Error making database request")
+	}
+	reply := &extpb.RollupStatsReply{Rollups: []*extpb.Rollup{}}
+	for _, rollup := range result {
+		reply.Rollups = append(reply.Rollups, &extpb.Rollup{
+			Granularity:           rollup.Granularity,
+			Period:                rollup.Period,
+			Label:                 rollup.Label,
+			StartDate:             rollup.StartDate,
+			EndDate:               rollup.EndDate,
+			NumDays:               rollup.NumDays,
+			NumUniqueFoods:        rollup.NumUniqueFoods,
+			TotalFoodMealsServed:  rollup.TotalFoodMealsServed,
+			TimesServed:           rollup.TimesServed,
+			CategoryCounts:        rollup.CategoryCounts,
+			AllergenCounts:        rollup.AllergenCounts,
+			AttributeCounts:       rollup.AttributeCounts,
+			DiningHallMealsServed: rollup.DiningHallMealsServed,
+			DiningHallUniqueFoods: rollup.DiningHallUniqueFoods,
+		})
+	}
+	glog.Infof("GetRollupStats res{%d rollups}", len(reply.Rollups))
+	return reply, nil
+}
+
+// SetTrendWindows - Sets the trend windows in weeks analyze computes, GetFoodTrends rejects any other window
+func (s *Server) SetTrendWindows(windows []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trendWindows = windows
+}
+
+// Converts trends to their proto messages
+func trendsToProto(trends []*foodtrends.Trend) []*extpb.FoodTrend {
+	converted := []*extpb.FoodTrend{}
+	for _, trend := range trends {
+		converted = append(converted, &extpb.FoodTrend{
+			WindowWeeks:         int32(trend.WindowWeeks),
+			Key:                 trend.Key,
+			WindowStart:         trend.WindowStart,
+			WindowEnd:           trend.WindowEnd,
+			Weekly:              trend.Weekly,
+			Total:               trend.Total,
+			Mean:                trend.Mean,
+			Slope:               trend.Slope,
+			RelativeSlope:       trend.RelativeSlope,
+			FirstServed:         trend.FirstServed,
+			LastServed:          trend.LastServed,
+			New:                 trend.New,
+			Retired:             trend.Retired,
+			TermServingsPerDay:  trend.TermServingsPerDay,
+			BreakServingsPerDay: trend.BreakServingsPerDay,
+			MonthCounts:         trend.MonthCounts,
+		})
+	}
+	return converted
+}
+
+func (s *Server) GetFoodTrends(ctx context.Context, req *extpb.FoodTrendsRequest) (*extpb.FoodTrendsReply, error) {
+	glog.Infof("GetFoodTrends req{%v}", req)
+	windowWeeks, minTotal, limit := int(req.WindowWeeks), req.MinTotal, int(req.Limit)
+	if windowWeeks == 0 {
+		windowWeeks = 12
+	}
+	if minTotal == 0 {
+		minTotal = 5
+	}
+	if limit == 0 {
+		limit = 25
+	}
+	if windowWeeks < 2 || minTotal < 0 || limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "windowWeeks must be at least 2 and minTotal and limit must not be negative")
+	}
+	s.mu.RLock()
+	configured := false
+	for _, window := range s.trendWindows {
+		configured = configured || window == windowWeeks
+	}
+	trends, exists := s.foodTrends[windowWeeks]
+	windows := s.trendWindows
+	s.mu.RUnlock()
+	if !configured {
+		return nil, status.Errorf(codes.InvalidArgument, "windowWeeks must be one of the computed windows %v", windows)
+	}
+	if !exists {
+		stored, err := s.dc.WithContext(ctx).QueryFoodTrends(windowWeeks)
+		if err != nil {
+			glog.Errorf("GetFoodTrends Error %s", err)
+			return nil, status.Error(codes.Internal, "Error making database request")
+		}
+		trends = foodtrends.Latest(stored)
+		// Not caching empty results so trends written by analyze are served before the next reload
+		if len(trends) > 0 {
+			s.mu.Lock()
+			s.foodTrends[windowWeeks] = trends
+			s.mu.Unlock()
+		}
+	}
+	if len(trends) == 0 {
+		return nil, status.Errorf(codes.NotFound, "No trends for a %d week window", windowWeeks)
+	}
+	rising, falling := foodtrends.Rank(trends, minTotal, limit)
+	reply := &extpb.FoodTrendsReply{
+		WindowWeeks: int32(windowWeeks),
+		WindowStart: trends[0].WindowStart,
+		WindowEnd:   trends[0].WindowEnd,
+		Rising:      trendsToProto(rising),
+		Falling:     trendsToProto(falling),
+		New:         trendsToProto(foodtrends.NewFoods(trends, limit)),
+		Retired:     trendsToProto(foodtrends.RetiredFoods(trends, limit)),
+	}
+	glog.Infof("GetFoodTrends res{%d rising, %d falling}", len(reply.Rising), len(reply.Falling))
+	return reply, nil
+}
+
+// Converts a rotation to its proto message
+func rotationToProto(r *rotation.Rotation) *extpb.Rotation {
+	converted := &extpb.Rotation{
+		DiningHall:  r.DiningHall,
+		CycleDays:   int32(r.CycleDays),
+		Confidence:  r.Confidence,
+		Similarity:  r.Similarity,
+		Baseline:    r.Baseline,
+		LagScores:   []*extpb.LagScore{},
+		FirstDate:   r.FirstDate,
+		LastDate:    r.LastDate,
+		Projections: []*extpb.ProjectedMenu{},
+	}
+	for _, score := range r.LagScores {
+		converted.LagScores = append(converted.LagScores, &extpb.LagScore{Lag: int32(score.Lag), Score: score.Score})
+	}
+	for _, projection := range r.Projections {
+		meals := map[string]*extpb.FoodNames{}
+		for meal, names := range projection.Meals {
+			meals[meal] = &extpb.FoodNames{Names: names}
+		}
+		converted.Projections = append(converted.Projections, &extpb.ProjectedMenu{
+			Date:       projection.Date,
+			SourceDate: projection.SourceDate,
+			Meals:      meals,
+			Predicted:  projection.Predicted,
+			Confidence: projection.Confidence,
+		})
+	}
+	return converted
+}
+
+func (s *Server) GetMenuRotations(ctx context.Context, req *extpb.MenuRotationsRequest) (*extpb.MenuRotationsReply, error) {
+	glog.Infof("GetMenuRotations req{%v}", req)
+	rotations, err := s.dc.WithContext(ctx).QueryMenuRotations()
+	if err != nil {
+		glog.Errorf("GetMenuRotations Error %s", err)
+		return nil, status.Error(codes.Internal, "Error making database request")
+	}
+	reply := &extpb.MenuRotationsReply{Rotations: []*extpb.Rotation{}}
+	for _, r := range rotations {
+		if req.DiningHall == "" || r.DiningHall == req.DiningHall {
+			reply.Rotations = append(reply.Rotations, rotationToProto(r))
+		}
+	}
+	glog.Infof("GetMenuRotations res{%d rotations}", len(reply.Rotations))
+	return reply, nil
+}
+
+// Converts a serving to its proto message, nil if there is none
+func servingToProto(serving *nextserving.Serving) *extpb.Serving {
+	if serving == nil {
+		return nil
+	}
+	return &extpb.Serving{
+		Date:        serving.Date,
+		DiningHall:  serving.DiningHall,
+		Meals:       serving.Meals,
+		Predicted:   serving.Predicted,
+		Probability: serving.Probability,
+	}
+}
+
+// Converts servings to their proto messages
+func servingsToProto(servings []*nextserving.Serving) []*extpb.Serving {
+	converted := []*extpb.Serving{}
+	for _, serving := range servings {
+		converted = append(converted, servingToProto(serving))
+	}
+	return converted
+}
+
+func (s *Server) GetNextServing(ctx context.Context, req *extpb.NextServingRequest) (*extpb.NextServingReply, error) {
+	glog.Infof("GetNextServing req{%v}", req)
+	key := foodnames.Key(req.Name)
+	if key == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	s.mu.RLock()
+	if s.foodStats == nil {
+		s.mu.RUnlock()
+		return nil, status.Error(codes.Unavailable, "Fetching data...")
+	}
+	weekdayCounts := map[string]int64{}
+	for _, stat := range *s.foodStats {
+		if counts, exists := stat.FoodWeekdayCounts[key]; exists {
+			for weekday, count := range counts.Data {
+				weekdayCounts[weekday] += count
+			}
+		}
+	}
+	s.mu.RUnlock()
+	opts := nextserving.DefaultOptions
+	today := date.Now()
+	startDate := date.FormatNoTime(today.AddDate(0, 0, -opts.HistoryDays))
+	history, err := s.dc.WithContext(ctx).QueryFoodsDateRange(&key, &startDate, nil)
+	if err != nil {
+		glog.Errorf("GetNextServing Error %s", err)
+		return nil, status.Error(codes.Internal, "Error making database request")
+	}
+	rotations := map[string]*rotation.Rotation{}
+	stored, err := s.dc.WithContext(ctx).QueryMenuRotations()
+	if err != nil {
+		// Predict from serving rates alone
+		glog.Errorf("GetNextServing Error querying menu rotations %s", err)
+	}
+	for _, r := range stored {
+		rotations[r.DiningHall] = r
+	}
+	prediction := nextserving.Predict(nextserving.Input{
+		History:       *history,
+		WeekdayCounts: weekdayCounts,
+		Rotations:     rotations,
+		Today:         today,
+	}, opts)
+	glog.Infof("GetNextServing res{%d published, next %v}", len(prediction.Published), prediction.Next)
+	return &extpb.NextServingReply{
+		Key:          key,
+		Published:    servingsToProto(prediction.Published),
+		Next:         servingToProto(prediction.Next),
+		Alternatives: servingsToProto(prediction.Alternatives),
+	}, nil
+}
+
+func (s *Server) GetFoodAssociations(ctx context.Context, req *extpb.FoodAssociationsRequest) (*extpb.FoodAssociationsReply, error) {
+	glog.Infof("GetFoodAssociations req{%v}", req)
+	key := foodnames.Key(req.Name)
+	if key == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	associations, err := s.dc.WithContext(ctx).GetFoodAssociations(key)
+	if err != nil {
+		glog.Errorf("GetFoodAssociations Error %s", err)
+		return nil, status.Error(codes.Internal, "Error making database request")
+	}
+	if associations == nil {
+		return nil, status.Errorf(codes.NotFound, "No associations for %s", key)
+	}
+	reply := &extpb.FoodAssociationsReply{
+		Key:          associations.Key,
+		Name:         associations.Name,
+		Count:        associations.Count,
+		NumMenus:     associations.NumMenus,
+		StartDate:    associations.StartDate,
+		EndDate:      associations.EndDate,
+		Associations: []*extpb.Association{},
+	}
+	for _, association := range associations.Associations {
+		reply.Associations = append(reply.Associations, &extpb.Association{
+			Key:        association.Key,
+			Name:       association.Name,
+			Count:      association.Count,
+			Support:    association.Support,
+			Confidence: association.Confidence,
+			Lift:       association.Lift,
+		})
+	}
+	glog.Infof("GetFoodAssociations res{%d associations}", len(reply.Associations))
+	return reply, nil
+}
+
+// Converts a dining hall summary to its proto message
+func summaryToProto(summary *hallstats.Summary) *extpb.DiningHallSummary {
+	converted := &extpb.DiningHallSummary{
+		DiningHall:         summary.DiningHall,
+		StartDate:          summary.StartDate,
+		EndDate:            summary.EndDate,
+		Servings:           summary.Servings,
+		UniqueFoods:        summary.UniqueFoods,
+		UniqueFoodsPerWeek: summary.UniqueFoodsPerWeek,
+		VarietyIndex:       summary.VarietyIndex,
+		AllergenFreeShare:  summary.AllergenFreeShare,
+		VeganShare:         summary.VeganShare,
+		VegetarianShare:    summary.VegetarianShare,
+		ExclusiveFoods:     summary.ExclusiveFoods,
+		Overlaps:           []*extpb.Overlap{},
+	}
+	for _, overlap := range summary.Overlaps {
+		converted.Overlaps = append(converted.Overlaps, &extpb.Overlap{DiningHall: overlap.DiningHall, Jaccard: overlap.Jaccard})
+	}
+	return converted
+}
+
+func (s *Server) GetDiningHallSummaries(ctx context.Context, req *extpb.DiningHallSummariesRequest) (*extpb.DiningHallSummariesReply, error) {
+	glog.Infof("GetDiningHallSummaries req{%v}", req)
+	summaries, err := s.dc.WithContext(ctx).QueryDiningHallSummaries()
+	if err != nil {
+		glog.Errorf("GetDiningHallSummaries Error %s", err)
+		return nil, status.Error(codes.Internal, "Error making database request")
+	}
+	reply := &extpb.DiningHallSummariesReply{Summaries: []*extpb.DiningHallSummary{}}
+	for _, summary := range summaries {
+		if req.DiningHall == "" || summary.DiningHall == req.DiningHall {
+			reply.Summaries = append(reply.Summaries, summaryToProto(summary))
+		}
+	}
+	if req.DiningHall != "" && len(reply.Summaries) == 0 {
+		return nil, status.Errorf(codes.NotFound, "No summary for %s", req.DiningHall)
+	}
+	sort.Slice(reply.Summaries, func(i, j int) bool { return reply.Summaries[i].DiningHall < reply.Summaries[j].DiningHall })
+	glog.Infof("GetDiningHallSummaries res{%d summaries}", len(reply.Summaries))
+	return reply, nil
+}
+
+// Maximum number of days in a date range request
+const maxRangeDays = 31
+
+// Resolves a single date or a range of dates (either end defaulting to the other, both to today) and checks
+// it spans at most maxRangeDays
+func dateRange(day string, startDate string, endDate string) (time.Time, time.Time, error) {
+	if day != "" {
+		startDate, endDate = day, day
+	} else if startDate == "" && endDate == "" {
+		startDate = date.FormatNoTime(date.Now())
+		endDate = startDate
+	} else if startDate == "" {
+		startDate = endDate
+	} else if endDate == "" {
+		endDate = startDate
+	}
+	start, err := date.ParseNoTime(&startDate)
+	if err != nil {
+		return start, start, status.Error(codes.InvalidArgument, "startDate must be formatted yyyy-MM-dd")
+	}
+	end, err := date.ParseNoTime(&endDate)
+	if err != nil {
+		return start, end, status.Error(codes.InvalidArgument, "endDate must be formatted yyyy-MM-dd")
+	}
+	if end.Before(start) {
+		return start, end, status.Error(codes.InvalidArgument, "endDate must not be before startDate")
+	}
+	if end.After(start.AddDate(0, 0, maxRangeDays-1)) {
+		return start, end, status.Errorf(codes.InvalidArgument, "Date range must be at most %d days", maxRangeDays)
+	}
+	return start, end, nil
+}
+
+// Meal weights are given over REST as mealWeights[MEAL]=weight query parameters
+func (s *Server) GetDiningHallSimilarity(ctx context.Context, req *extpb.DiningHallSimilarityRequest) (*extpb.DiningHallSimilarityReply, error) {
+	glog.Infof("GetDiningHallSimilarity req{%v}", req)
+	start, end, err := dateRange(req.Date, req.StartDate, req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	mealWeights := map[string]float64{}
+	for meal, weight := range req.MealWeights {
+		if weight < 0 {
+			return nil, status.Error(codes.InvalidArgument, "mealWeights must not be negative")
+		}
+		mealWeights[strings.ToUpper(meal)] = weight
+	}
+	builder := hallsimilarity.NewBuilder()
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		day := date.FormatNoTime(d)
+		menus, err := s.dc.WithContext(ctx).QueryMenus(nil, &day, nil)
+		if err != nil {
+			glog.Errorf("GetDiningHallSimilarity Error %s", err)
+			return nil, status.Error(codes.Internal, "Error making database request")
+		}
+		for _, menu := range *menus {
+			builder.AddMenu(menu)
+		}
+	}
+	matrix := builder.Build(mealWeights)
+	if len(matrix.DiningHalls) == 0 {
+		return nil, status.Errorf(codes.NotFound, "No menus between %s and %s", date.FormatNoTime(start), date.FormatNoTime(end))
+	}
+	reply := &extpb.DiningHallSimilarityReply{
+		StartDate:     matrix.StartDate,
+		EndDate:       matrix.EndDate,
+		DiningHalls:   matrix.DiningHalls,
+		Similarity:    []*extpb.SimilarityRow{},
+		MealsCompared: []*extpb.MealsComparedRow{},
+		Unique:        map[string]*extpb.FoodNames{},
+	}
+	for _, row := range matrix.Similarity {
+		reply.Similarity = append(reply.Similarity, &extpb.SimilarityRow{Values: row})
+	}
+	for _, row := range matrix.MealsCompared {
+		reply.MealsCompared = append(reply.MealsCompared, &extpb.MealsComparedRow{Values: row})
+	}
+	for diningHall, names := range matrix.Unique {
+		reply.Unique[diningHall] = &extpb.FoodNames{Names: names}
+	}
+	glog.Infof("GetDiningHallSimilarity res{%d dining halls}", len(reply.DiningHalls))
+	return reply, nil
+}
+
+// Converts a distribution to its proto message
+func distributionToProto(d nutrition.Distribution) *extpb.Distribution {
+	return &extpb.Distribution{Count: d.Count, Min: d.Min, Q1: d.Q1, Median: d.Median, Q3: d.Q3, Max: d.Max, Mean: d.Mean}
+}
+
+// Converts a nutrition aggregate to its proto message
+func aggregateToProto(aggregate *nutrition.Aggregate) *extpb.NutritionAggregate {
+	converted := &extpb.NutritionAggregate{
+		Date:             aggregate.Date,
+		DiningHallMeal:   aggregate.DiningHallMeal,
+		DiningHall:       aggregate.DiningHall,
+		Meal:             aggregate.Meal,
+		NumItems:         aggregate.NumItems,
+		NumWithNutrition: aggregate.NumWithNutrition,
+		Calories:         distributionToProto(aggregate.Calories),
+		Protein:          distributionToProto(aggregate.Protein),
+		Sodium:           distributionToProto(aggregate.Sodium),
+		ProteinRichItems: aggregate.ProteinRichItems,
+		SodiumOutliers:   []*extpb.Outlier{},
+	}
+	for _, outlier := range aggregate.SodiumOutliers {
+		converted.SodiumOutliers = append(converted.SodiumOutliers, &extpb.Outlier{Name: outlier.Name, Value: outlier.Value})
+	}
+	return converted
+}
+
+func (s *Server) GetNutritionAggregates(ctx context.Context, req *extpb.NutritionAggregatesRequest) (*extpb.NutritionAggregatesReply, error) {
+	glog.Infof("GetNutritionAggregates req{%v}", req)
+	start, end, err := dateRange(req.Date, req.StartDate, req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	var diningHall *string
+	if req.DiningHall != "" {
+		diningHall = &req.DiningHall
+	}
+	reply := &extpb.NutritionAggregatesReply{Aggregates: []*extpb.NutritionAggregate{}}
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		aggregates, err := s.dc.WithContext(ctx).QueryNutritionAggregates(date.FormatNoTime(d), diningHall)
+		if err != nil {
+			glog.Errorf("GetNutritionAggregates Error %s", err)
+			return nil, status.Error(codes.Internal, "Error making database request")
+		}
+		for _, aggregate := range aggregates {
+			// The key prefix also matches halls whose name starts with the requested hall
+			if diningHall != nil && aggregate.DiningHall != *diningHall {
+				continue
+			}
+			if req.Meal != "" && aggregate.Meal != req.Meal {
+				continue
+			}
+			reply.Aggregates = append(reply.Aggregates, aggregateToProto(aggregate))
+		}
+	}
+	sort.Slice(reply.Aggregates, func(i, j int) bool {
+		a, b := reply.Aggregates[i], reply.Aggregates[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		return a.DiningHallMeal < b.DiningHallMeal
+	})
+	glog.Infof("GetNutritionAggregates res{%d aggregates}", len(reply.Aggregates))
+	return reply, nil
+}
+
+func (s *Server) GetFoodNutrition(ctx context.Context, req *extpb.FoodNutritionRequest) (*extpb.FoodNutritionReply, error) {
+	glog.Infof("GetFoodNutrition req{%v}", req)
+	key := foodnames.Key(req.Name)
+	if key == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	food, err := s.dc.WithContext(ctx).GetFoodNutrition(key)
+	if err != nil {
+		glog.Errorf("GetFoodNutrition Error %s", err)
+		return nil, status.Error(codes.Internal, "Error making database request")
+	}
+	if food == nil {
+		return nil, status.Errorf(codes.NotFound, "No nutrition history for %s", key)
+	}
+	reply := &extpb.FoodNutritionReply{Key: food.Key, Name: food.Name, History: []*extpb.NutritionHistoryEntry{}}
+	for _, entry := range food.History {
+		reply.History = append(reply.History, &extpb.NutritionHistoryEntry{
+			StartDate:   entry.StartDate,
+			EndDate:     entry.EndDate,
+			NumDays:     entry.NumDays,
+			ServingSize: entry.ServingSize,
+			Calories:    entry.Calories,
+			Protein:     entry.Protein,
+			Sodium:      entry.Sodium,
+			Nutrients:   entry.Nutrients,
+		})
+	}
+	glog.Infof("GetFoodNutrition res{%d entries}", len(reply.History))
+	return reply, nil
+}
+
 func (s *Server) AddHeart(ctx context.Context, req *pb.HeartsRequest) (*pb.HeartsReply, error) {
 	glog.Infof("AddHeart req{%v}", req)
 	reply := pb.HeartsReply{Counts: []*pb.HeartCount{}}
-	for _, key := range req.Keys {
-		heartCount, err := s.dc.AddHeart(key)
+	for _, key := range foodnames.Keys(req.Keys) {
+		heartCount, err := s.dc.WithContext(ctx).AddHeart(key)
 		if err != nil {
 			glog.Errorf("Error adding heart: %s", err)
 			continue
@@ -276,10 +1361,7 @@ func (s *Server) AddHeart(ctx context.Context, req *pb.HeartsRequest) (*pb.Heart
 
 func (s *Server) GetHearts(ctx context.Context, req *pb.HeartsRequest) (*pb.HeartsReply, error) {
 	glog.Infof("GetHearts req{%v}", req)
-	for i, key := range req.Keys {
-		req.Keys[i] = strings.ToLower(key)
-	}
-	counts, err := s.dc.GetHearts(req.Keys)
+	counts, err := s.dc.WithContext(ctx).GetHearts(foodnames.Keys(req.Keys))
 	if err != nil {
 		return nil, status.Error(codes.Internal, "Error making databse request")
 	}
@@ -295,16 +1377,28 @@ type heartStreamRequest struct {
 
 func (s *Server) StreamHearts(req *pb.HeartsRequest, stream pb.MDining_StreamHeartsServer) error {
 	glog.Infof("StreamHearts req{%v}", req)
-	done := make(chan struct{})
+	// Buffered so listenForHearts never blocks on a stream which is closing
+	done := make(chan struct{}, 1)
 	streamReq := &heartStreamRequest{id: uuid.New().String(), done: done, stream: stream, request: *req}
+	streamReq.request.Keys = foodnames.Keys(req.Keys)
 	glog.Infof("Opening heart stream %s", streamReq.id)
 	s.mu.Lock()
+	if s.isShuttingDown() {
+		s.mu.Unlock()
+		return status.Error(codes.Unavailable, "Server is shutting down")
+	}
 	s.heartStreams[streamReq.id] = streamReq
 	s.mu.Unlock()
-	<-done
+	openHeartStreams.Inc()
+	select {
+	case <-done:
+	case <-stream.Context().Done():
+	case <-s.shutdown:
+	}
 	glog.Infof("Closing heart stream %s", streamReq.id)
 	s.mu.Lock()
 	delete(s.heartStreams, streamReq.id)
 	s.mu.Unlock()
+	openHeartStreams.Dec()
 	return nil
 }
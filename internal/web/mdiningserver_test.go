@@ -0,0 +1,55 @@
+package mdiningserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailedReloadBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 5 * time.Minute},
+		{2, 10 * time.Minute},
+		{3, 20 * time.Minute},
+		{5, 80 * time.Minute},
+		{6, 2 * time.Hour},
+		{20, 2 * time.Hour},
+		// Large enough to overflow the shift
+		{100, 2 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := failedReloadBackoff(test.failures); got != test.want {
+			t.Errorf("failedReloadBackoff(%d) = %v, want %v", test.failures, got, test.want)
+		}
+	}
+}
+
+func TestReloadDue(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	tests := []struct {
+		name      string
+		latest    int64
+		loaded    int64
+		attempted int64
+		retryAt   time.Time
+		want      bool
+	}{
+		{"up to date", 3, 3, 3, time.Time{}, false},
+		{"new generation", 4, 3, 3, time.Time{}, true},
+		{"new generation during backoff", 5, 3, 4, now.Add(time.Minute), true},
+		{"failed generation during backoff", 4, 3, 4, now.Add(time.Minute), false},
+		{"failed generation at end of backoff", 4, 3, 4, now, true},
+		{"failed generation after backoff", 4, 3, 4, now.Add(-time.Minute), true},
+		{"behind the loaded generation", 2, 3, 3, time.Time{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := reloadDue(test.latest, test.loaded, test.attempted, test.retryAt, now); got != test.want {
+				t.Errorf("reloadDue(%d, %d, %d, %s) = %v, want %v",
+					test.latest, test.loaded, test.attempted, test.retryAt, got, test.want)
+			}
+		})
+	}
+}
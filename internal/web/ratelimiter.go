@@ -1,54 +1,437 @@
 package ratelimiter
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/MichiganDiningAPI/internal/util/date"
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
-const maxPerHour = 1
+//
+// Per client token bucket rate limiting of REST, grpc and grpc-web requests
+//
+// Each client has a bucket per route holding up to Burst tokens which refill
+// at Rate tokens per second. A request takes a token and is rejected while its
+// bucket is empty, with a hint of how long until a token is available. Routes
+// are HTTP paths (e.g. /v1/menus) or grpc full method names (e.g.
+// /mdining.MDining/GetMenu) and are matched by the longest configured prefix,
+// with routes without a configured limit sharing the client's default bucket.
+// Clients are identified by their API key if given, otherwise by their IP
+// address. IPv6 clients share a bucket per /64 since a single host is usually
+// assigned a whole /64. Requests with an API key use the limits of the key's
+// tier and are also counted against the tier's daily quota. Requests with an
+// unknown or revoked key are rejected.
+//
 
-func getIPFromRemoteAddr(remoteAddr string) string {
-	comps := strings.Split(remoteAddr, ":")
-	if len(comps) != 2 {
-		// If the address is not parsable, just return localhost
-		return "localhost"
+// APIKeyHeader - HTTP header and grpc metadata key holding a client's API key
+const APIKeyHeader = "x-api-key"
+
+// How often buckets which have refilled are dropped
+const cleanupInterval = time.Minute
+
+// Reasons requests are rejected
+const (
+	rateLimited = "rate_limit"
+	overQuota   = "daily_quota"
+	invalidKey  = "invalid_key"
+)
+
+var rejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ratelimit_rejections_total",
+	Help: "Requests rejected by the rate limiter by transport (http or grpc) and reason.",
+}, []string{"transport", "reason"})
+
+// Limit - Token bucket parameters, a Burst of 0 disables limiting
+type Limit struct {
+	// Tokens added per second
+	Rate float64 `json:"rate"`
+	// Size of the bucket
+	Burst float64 `json:"burst"`
+}
+
+// Limits - Limit applied to routes without a more specific limit along with per route limits
+type Limits struct {
+	Default Limit            `json:"default"`
+	Routes  map[string]Limit `json:"routes"`
+}
+
+// Tier - Limits of the API keys of a tier
+type Tier struct {
+	Limits
+	// Requests allowed per day, 0 for no quota
+	DailyQuota int64 `json:"dailyQuota"`
+}
+
+// Config - Limits of clients without an API key and of each API key tier
+type Config struct {
+	Limits
+	Tiers map[string]Tier `json:"tiers"`
+}
+
+// DefaultTier - Tier of API keys issued without one
+const DefaultTier = "standard"
+
+// AdminTier - Tier of API keys which may also call admin routes
+const AdminTier = "admin"
+
+// DefaultConfig - Limits used without a config file, menus are the most expensive to serve
+var DefaultConfig = Config{
+	Limits: Limits{
+		Default: Limit{Rate: 5, Burst: 50},
+		Routes: map[string]Limit{
+			"/v1/menus":                {Rate: 1, Burst: 20},
+			"/mdining.MDining/GetMenu": {Rate: 1, Burst: 20},
+		},
+	},
+	Tiers: map[string]Tier{
+		DefaultTier: Tier{
+			Limits:     Limits{Default: Limit{Rate: 20, Burst: 200}},
+			DailyQuota: 100000,
+		},
+		"partner": Tier{
+			Limits:     Limits{Default: Limit{Rate: 100, Burst: 1000}},
+			DailyQuota: 0,
+		},
+		AdminTier: Tier{
+			Limits:     Limits{Default: Limit{Rate: 100, Burst: 1000}},
+			DailyQuota: 0,
+		},
+	},
+}
+
+// KeyStore - Issued API keys and their usage
+type KeyStore interface {
+	// Returns the id and tier of an API key, false if it is not a valid key
+	Lookup(key string) (string, string, bool)
+	// Counts a request made with the API key with id, returning the key's requests today
+	Use(id string) int64
+}
+
+// LoadConfig - Reads a json Config from path
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
 	}
-	return comps[0]
+	if err := validate(&config.Limits); err != nil {
+		return nil, err
+	}
+	for name, tier := range config.Tiers {
+		if err := validate(&tier.Limits); err != nil {
+			return nil, fmt.Errorf("%s in tier %s", err, name)
+		}
+		if tier.DailyQuota < 0 {
+			return nil, fmt.Errorf("Negative daily quota in tier %s", name)
+		}
+	}
+	return config, nil
+}
+
+func validate(limits *Limits) error {
+	for route, limit := range limits.Routes {
+		if limit.Rate < 0 || limit.Burst < 0 {
+			return fmt.Errorf("Negative limit for route %s", route)
+		}
+	}
+	if limits.Default.Rate < 0 || limits.Default.Burst < 0 {
+		return fmt.Errorf("Negative default limit")
+	}
+	return nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
 }
 
-type hourRate struct {
-	hour  int
-	count int
+// Refills the bucket up to now
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.limit.Burst, b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
 }
 
+// RateLimiter - Token buckets of every client and route
 type RateLimiter struct {
-	rates map[string]*hourRate
-	mu    sync.RWMutex
+	config Config
+	// Number of proxies in front of the server which append to X-Forwarded-For
+	trustedProxyHops int
+	// nil if API keys are not accepted
+	keys    KeyStore
+	buckets map[string]*bucket
+	mu      sync.Mutex
 }
 
-func New() *RateLimiter {
-	return &RateLimiter{rates: make(map[string]*hourRate)}
+// New - Creates a RateLimiter. trustedProxyHops is the number of proxies (e.g. load balancers) in front of
+// the server which append the client address to X-Forwarded-For, 0 to ignore the header. If keys is nil the
+// API key header is ignored.
+func New(config Config, trustedProxyHops int, keys KeyStore) *RateLimiter {
+	r := &RateLimiter{config: config, trustedProxyHops: trustedProxyHops, keys: keys, buckets: map[string]*bucket{}}
+	go r.cleanup()
+	return r
 }
 
-func (r *RateLimiter) ShouldAllow(req *http.Request) bool {
-	if !strings.Contains(req.URL.Path, "menus") {
-		return true
+// Returns the limit of the longest configured prefix of route
+func routeLimit(limits *Limits, route string) (string, Limit) {
+	matched, limit := "", limits.Default
+	for prefix, l := range limits.Routes {
+		if strings.HasPrefix(route, prefix) && len(prefix) > len(matched) {
+			matched, limit = prefix, l
+		}
+	}
+	return matched, limit
+}
+
+// Takes a token from the client's bucket for route. Returns whether the request is allowed and, if not,
+// how long until it would be.
+func (r *RateLimiter) take(limits *Limits, route string, client string) (bool, time.Duration) {
+	prefix, limit := routeLimit(limits, route)
+	if limit.Burst <= 0 {
+		return true, 0
 	}
-	hour := time.Now().Hour()
-	ip := getIPFromRemoteAddr(req.RemoteAddr)
+	now := time.Now()
+	key := client + " " + prefix
 	r.mu.Lock()
-	rate, ok := r.rates["default"]
-	if !ok || rate.hour != hour {
-		r.rates["default"] = &hourRate{hour, 0}
-		rate = r.rates["default"]
-	}
-	var requestRate = rate.count + 1
-	rate.count = requestRate
-	r.mu.Unlock()
-	glog.Infof("RateLimiter %s {hour: %v, count: %v} Allow: %b", ip, hour, requestRate, requestRate <= maxPerHour)
-	return requestRate <= maxPerHour
+	defer r.mu.Unlock()
+	b, exists := r.buckets[key]
+	if !exists {
+		b = &bucket{tokens: limit.Burst, last: now, limit: limit}
+		r.buckets[key] = b
+	}
+	b.refill(now)
+	// A key's tier may have changed since the bucket was created
+	b.limit = limit
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if limit.Rate <= 0 {
+		// Never refills
+		return false, time.Hour
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// Drops buckets which have refilled, as they are the same as new buckets
+func (r *RateLimiter) cleanup() {
+	for range time.Tick(cleanupInterval) {
+		now := time.Now()
+		r.mu.Lock()
+		for key, b := range r.buckets {
+			if b.limit.Rate > 0 && b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= b.limit.Burst {
+				delete(r.buckets, key)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Returns the IP address identifying a client given its X-Forwarded-For values and the address of the connection
+func (r *RateLimiter) clientIP(forwardedFor []string, remoteAddr string) string {
+	ip := hostIP(remoteAddr)
+	if r.trustedProxyHops > 0 {
+		// Each proxy appends the address it received the request from, so the client is the entry added by the
+		// outermost trusted proxy. Entries before it may have been set by the client.
+		addrs := []string{}
+		for _, value := range forwardedFor {
+			for _, addr := range strings.Split(value, ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					addrs = append(addrs, addr)
+				}
+			}
+		}
+		if len(addrs) > 0 {
+			// With fewer entries than proxies the first is the closest to the client
+			i := len(addrs) - r.trustedProxyHops
+			if i < 0 {
+				i = 0
+			}
+			if forwarded := hostIP(addrs[i]); forwarded != nil {
+				ip = forwarded
+			}
+		}
+	}
+	if ip == nil {
+		return "addr:" + remoteAddr
+	}
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 128))
+	}
+	return "ip:" + ip.String()
+}
+
+// Why a request was rejected
+type rejection struct {
+	reason     string
+	message    string
+	retryAfter time.Duration
+}
+
+// Checks the limits of a request to route with apiKey (empty if none). Returns the client identity and, if
+// the request is rejected, why.
+func (r *RateLimiter) check(route string, apiKey string, forwardedFor []string, remoteAddr string) (string, *rejection) {
+	if apiKey == "" || r.keys == nil {
+		client := r.clientIP(forwardedFor, remoteAddr)
+		if allowed, retryAfter := r.take(&r.config.Limits, route, client); !allowed {
+			return client, &rejection{reason: rateLimited, message: "Rate limit reached", retryAfter: retryAfter}
+		}
+		return client, nil
+	}
+	id, tierName, valid := r.keys.Lookup(apiKey)
+	if !valid {
+		return "", &rejection{reason: invalidKey, message: "Invalid API key"}
+	}
+	client := "key:" + id
+	tier, exists := r.config.Tiers[tierName]
+	if !exists {
+		glog.Warningf("API key %s has unknown tier %s, using the default limits", id, tierName)
+		tier = Tier{Limits: r.config.Limits}
+	}
+	if allowed, retryAfter := r.take(&tier.Limits, route, client); !allowed {
+		return client, &rejection{reason: rateLimited, message: "Rate limit reached", retryAfter: retryAfter}
+	}
+	if used := r.keys.Use(id); tier.DailyQuota > 0 && used > tier.DailyQuota {
+		now := date.Now()
+		return client, &rejection{
+			reason:     overQuota,
+			message:    fmt.Sprintf("Daily quota of %d requests reached", tier.DailyQuota),
+			retryAfter: date.DayStart(now).AddDate(0, 0, 1).Sub(now),
+		}
+	}
+	return client, nil
+}
+
+// Parses an IP address with or without a port
+func hostIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.Trim(addr, "[]"))
+}
+
+// Rounds up to whole seconds, at least 1
+func retrySeconds(retryAfter time.Duration) int64 {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// AllowHTTP - Checks the limits of an HTTP request. Requests over a limit are answered with 429 Too Many
+// Requests and a Retry-After header, and requests with an invalid API key with 401 Unauthorized.
+func (r *RateLimiter) AllowHTTP(resp http.ResponseWriter, req *http.Request) bool {
+	client, rejected := r.check(req.URL.Path, req.Header.Get(APIKeyHeader), req.Header["X-Forwarded-For"], req.RemoteAddr)
+	if rejected == nil {
+		return true
+	}
+	rejections.WithLabelValues("http", rejected.reason).Inc()
+	if rejected.reason == invalidKey {
+		http.Error(resp, rejected.message, http.StatusUnauthorized)
+		return false
+	}
+	seconds := retrySeconds(rejected.retryAfter)
+	glog.Infof("RateLimiter %s rejected %s: %s, retry after %ds", client, req.URL.Path, rejected.message, seconds)
+	resp.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	http.Error(resp, fmt.Sprintf("Please do not abuse this API. %s, retry after %ds.", rejected.message, seconds), http.StatusTooManyRequests)
+	return false
+}
+
+// Checks the limits of a grpc call. Returns an Unauthenticated error for an invalid API key, or a
+// ResourceExhausted error with RetryInfo and the header to send if over a limit.
+func (r *RateLimiter) allowGRPC(ctx context.Context, fullMethod string) (metadata.MD, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	apiKey := ""
+	if keys := md.Get(APIKeyHeader); len(keys) > 0 {
+		apiKey = keys[0]
+	}
+	remoteAddr := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	client, rejected := r.check(fullMethod, apiKey, md.Get("x-forwarded-for"), remoteAddr)
+	if rejected == nil {
+		return nil, nil
+	}
+	rejections.WithLabelValues("grpc", rejected.reason).Inc()
+	if rejected.reason == invalidKey {
+		return metadata.MD{}, status.Error(codes.Unauthenticated, rejected.message)
+	}
+	seconds := retrySeconds(rejected.retryAfter)
+	glog.Infof("RateLimiter %s rejected %s: %s, retry after %ds", client, fullMethod, rejected.message, seconds)
+	st := status.Newf(codes.ResourceExhausted, "%s, retry after %ds", rejected.message, seconds)
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(time.Duration(seconds) * time.Second)}); err == nil {
+		st = detailed
+	}
+	return metadata.Pairs("retry-after", strconv.FormatInt(seconds, 10)), st.Err()
+}
+
+// UnaryServerInterceptor - Rate limits unary grpc and grpc-web calls
+func (r *RateLimiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if header, err := r.allowGRPC(ctx, info.FullMethod); err != nil {
+			grpc.SetHeader(ctx, header)
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor - Rate limits the start of streaming grpc and grpc-web calls
+func (r *RateLimiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if header, err := r.allowGRPC(ss.Context(), info.FullMethod); err != nil {
+			ss.SetHeader(header)
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// AdminUnaryServerInterceptor - Rejects calls of methods without an API key of the admin tier with a
+// PermissionDenied error. Without a key store every call of methods is rejected.
+func AdminUnaryServerInterceptor(keys KeyStore, methods ...string) grpc.UnaryServerInterceptor {
+	adminOnly := map[string]bool{}
+	for _, method := range methods {
+		adminOnly[method] = true
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !adminOnly[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		apiKey := ""
+		if values := md.Get(APIKeyHeader); len(values) > 0 {
+			apiKey = values[0]
+		}
+		if keys != nil {
+			if _, tier, ok := keys.Lookup(apiKey); ok && tier == AdminTier {
+				return handler(ctx, req)
+			}
+		}
+		return nil, status.Error(codes.PermissionDenied, "An admin API key is required")
+	}
 }
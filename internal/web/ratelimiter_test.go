@@ -0,0 +1,283 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestBucketRefill(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	tests := []struct {
+		name    string
+		limit   Limit
+		tokens  float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{"no time passed", Limit{Rate: 2, Burst: 10}, 3, 0, 3},
+		{"partial refill", Limit{Rate: 2, Burst: 10}, 3, 1500 * time.Millisecond, 6},
+		{"capped at burst", Limit{Rate: 2, Burst: 10}, 3, time.Minute, 10},
+		{"fractional tokens", Limit{Rate: 0.5, Burst: 1}, 0, time.Second, 0.5},
+		{"zero rate never refills", Limit{Rate: 0, Burst: 5}, 1, time.Hour, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &bucket{tokens: test.tokens, last: start, limit: test.limit}
+			now := start.Add(test.elapsed)
+			b.refill(now)
+			if b.tokens != test.want {
+				t.Errorf("refill after %s left %v tokens, want %v", test.elapsed, b.tokens, test.want)
+			}
+			if !b.last.Equal(now) {
+				t.Errorf("refill set last to %s, want %s", b.last, now)
+			}
+		})
+	}
+}
+
+func TestTakeRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Limit
+		// Requests made in quick succession
+		requests int
+		// Requests expected to be allowed before the first rejection
+		allowed int
+		// How long after the first rejection a token is expected to be available
+		wantRetry time.Duration
+	}{
+		{"burst then one token per second", Limit{Rate: 1, Burst: 3}, 4, 3, time.Second},
+		{"fast refill", Limit{Rate: 4, Burst: 2}, 3, 2, 250 * time.Millisecond},
+		{"slow refill", Limit{Rate: 0.1, Burst: 1}, 2, 1, 10 * time.Second},
+		{"zero rate", Limit{Rate: 0, Burst: 2}, 3, 2, time.Hour},
+		{"zero burst disables limiting", Limit{Rate: 0, Burst: 0}, 100, 100, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := New(Config{Limits: Limits{Default: test.limit}}, 0, nil)
+			allowed := 0
+			var retryAfter time.Duration
+			for i := 0; i < test.requests; i++ {
+				ok, retry := r.take(&r.config.Limits, "/v1/menus", "ip:192.0.2.1")
+				if !ok {
+					retryAfter = retry
+					break
+				}
+				allowed++
+			}
+			if allowed != test.allowed {
+				t.Errorf("take allowed %d requests, want %d", allowed, test.allowed)
+			}
+			// Tokens refill between requests, so allow for some time having passed
+			if retryAfter > test.wantRetry || retryAfter < test.wantRetry-100*time.Millisecond {
+				t.Errorf("take returned retry after %s, want %s", retryAfter, test.wantRetry)
+			}
+		})
+	}
+}
+
+func TestTakeRouteBuckets(t *testing.T) {
+	r := New(Config{Limits: Limits{
+		Default: Limit{Rate: 1, Burst: 1},
+		Routes:  map[string]Limit{"/v1/menus": Limit{Rate: 1, Burst: 1}},
+	}}, 0, nil)
+	requests := []struct {
+		route  string
+		client string
+		want   bool
+	}{
+		{"/v1/menus", "ip:192.0.2.1", true},
+		// Same prefix shares the bucket
+		{"/v1/menus/today", "ip:192.0.2.1", false},
+		// Other routes share the default bucket
+		{"/v1/items", "ip:192.0.2.1", true},
+		{"/v1/halls", "ip:192.0.2.1", false},
+		// Clients have their own buckets
+		{"/v1/menus", "ip:192.0.2.2", true},
+	}
+	for _, request := range requests {
+		if got, _ := r.take(&r.config.Limits, request.route, request.client); got != request.want {
+			t.Errorf("take(%s, %s) = %t, want %t", request.route, request.client, got, request.want)
+		}
+	}
+}
+
+func TestRetrySeconds(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		want       int64
+	}{
+		{0, 1},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{time.Second + time.Millisecond, 2},
+		{2500 * time.Millisecond, 3},
+		{time.Hour, 3600},
+	}
+	for _, test := range tests {
+		if got := retrySeconds(test.retryAfter); got != test.want {
+			t.Errorf("retrySeconds(%s) = %d, want %d", test.retryAfter, got, test.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name             string
+		trustedProxyHops int
+		forwardedFor     []string
+		remoteAddr       string
+		want             string
+	}{
+		{"no proxies ignores header", 0, []string{"198.51.100.7"}, "203.0.113.5:1234", "ip:203.0.113.5"},
+		{"one proxy without header", 1, nil, "203.0.113.5:1234", "ip:203.0.113.5"},
+		{"one proxy", 1, []string{"198.51.100.7"}, "10.0.0.1:1234", "ip:198.51.100.7"},
+		{"one proxy ignores client set entries", 1, []string{"192.0.2.66, 198.51.100.7"}, "10.0.0.1:1234", "ip:198.51.100.7"},
+		{"two proxies", 2, []string{"192.0.2.66, 198.51.100.7, 10.0.0.2"}, "10.0.0.1:1234", "ip:198.51.100.7"},
+		{"two proxies across headers", 2, []string{"192.0.2.66", "198.51.100.7", "10.0.0.2"}, "10.0.0.1:1234", "ip:198.51.100.7"},
+		{"fewer entries than proxies", 3, []string{"198.51.100.7, 10.0.0.2"}, "10.0.0.1:1234", "ip:198.51.100.7"},
+		{"empty entries skipped", 1, []string{"198.51.100.7, ", ""}, "10.0.0.1:1234", "ip:198.51.100.7"},
+		{"entry with port", 1, []string{"198.51.100.7:5555"}, "10.0.0.1:1234", "ip:198.51.100.7"},
+		{"invalid entry falls back to connection", 1, []string{"unknown"}, "203.0.113.5:1234", "ip:203.0.113.5"},
+		{"ipv6 connection masked to /64", 0, nil, "[2001:db8:1:2:3:4:5:6]:443", "ip:2001:db8:1:2::"},
+		{"ipv6 entry masked to /64", 1, []string{"2001:db8:1:2:ffff::1"}, "10.0.0.1:1234", "ip:2001:db8:1:2::"},
+		{"bracketed ipv6 entry with port", 1, []string{"[2001:db8:1:2::9]:5555"}, "10.0.0.1:1234", "ip:2001:db8:1:2::"},
+		{"ipv4 mapped ipv6 not masked", 0, nil, "[::ffff:198.51.100.7]:443", "ip:198.51.100.7"},
+		{"unparseable connection address", 0, nil, "@", "addr:@"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &RateLimiter{trustedProxyHops: test.trustedProxyHops}
+			if got := r.clientIP(test.forwardedFor, test.remoteAddr); got != test.want {
+				t.Errorf("clientIP(%q, %q) = %s, want %s", test.forwardedFor, test.remoteAddr, got, test.want)
+			}
+		})
+	}
+}
+
+// Issued keys and their usage
+type fakeKeys struct {
+	// Map from key to id and tier
+	keys map[string][2]string
+	used map[string]int64
+}
+
+func (f *fakeKeys) Lookup(key string) (string, string, bool) {
+	k, exists := f.keys[key]
+	return k[0], k[1], exists
+}
+
+func (f *fakeKeys) Use(id string) int64 {
+	f.used[id]++
+	return f.used[id]
+}
+
+func TestCheck(t *testing.T) {
+	config := Config{
+		Limits: Limits{Default: Limit{Rate: 1, Burst: 2}},
+		Tiers: map[string]Tier{
+			"standard": Tier{Limits: Limits{Default: Limit{Rate: 100, Burst: 100}}, DailyQuota: 3},
+			"limited":  Tier{Limits: Limits{Default: Limit{Rate: 1, Burst: 1}}},
+		},
+	}
+	keys := &fakeKeys{
+		keys: map[string][2]string{
+			"standard-key": {"k1", "standard"},
+			"limited-key":  {"k2", "limited"},
+			"unknown-tier": {"k3", "gold"},
+		},
+		used: map[string]int64{},
+	}
+	r := New(config, 0, keys)
+	// Requests are made in order against the same limiter
+	requests := []struct {
+		name   string
+		apiKey string
+		// Expected client and rejection message, empty if allowed
+		wantClient  string
+		wantMessage string
+	}{
+		{"no key within burst", "", "ip:192.0.2.1", ""},
+		{"no key burst used", "", "ip:192.0.2.1", ""},
+		{"no key over limit", "", "ip:192.0.2.1", "Rate limit reached"},
+		{"invalid key", "revoked-key", "", "Invalid API key"},
+		{"key within quota", "standard-key", "key:k1", ""},
+		{"key at quota", "standard-key", "key:k1", ""},
+		{"key quota used", "standard-key", "key:k1", ""},
+		{"key over quota", "standard-key", "key:k1", "Daily quota of 3 requests reached"},
+		{"key tier limit", "limited-key", "key:k2", ""},
+		{"key over tier limit", "limited-key", "key:k2", "Rate limit reached"},
+		{"unknown tier uses default limits", "unknown-tier", "key:k3", ""},
+		{"unknown tier burst used", "unknown-tier", "key:k3", ""},
+		{"unknown tier over limit", "unknown-tier", "key:k3", "Rate limit reached"},
+	}
+	for _, request := range requests {
+		client, rejected := r.check("/v1/items", request.apiKey, nil, "192.0.2.1:1234")
+		message := ""
+		if rejected != nil {
+			message = rejected.message
+			if request.wantMessage == "Daily quota of 3 requests reached" && (rejected.retryAfter <= 0 || rejected.retryAfter > 24*time.Hour) {
+				t.Errorf("%s: retry after %s, want until the end of the day", request.name, rejected.retryAfter)
+			}
+		}
+		if client != request.wantClient || message != request.wantMessage {
+			t.Errorf("%s: check returned client %q rejected with %q, want client %q rejected with %q",
+				request.name, client, message, request.wantClient, request.wantMessage)
+		}
+	}
+	if used := keys.used["k1"]; used != 4 {
+		t.Errorf("check used the key %d times, want every request within its rate limit counted (4)", used)
+	}
+
+	// Without a key store keys are ignored and clients are limited by address
+	r = New(config, 0, nil)
+	if client, rejected := r.check("/v1/items", "revoked-key", nil, "192.0.2.1:1234"); client != "ip:192.0.2.1" || rejected != nil {
+		t.Errorf("check without keys returned client %q rejected %v, want ip:192.0.2.1 allowed", client, rejected)
+	}
+}
+
+func TestAdminUnaryServerInterceptor(t *testing.T) {
+	keys := &fakeKeys{
+		keys: map[string][2]string{
+			"admin-key":    {"k1", AdminTier},
+			"standard-key": {"k2", DefaultTier},
+		},
+		used: map[string]int64{},
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "reply", nil
+	}
+	tests := []struct {
+		name   string
+		keys   KeyStore
+		method string
+		apiKey string
+		want   codes.Code
+	}{
+		{"admin key", keys, "/test.Service/Admin", "admin-key", codes.OK},
+		{"standard key", keys, "/test.Service/Admin", "standard-key", codes.PermissionDenied},
+		{"invalid key", keys, "/test.Service/Admin", "revoked-key", codes.PermissionDenied},
+		{"no key", keys, "/test.Service/Admin", "", codes.PermissionDenied},
+		{"without key store", nil, "/test.Service/Admin", "admin-key", codes.PermissionDenied},
+		{"other method without key", keys, "/test.Service/Public", "", codes.OK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			interceptor := AdminUnaryServerInterceptor(test.keys, "/test.Service/Admin")
+			ctx := context.Background()
+			if test.apiKey != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(APIKeyHeader, test.apiKey))
+			}
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: test.method}, handler)
+			if got := status.Code(err); got != test.want {
+				t.Errorf("interceptor returned %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package rpcmetrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+//
+// Request counts and latencies per RPC and transport
+//
+// grpc and grpc-web calls are measured by interceptors on the grpc servers
+// handling them. REST requests proxied through the gateway are measured on the
+// loopback grpc server, so their method is the grpc method and their code is
+// the grpc code.
+//
+
+// Transports
+const (
+	REST    = "rest"
+	GRPC    = "grpc"
+	GRPCWeb = "grpc-web"
+)
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rpc_requests_total",
+		Help: "Requests handled by transport, method and response code.",
+	}, []string{"transport", "method", "code"})
+	latency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "rpc_request_duration_seconds",
+		Help: "Time taken to handle requests, or how long streams were open.",
+	}, []string{"transport", "method"})
+)
+
+func observe(transport string, method string, code string, start time.Time) {
+	requests.WithLabelValues(transport, method, code).Inc()
+	latency.WithLabelValues(transport, method).Observe(time.Since(start).Seconds())
+}
+
+// UnaryServerInterceptor - Measures unary calls over transport
+func UnaryServerInterceptor(transport string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+		observe(transport, info.FullMethod, status.Code(err).String(), start)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor - Measures streaming calls over transport
+func StreamServerInterceptor(transport string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		observe(transport, info.FullMethod, status.Code(err).String(), start)
+		return err
+	}
+}
@@ -0,0 +1,149 @@
+package rpctracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/kv"
+	"go.opentelemetry.io/otel/api/propagation"
+	"go.opentelemetry.io/otel/api/standard"
+	"go.opentelemetry.io/otel/api/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//
+// Spans of HTTP requests and grpc calls
+//
+// Server interceptors continue the trace of the traceparent metadata sent by
+// clients, which grpc-web and the gateway pass through from HTTP headers. The
+// client interceptor is installed on the gateway's connection to the loopback
+// grpc server so the proxied call is a child of the REST request's span.
+// Spans are recorded with the global OpenTelemetry tracer and propagators, so
+// they are no-ops until cmd/web installs a trace provider.
+//
+
+const instrumentationName = "github.com/MichiganDiningAPI/internal/web/rpctracing"
+
+// Adapts grpc metadata to the propagators
+type metadataSupplier struct {
+	md metadata.MD
+}
+
+func (s metadataSupplier) Get(key string) string {
+	if values := s.md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (s metadataSupplier) Set(key string, value string) {
+	s.md.Set(key, value)
+}
+
+// Returns ctx with the remote parent of the incoming metadata
+func extract(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return propagation.ExtractHTTP(ctx, global.Propagators(), metadataSupplier{md})
+}
+
+func start(ctx context.Context, transport string, method string, kind trace.SpanKind) (context.Context, trace.Span) {
+	return global.Tracer(instrumentationName).Start(ctx, method, trace.WithSpanKind(kind), trace.WithAttributes(
+		kv.String("rpc.system", "grpc"), kv.String("rpc.method", method), kv.String("transport", transport)))
+}
+
+func end(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(kv.String("rpc.grpc.status_code", code.String()))
+	span.SetStatus(code, status.Convert(err).Message())
+	span.End()
+}
+
+// UnaryServerInterceptor - Traces unary calls over transport
+func UnaryServerInterceptor(transport string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := start(extract(ctx), transport, info.FullMethod, trace.SpanKindServer)
+		res, err := handler(ctx, req)
+		end(span, err)
+		return res, err
+	}
+}
+
+// Overrides the context of a stream with one carrying its span
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor - Traces streaming calls over transport, spanning the time the stream was open
+func StreamServerInterceptor(transport string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := start(extract(ss.Context()), transport, info.FullMethod, trace.SpanKindServer)
+		err := handler(srv, &tracedStream{ServerStream: ss, ctx: ctx})
+		end(span, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor - Traces outgoing unary calls and propagates their span to the server
+func UnaryClientInterceptor(transport string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := start(ctx, transport, method, trace.SpanKindClient)
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md = md.Copy()
+		propagation.InjectHTTP(ctx, global.Propagators(), metadataSupplier{md})
+		err := invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+		end(span, err)
+		return err
+	}
+}
+
+// Records the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush - Passes flushes through, which streaming grpc-web responses rely on
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// CloseNotify - Passes close notifications through, which grpc-web relies on
+func (r *statusRecorder) CloseNotify() <-chan bool {
+	if notifier, ok := r.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	// Never closes
+	return make(chan bool)
+}
+
+// HTTPHandler - Traces every request to handler with a server span continuing the trace of the request headers.
+// othttp.NewHandler is not used since its response writer hides Flush and CloseNotify from grpc-web.
+func HTTPHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		ctx := propagation.ExtractHTTP(req.Context(), global.Propagators(), req.Header)
+		ctx, span := global.Tracer(instrumentationName).Start(ctx, "HTTP "+req.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(standard.NetAttributesFromHTTPRequest("tcp", req)...),
+			trace.WithAttributes(standard.HTTPServerAttributesFromHTTPRequest("", "", req)...))
+		defer span.End()
+		recorder := &statusRecorder{ResponseWriter: resp, code: http.StatusOK}
+		handler.ServeHTTP(recorder, req.WithContext(ctx))
+		span.SetAttributes(standard.HTTPAttributesFromHTTPStatusCode(recorder.code)...)
+		span.SetStatus(standard.SpanStatusFromHTTPStatusCode(recorder.code))
+	})
+}